@@ -0,0 +1,260 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultCardWidth     = 1200
+	defaultCardHeight    = 630
+	defaultCardTitleSize = 64
+	defaultCardTextSize  = 36
+)
+
+type CardOptions struct {
+	Width      int
+	Height     int
+	Font       []byte
+	Background color.Color
+	Foreground color.Color
+	TitleSize  float64
+	TextSize   float64
+}
+
+type CardData struct {
+	Title    string
+	Name     string
+	Username string
+	Time     string
+	Avatar   image.Image
+}
+
+type CardRenderer struct {
+	mu        sync.Mutex
+	opts      CardOptions
+	titleFace font.Face
+	textFace  font.Face
+}
+
+func NewCardRenderer(opts CardOptions) (*CardRenderer, error) {
+	if opts.Width <= 0 {
+		opts.Width = defaultCardWidth
+	}
+	if opts.Height <= 0 {
+		opts.Height = defaultCardHeight
+	}
+	if opts.Font == nil {
+		opts.Font = goregular.TTF
+	}
+	if opts.Background == nil {
+		opts.Background = color.RGBA{R: 0x15, G: 0x20, B: 0x2b, A: 0xff}
+	}
+	if opts.Foreground == nil {
+		opts.Foreground = color.White
+	}
+	if opts.TitleSize <= 0 {
+		opts.TitleSize = defaultCardTitleSize
+	}
+	if opts.TextSize <= 0 {
+		opts.TextSize = defaultCardTextSize
+	}
+
+	// TTC などのフォントコレクションの場合は先頭のフォントを使用する
+	collection, err := opentype.ParseCollection(opts.Font)
+	if err != nil {
+		return nil, err
+	}
+	f, err := collection.Font(0)
+	if err != nil {
+		return nil, err
+	}
+	titleFace, err := opentype.NewFace(f, &opentype.FaceOptions{Size: opts.TitleSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	textFace, err := opentype.NewFace(f, &opentype.FaceOptions{Size: opts.TextSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CardRenderer{
+		opts:      opts,
+		titleFace: titleFace,
+		textFace:  textFace,
+	}, nil
+}
+
+// Render はスペースの告知用の画像を生成する
+func (r *CardRenderer) Render(data CardData) image.Image {
+	// font.Face は並行に使用できない
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, h := r.opts.Width, r.opts.Height
+	padding := h / 10
+	avatarSize := h / 5
+	fg := image.NewUniform(r.opts.Foreground)
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(r.opts.Background), image.Point{}, draw.Src)
+
+	// アイコン
+	avatarRect := image.Rect(padding, padding, padding+avatarSize, padding+avatarSize)
+	mask := &circle{size: avatarSize}
+	if data.Avatar != nil {
+		scaled := image.NewRGBA(image.Rect(0, 0, avatarSize, avatarSize))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), data.Avatar, data.Avatar.Bounds(), draw.Src, nil)
+		draw.DrawMask(img, avatarRect, scaled, image.Point{}, mask, image.Point{}, draw.Over)
+	} else {
+		draw.DrawMask(img, avatarRect, fg, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+
+	// 表示名・ユーザー名
+	textX := padding + avatarSize + padding/2
+	textWidth := w - textX - padding
+	lineHeight := r.textFace.Metrics().Height.Ceil()
+	nameY := padding + avatarSize/2
+	r.drawText(img, r.textFace, fg, textX, nameY, truncateWidth(r.textFace, data.Name, textWidth))
+	if data.Username != "" {
+		r.drawText(img, r.textFace, fg, textX, nameY+lineHeight, truncateWidth(r.textFace, "@"+data.Username, textWidth))
+	}
+
+	// 日時
+	timeY := h - padding
+	r.drawText(img, r.textFace, fg, padding, timeY, truncateWidth(r.textFace, data.Time, w-2*padding))
+
+	// タイトル
+	titleHeight := r.titleFace.Metrics().Height.Ceil()
+	titleTop := padding + avatarSize + padding/2
+	maxLines := (timeY - lineHeight - titleTop) / titleHeight
+	lines := wrapText(r.titleFace, data.Title, w-2*padding, maxLines)
+	for i, line := range lines {
+		y := titleTop + r.titleFace.Metrics().Ascent.Ceil() + i*titleHeight
+		r.drawText(img, r.titleFace, fg, padding, y, line)
+	}
+
+	return img
+}
+
+func (r *CardRenderer) RenderPNG(data CardData) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, r.Render(data)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *CardRenderer) drawText(dst draw.Image, face font.Face, src image.Image, x, y int, text string) {
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  src,
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// wrapText は幅に収まるよう文字単位で折り返し、行数を超える場合は末尾を省略する
+func wrapText(face font.Face, text string, width, maxLines int) []string {
+	if maxLines <= 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, r := range paragraph {
+			next := line + string(r)
+			if line != "" && font.MeasureString(face, next).Ceil() > width {
+				lines = append(lines, line)
+				line = string(r)
+			} else {
+				line = next
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] = truncateWidth(face, lines[maxLines-1]+ellipsis, width)
+	}
+	return lines
+}
+
+func truncateWidth(face font.Face, text string, width int) string {
+	if font.MeasureString(face, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(strings.TrimSuffix(text, ellipsis))
+	for n := len(runes); n > 0; n-- {
+		s := string(runes[:n]) + ellipsis
+		if font.MeasureString(face, s).Ceil() <= width {
+			return s
+		}
+	}
+	return ""
+}
+
+// circle はアイコンを円形に切り抜くマスク
+type circle struct {
+	size int
+}
+
+func (c *circle) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (c *circle) Bounds() image.Rectangle {
+	return image.Rect(0, 0, c.size, c.size)
+}
+
+func (c *circle) At(x, y int) color.Color {
+	r := float64(c.size) / 2
+	dx, dy := float64(x)+0.5-r, float64(y)+0.5-r
+	if dx*dx+dy*dy <= r*r {
+		return color.Alpha{A: 0xff}
+	}
+	return color.Alpha{}
+}
+
+// ParseColor は #rrggbb 形式の色を解析する
+func ParseColor(s string) (color.Color, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return nil, fmt.Errorf("invalid color: %s", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color: %s", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
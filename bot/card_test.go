@@ -0,0 +1,89 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package bot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRenderCard(t *testing.T) {
+	avatar := image.NewRGBA(image.Rect(0, 0, 48, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 48; x++ {
+			avatar.Set(x, y, color.RGBA{R: uint8(x * 5), G: uint8(y * 5), B: 0x80, A: 0xff})
+		}
+	}
+	data := CardData{
+		Title:    strings.Repeat("Space Title ", 20),
+		Name:     "UserName",
+		Username: "username",
+		Time:     "2021/10/01 21:00 JST",
+		Avatar:   avatar,
+	}
+
+	r1, err := NewCardRenderer(CardOptions{Width: 600, Height: 315})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := NewCardRenderer(CardOptions{Width: 600, Height: 315})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b1, err := r1.RenderPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := r2.RenderPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b3, err := r1.RenderPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b1, b2) || !bytes.Equal(b1, b3) {
+		t.Error("RenderPNG is not deterministic")
+	}
+
+	img, err := png.Decode(bytes.NewReader(b1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 600 || size.Y != 315 {
+		t.Errorf("RenderPNG, size: %v", size)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#1d9bf0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != (color.RGBA{R: 0x1d, G: 0x9b, B: 0xf0, A: 0xff}) {
+		t.Errorf("ParseColor, actual: %v", c)
+	}
+	if _, err := ParseColor("1d9bf0"); err == nil {
+		t.Error("ParseColor, expected error")
+	}
+}
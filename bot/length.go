@@ -0,0 +1,220 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kylemcc/twitter-text-go/extract"
+	"golang.org/x/text/unicode/norm"
+
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+const (
+	MaxTweetLength = 280
+	// t.co で短縮された URL の長さ
+	ShortURLLength = 23
+
+	ellipsis = "…"
+)
+
+type TooLongError struct {
+	Length int
+}
+
+func (e *TooLongError) Error() string {
+	return fmt.Sprintf("tweet length %d exceeds %d", e.Length, MaxTweetLength)
+}
+
+// 重み 1 で数える文字の範囲 (twitter-text v3)
+var lightRanges = [][2]rune{
+	{0x0000, 0x10FF},
+	{0x2000, 0x200D},
+	{0x2010, 0x201F},
+	{0x2032, 0x2037},
+}
+
+func runeWeight(r rune) int {
+	for _, lr := range lightRanges {
+		if lr[0] <= r && r <= lr[1] {
+			return 1
+		}
+	}
+	return 2
+}
+
+// TweetLength は Twitter の重み付けでツイートの長さを計算する
+// URL は t.co の長さ、CJK などは 2 文字として数える
+func TweetLength(text string) int {
+	text = norm.NFC.String(text)
+
+	length := 0
+	offset := 0
+	for _, url := range extract.ExtractUrls(text) {
+		length += weightedLength(text[offset:url.ByteRange.Start])
+		length += ShortURLLength
+		offset = url.ByteRange.Stop
+	}
+	length += weightedLength(text[offset:])
+
+	return length
+}
+
+func weightedLength(text string) int {
+	length := 0
+	joined := false
+	for _, r := range text {
+		switch {
+		case r == 0x200D:
+			// ZWJ で結合された絵文字は 1 つとして数える
+			joined = true
+			continue
+		case r == 0xFE0E || r == 0xFE0F || (0x1F3FB <= r && r <= 0x1F3FF):
+			// 異体字セレクタ・肌の色の修飾子
+			continue
+		case joined:
+			joined = false
+			continue
+		}
+		length += runeWeight(r)
+	}
+	return length
+}
+
+// Truncate は重み付けした長さが length 以下になるよう末尾を省略する
+func Truncate(length int, s string) string {
+	if weightedLength(s) <= length {
+		return s
+	}
+
+	limit := length - weightedLength(ellipsis)
+	sb := &strings.Builder{}
+	n := 0
+	for _, r := range s {
+		n += runeWeight(r)
+		if n > limit {
+			break
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteString(ellipsis)
+	return sb.String()
+}
+
+// RenderTweet はテンプレートを展開し、ツイートの上限を超える場合はスペースのタイトルを省略する
+func RenderTweet(message string, space *twitter2.Space, user *twitter2.User) (string, error) {
+	return NewMessageData(space, user).RenderTweet(message)
+}
+
+// RenderTweet はテンプレートを展開し、ツイートの上限を超える場合はスペースのタイトルを省略する
+func (d *MessageData) RenderTweet(message string) (string, error) {
+	text, err := d.Render(message)
+	if err != nil {
+		return "", err
+	}
+
+	length := TweetLength(text)
+	if length <= MaxTweetLength {
+		return text, nil
+	}
+
+	title := []rune(d.Space.Title)
+	data := *d
+	render := func(n int) (string, error) {
+		data.Space.Title = string(title[:n]) + ellipsis
+		return data.Render(message)
+	}
+
+	// 収まるタイトルの最大長を二分探索する
+	lo, hi := 0, len(title)
+	found := ""
+	for lo < hi {
+		mid := (lo + hi) / 2
+		t, err := render(mid)
+		if err != nil {
+			return "", err
+		}
+		if TweetLength(t) <= MaxTweetLength {
+			found = t
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	if found == "" {
+		return "", &TooLongError{Length: length}
+	}
+	return found, nil
+}
+
+// CheckTweetTemplate はタイトルを省略してもツイートの上限に収まらないテンプレートをエラーにする
+func CheckTweetTemplate(message string) error {
+	text, err := sampleMessageData().Render(message)
+	if err != nil {
+		return err
+	}
+	if length := TweetLength(text); length > MaxTweetLength {
+		return &TooLongError{Length: length}
+	}
+	return nil
+}
+
+// CheckTemplate は展開できないテンプレートをエラーにする
+func CheckTemplate(message string) error {
+	_, err := sampleMessageData().Render(message)
+	return err
+}
+
+// sampleMessageData はテンプレートの確認に使用する、各値が最大の長さの MessageData を返す
+func sampleMessageData() *MessageData {
+	now := time.Now()
+	state := "scheduled"
+	space := &twitter2.Space{
+		ID:             "1AbCdEfGhIjKl",
+		CreatorID:      "1234567890123456789",
+		Title:          ellipsis, // 省略したタイトルにも付く省略記号の分を含める
+		State:          &state,
+		ScheduledStart: &now,
+		StartedAt:      &now,
+		CreatedAt:      &now,
+		UpdatedAt:      &now,
+	}
+	// 表示名・ユーザー名が上限の長さの場合
+	user := &twitter2.User{
+		ID:       "1234567890123456789",
+		Name:     strings.Repeat("あ", 50),
+		Username: strings.Repeat("a", 15),
+	}
+
+	// 参加者数は桁数が最大の場合
+	data := NewMessageData(space, user)
+	data.PeakParticipants = 9999999
+	data.AverageParticipants = 9999999
+	data.Milestone = 9999999
+	// 一覧の要素のフィールドも確認できるようにする
+	data.Hosts = []twitter2.User{*user}
+	data.Speakers = []twitter2.User{*user}
+	data.Invited = []twitter2.User{*user}
+	data.Joined = *user
+	data.Role = "speaker"
+
+	return data
+}
@@ -0,0 +1,91 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+func TestTweetLength(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected int
+	}{
+		{"abc", 3},
+		{"あいう", 6},
+		{"abc https://twitter.com/i/spaces/1AbCdEfGhIjKl", 4 + ShortURLLength},
+		{"👨‍👩‍👧", 2},
+	}
+	for _, c := range cases {
+		if actual := TweetLength(c.text); actual != c.expected {
+			t.Errorf("TweetLength(%s), actual: %d, expected: %d", c.text, actual, c.expected)
+		}
+	}
+}
+
+func TestRenderTweet(t *testing.T) {
+	message := "{{.Space.Title}} {{.URL}}"
+	space := &twitter2.Space{
+		ID:    "spaceid",
+		Title: strings.Repeat("あ", 200),
+	}
+	user := &twitter2.User{}
+
+	actual, err := RenderTweet(message, space, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if length := TweetLength(actual); length > MaxTweetLength {
+		t.Errorf("RenderTweet, length: %d", length)
+	}
+	expected := strings.Repeat("あ", 127) + "… https://twitter.com/i/spaces/spaceid"
+	if actual != expected {
+		t.Errorf("RenderTweet, actual: %s, expected: %s", actual, expected)
+	}
+}
+
+func TestCheckTweetTemplate(t *testing.T) {
+	if err := CheckTweetTemplate("{{.User.Name}} {{.Space.Title}} {{.URL}}"); err != nil {
+		t.Error(err)
+	}
+	if err := CheckTweetTemplate("{{.PeakParticipants}} {{.AverageParticipants}}"); err != nil {
+		t.Error(err)
+	}
+	if err := CheckTweetTemplate(strings.Repeat("a", 281)); err == nil {
+		t.Error("CheckTweetTemplate, expected error")
+	}
+	// タイトルを省略しても省略記号 (重み 2) の分だけ上限を超える場合
+	if err := CheckTweetTemplate(strings.Repeat("a", 280) + "{{.Space.Title}}"); err == nil {
+		t.Error("CheckTweetTemplate with title, expected error")
+	}
+	if err := CheckTweetTemplate(strings.Repeat("a", 278) + "{{.Space.Title}}"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckTemplate(t *testing.T) {
+	if err := CheckTemplate(strings.Repeat("a", 281)); err != nil {
+		t.Error(err)
+	}
+	if err := CheckTemplate("{{.Unknown}}"); err == nil {
+		t.Error("CheckTemplate, expected error")
+	}
+}
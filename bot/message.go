@@ -17,6 +17,7 @@
 package bot
 
 import (
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -44,12 +45,86 @@ loop:
 	return strings.NewReplacer(reps...).Replace(message)
 }
 
+// FormatNumber は 3 桁ごとにカンマで区切った数値を返す
+func FormatNumber(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s
+}
+
+// MessageData はメッセージのテンプレートに渡す値
+// 参加者数は記録のあるスペースのみ、Milestone は参加者数の節目の通知のみ設定される
+// Hosts, Speakers, Invited は ResolveUsers で解決できたユーザーのみ含まれる
+// Joined, Role は他のユーザーのスペースへの参加の通知のみ設定され、Role は host または speaker になる
+type MessageData struct {
+	User                twitter2.User
+	Space               twitter2.Space
+	URL                 string
+	PeakParticipants    int64
+	AverageParticipants int64
+	Milestone           int64
+	Hosts               []twitter2.User
+	Speakers            []twitter2.User
+	Invited             []twitter2.User
+	Joined              twitter2.User
+	Role                string
+}
+
+func NewMessageData(space *twitter2.Space, user *twitter2.User) *MessageData {
+	return &MessageData{
+		Space: *space,
+		User:  *user,
+		URL:   twitter2.GetSpaceURL(space.ID),
+	}
+}
+
+// ResolveUsers はスペースのホスト・スピーカー・招待されたユーザーを users から設定する
+func (d *MessageData) ResolveUsers(users map[string]twitter2.User) {
+	d.Hosts = resolveUsers(d.Space.HostIds, users)
+	d.Speakers = resolveUsers(d.Space.SpeakerIDs, users)
+	d.Invited = resolveUsers(d.Space.InvitedUserIDs, users)
+}
+
+func resolveUsers(ids *[]string, users map[string]twitter2.User) []twitter2.User {
+	if ids == nil {
+		return nil
+	}
+	var resolved []twitter2.User
+	for _, id := range *ids {
+		if u, ok := users[id]; ok {
+			resolved = append(resolved, u)
+		}
+	}
+	return resolved
+}
+
+// Mentions はユーザーのスクリーンネームを空白区切りで並べ、メンションにならないようエスケープする
+func Mentions(users []twitter2.User) string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = "@" + u.Username
+	}
+	return EscapeMessage(strings.Join(names, " "))
+}
+
 func RenderTemplate(message string, space *twitter2.Space, user *twitter2.User) (string, error) {
-	url := twitter2.GetSpaceURL(space.ID)
+	return NewMessageData(space, user).Render(message)
+}
 
+// Render はテンプレートを展開する
+func (d *MessageData) Render(message string) (string, error) {
 	t, err := template.New("message").
 		Funcs(map[string]interface{}{
-			"escape": EscapeMessage,
+			"escape":   EscapeMessage,
+			"truncate": Truncate,
+			"number":   FormatNumber,
+			"mentions": Mentions,
 		}).
 		Parse(message)
 
@@ -58,16 +133,7 @@ func RenderTemplate(message string, space *twitter2.Space, user *twitter2.User)
 	}
 
 	sb := &strings.Builder{}
-	err = t.Execute(sb, struct {
-		User  twitter2.User
-		Space twitter2.Space
-		URL   string
-	}{
-		Space: *space,
-		User:  *user,
-		URL:   url,
-	})
-	if err != nil {
+	if err := t.Execute(sb, d); err != nil {
 		return "", err
 	}
 
@@ -54,3 +54,21 @@ func TestGetTweetMessage(t *testing.T) {
 		t.Errorf("GetTweetMessage, actual: %s, expected: %s", actual, expected)
 	}
 }
+
+func TestFormatNumber(t *testing.T) {
+	cases := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{1234567, "1,234,567"},
+		{-1000, "-1,000"},
+	}
+	for _, c := range cases {
+		if actual := FormatNumber(c.n); actual != c.expected {
+			t.Errorf("FormatNumber(%d), actual: %s, expected: %s", c.n, actual, c.expected)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAdminAddress = "127.0.0.1:18081"
+)
+
+func adminAddress(config *AdminConfig) string {
+	if config.Address != "" {
+		return config.Address
+	}
+	return defaultAdminAddress
+}
+
+// isLoopbackAddress は address がループバックアドレスでのみ待ち受ける場合に true を返す
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startAdminServer は起動中のプロセスを操作するための HTTP サーバーを開始する
+func (w *watcher) startAdminServer(config *AdminConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backup", w.adminHandler(http.MethodPost, w.handleBackup))
+	mux.HandleFunc("/purge", w.adminHandler(http.MethodPost, w.handlePurge))
+	mux.HandleFunc("/report", w.adminHandler(http.MethodGet, w.handleReport))
+	mux.HandleFunc("/watch", w.adminHandler(http.MethodPost, w.handleWatch))
+	mux.HandleFunc("/watched", w.adminHandler(http.MethodGet, w.handleWatched))
+
+	go func() {
+		address := adminAddress(config)
+		w.logger.Infow("start http server for admin", "address", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			w.logger.Errorw("http server for admin failed", "address", address, "error", err)
+		}
+	}()
+}
+
+func (w *watcher) adminHandler(method string, f func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(res http.ResponseWriter, r *http.Request) {
+		w.logger.Infow("admin access", "method", r.Method, "uri", r.RequestURI, "remote_addr", r.RemoteAddr)
+
+		if !authorizeAdmin(w.config.Admin.Token, r) {
+			w.logger.Warnw("admin unauthorized", "uri", r.RequestURI, "remote_addr", r.RemoteAddr)
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != method {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := f(r)
+		res.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.logger.Errorw("admin request error", "uri", r.RequestURI, "error", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(res).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(res).Encode(result)
+	}
+}
+
+// authorizeAdmin はトークンを設定した場合に Authorization ヘッダーのトークンを確認する
+func authorizeAdmin(token string, r *http.Request) bool {
+	if token == "" {
+		return true
+	}
+	expected := []byte("Bearer " + token)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+}
+
+// callAdmin は起動中のプロセスの管理用 API を呼び出す
+func callAdmin(config *AdminConfig, method, path string, params url.Values, out interface{}) error {
+	u := "http://" + adminAddress(config) + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	if config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return fmt.Errorf("admin api error: %s", e.Error)
+		}
+		return fmt.Errorf("admin api error status: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,174 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qitoi/space-watcher/db"
+)
+
+const (
+	defaultBackupInterval = 86400
+	defaultBackupKeep     = 7
+
+	backupPrefix     = "space-watcher-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102-150405.000000000"
+)
+
+var backupMu sync.Mutex
+
+type backupResult struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// backupDatabase はデータベースを起動したままバックアップし、古いバックアップを削除する
+func backupDatabase(dbClient db.Store, config *BackupConfig) (*backupResult, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if err := os.MkdirAll(config.Directory, 0700); err != nil {
+		return nil, err
+	}
+
+	// 同じ秒に作成しても衝突しないよう、ナノ秒まで含める
+	// 固定長のため、ファイル名の順序は作成順と一致する
+	name := backupPrefix + time.Now().Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(config.Directory, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup already exists: %s", path)
+	}
+	size, err := dbClient.Backup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := config.Keep
+	if keep <= 0 {
+		keep = defaultBackupKeep
+	}
+	if err := rotateBackups(config.Directory, keep); err != nil {
+		return nil, err
+	}
+
+	return &backupResult{Path: path, Size: size}, nil
+}
+
+func rotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+
+	// ファイル名の日時順に並べて古いものから削除する
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+func (w *watcher) handleBackup(*http.Request) (interface{}, error) {
+	if w.config.Database.Backup == nil {
+		return nil, errors.New("database.backup is not configured")
+	}
+	result, err := backupDatabase(w.dbClient, w.config.Database.Backup)
+	if err != nil {
+		return nil, err
+	}
+	w.logger.Infow("backup completed", "path", result.Path, "size", result.Size)
+	return result, nil
+}
+
+// startBackup は定期的にバックアップを作成する
+func (w *watcher) startBackup(ctx context.Context, config *BackupConfig) {
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultBackupInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			result, err := backupDatabase(w.dbClient, config)
+			if err != nil {
+				w.logger.Errorw("backup error", "error", err)
+				continue
+			}
+			w.logger.Infow("backup completed", "path", result.Path, "size", result.Size)
+		}
+	}()
+}
+
+// BackupDatabase はバックアップを作成する
+// 管理用サーバーが有効な場合は起動中のプロセスに依頼し、無効な場合はデータベースを直接開く
+func BackupDatabase(config *Config) error {
+	if config.Database.Backup == nil {
+		return errors.New("database.backup is not configured")
+	}
+
+	var result backupResult
+	if config.Admin.Enabled {
+		if err := callAdmin(&config.Admin, http.MethodPost, "/backup", nil, &result); err != nil {
+			return err
+		}
+	} else {
+		dbClient, err := openDatabase(&config.Database)
+		if err != nil {
+			return err
+		}
+		defer dbClient.Close()
+
+		r, err := backupDatabase(dbClient, config.Database.Backup)
+		if err != nil {
+			return err
+		}
+		result = *r
+	}
+
+	fmt.Printf("backup completed: %s (%d bytes)\n", result.Path, result.Size)
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/qitoi/space-watcher/bot"
+	"github.com/qitoi/space-watcher/db"
+)
+
+const (
+	defaultCardTimeFormat = "2006/01/02 15:04 MST"
+)
+
+func newCardRenderer(config *CardConfig) (*bot.CardRenderer, error) {
+	opts := bot.CardOptions{}
+	if config == nil {
+		return bot.NewCardRenderer(opts)
+	}
+
+	opts.Width = config.Width
+	opts.Height = config.Height
+	opts.TitleSize = config.TitleSize
+	opts.TextSize = config.TextSize
+
+	if config.Font != "" {
+		font, err := os.ReadFile(config.Font)
+		if err != nil {
+			return nil, err
+		}
+		opts.Font = font
+	}
+	if config.Background != "" {
+		c, err := bot.ParseColor(config.Background)
+		if err != nil {
+			return nil, err
+		}
+		opts.Background = c
+	}
+	if config.Foreground != "" {
+		c, err := bot.ParseColor(config.Foreground)
+		if err != nil {
+			return nil, err
+		}
+		opts.Foreground = c
+	}
+
+	return bot.NewCardRenderer(opts)
+}
+
+// uploadCard はスペースの画像を生成してアップロードし、メディア ID を返す
+func (w *watcher) uploadCard(ctx context.Context, status db.SpaceNotificationStatus, altTextTemplate string, message *bot.MessageData) (int64, error) {
+	space, user := &message.Space, &message.User
+	timeFormat := defaultCardTimeFormat
+	if w.config.Card != nil && w.config.Card.TimeFormat != "" {
+		timeFormat = w.config.Card.TimeFormat
+	}
+
+	data := bot.CardData{
+		Title:    space.Title,
+		Name:     user.Name,
+		Username: user.Username,
+	}
+	if status >= db.SpaceNotificationStatus_START && space.StartedAt != nil {
+		data.Time = space.StartedAt.Local().Format(timeFormat)
+	} else if space.ScheduledStart != nil {
+		data.Time = space.ScheduledStart.Local().Format(timeFormat)
+	}
+
+	if user.ProfileImageURL != nil {
+		avatar, err := fetchAvatar(ctx, *user.ProfileImageURL)
+		if err != nil {
+			// アイコンが取得できなくても画像は生成する
+			w.logger.Warnw("fetch avatar error", "url", *user.ProfileImageURL, "error", err)
+		}
+		data.Avatar = avatar
+	}
+
+	png, err := w.card.RenderPNG(data)
+	if err != nil {
+		return 0, err
+	}
+
+	mediaID, err := w.mediaClient.Upload(ctx, png, "image/png", "tweet_image")
+	if err != nil {
+		return 0, err
+	}
+
+	if altTextTemplate != "" {
+		altText, err := message.Render(altTextTemplate)
+		if err != nil {
+			return 0, err
+		}
+		if err := w.mediaClient.CreateMetadata(ctx, mediaID, altText); err != nil {
+			return 0, err
+		}
+	}
+
+	w.logger.Infow("card uploaded", "space_id", space.ID, "media_id", mediaID)
+	return mediaID, nil
+}
+
+func fetchAvatar(ctx context.Context, url string) (image.Image, error) {
+	// _normal は 48x48 のため大きいサイズを取得する
+	url = strings.Replace(url, "_normal.", "_400x400.", 1)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch avatar error status: %v", resp.StatusCode)
+	}
+
+	img, _, err := image.Decode(resp.Body)
+	return img, err
+}
@@ -18,17 +18,37 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v3"
+
+	"github.com/qitoi/space-watcher/bot"
+	"github.com/qitoi/space-watcher/db"
+	"github.com/qitoi/space-watcher/report"
+	"github.com/qitoi/space-watcher/rule"
 )
 
 type Config struct {
-	Twitter     TwitterConfig     `yaml:"twitter"`
-	Event       EventConfig       `yaml:"event"`
-	HealthCheck HealthCheckConfig `yaml:"healthcheck_server"`
-	Logger      LoggerConfig      `yaml:"logger"`
+	Twitter     TwitterConfig             `yaml:"twitter"`
+	Event       EventConfig               `yaml:"event"`
+	Routing     *RoutingConfig            `yaml:"routing,omitempty"`
+	Sinks       *SinksConfig              `yaml:"sinks,omitempty"`
+	Creators    map[string]*CreatorConfig `yaml:"creators,omitempty"`
+	Discovery   *DiscoveryConfig          `yaml:"discovery,omitempty"`
+	Search      *SearchConfig             `yaml:"search,omitempty"`
+	Links       *LinksConfig              `yaml:"links,omitempty"`
+	Card        *CardConfig               `yaml:"card,omitempty"`
+	Outbox      *OutboxConfig             `yaml:"outbox,omitempty"`
+	Database    DatabaseConfig            `yaml:"database,omitempty"`
+	Report      *ReportConfig             `yaml:"report,omitempty"`
+	HealthCheck HealthCheckConfig         `yaml:"healthcheck_server"`
+	Admin       AdminConfig               `yaml:"admin_server,omitempty"`
+	Logger      LoggerConfig              `yaml:"logger"`
 }
 
 type TwitterConfig struct {
@@ -45,19 +65,204 @@ type EventConfig struct {
 	Schedule       *EventItemConfig `yaml:"schedule,omitempty"`
 	ScheduleRemind *EventItemConfig `yaml:"schedule_remind,omitempty"`
 	Start          *EventItemConfig `yaml:"start,omitempty"`
+	End            *EventItemConfig `yaml:"end,omitempty"`
+	Milestone      *EventItemConfig `yaml:"milestone,omitempty"`
+	Joined         *EventItemConfig `yaml:"joined,omitempty"`
+	Stale          *StaleConfig     `yaml:"stale,omitempty"`
 }
 
 type EventItemConfig struct {
-	Before       int64 `yaml:"before,omitempty"`
+	Before       int64               `yaml:"before,omitempty"`
+	Thresholds   []int64             `yaml:"thresholds,omitempty"`
+	Notification *NotificationConfig `yaml:"notification,omitempty"`
+	Command      *CommandConfig      `yaml:"command,omitempty"`
+	Filter       *FilterConfig       `yaml:"filter,omitempty"`
+	QuietHours   *QuietHoursConfig   `yaml:"quiet_hours,omitempty"`
+}
+
+// CommandConfig は通知時に実行するコマンド
+// args はテンプレートとして展開する
+type CommandConfig struct {
+	Name             string   `yaml:"name"`
+	Args             []string `yaml:"args"`
+	WorkingDirectory string   `yaml:"working_directory"`
+	CaptureStderr    bool     `yaml:"capture_stderr"`
+}
+
+type NotificationConfig struct {
+	Message string `yaml:"message,omitempty"`
+	Card    *struct {
+		AltText string `yaml:"alt_text,omitempty"`
+	} `yaml:"card,omitempty"`
+}
+
+// FilterConfig はイベントを通知するスペースの条件
+// タイトルは include_title のいずれかに一致し、exclude_title のいずれにも一致しないものを通知する
+type FilterConfig struct {
+	IncludeTitle []string `yaml:"include_title,omitempty"`
+	ExcludeTitle []string `yaml:"exclude_title,omitempty"`
+	Lang         []string `yaml:"lang,omitempty"`
+	IsTicketed   *bool    `yaml:"is_ticketed,omitempty"`
+}
+
+// QuietHoursConfig は通知を控える時間帯
+// start から end までの間 (日をまたいでもよい) の配送は action に従って延期・破棄・実行する
+// action を省略した場合はイベントごとの既定の動作になる
+type QuietHoursConfig struct {
+	Start    string `yaml:"start"`
+	End      string `yaml:"end"`
+	Timezone string `yaml:"timezone,omitempty"`
+	Action   string `yaml:"action,omitempty"`
+}
+
+const (
+	QuietActionDefer = "defer"
+	QuietActionDrop  = "drop"
+	QuietActionSend  = "send"
+)
+
+// CreatorConfig はユーザーごとに上書きするイベントの設定
+// creators のキーはユーザー名またはユーザー ID
+type CreatorConfig struct {
+	Schedule       *CreatorEventConfig `yaml:"schedule,omitempty"`
+	ScheduleRemind *CreatorEventConfig `yaml:"schedule_remind,omitempty"`
+	Start          *CreatorEventConfig `yaml:"start,omitempty"`
+	End            *CreatorEventConfig `yaml:"end,omitempty"`
+	Milestone      *CreatorEventConfig `yaml:"milestone,omitempty"`
+	Joined         *CreatorEventConfig `yaml:"joined,omitempty"`
+}
+
+// CreatorEventConfig は event の設定を上書きする値
+// enabled を省略した場合は event の設定に従い、設定されていないイベントは通知しない
+// args は event のコマンドの引数を置き換える
+type CreatorEventConfig struct {
+	Enabled *bool    `yaml:"enabled,omitempty"`
+	Before  int64    `yaml:"before,omitempty"`
+	Message string   `yaml:"message,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+}
+
+// RoutingConfig はイベントを通知先に振り分ける規則
+// mode が first の場合は最初に一致した規則、all の場合は一致したすべての規則の通知先に通知する
+type RoutingConfig struct {
+	Mode  string       `yaml:"mode,omitempty"`
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+const (
+	RoutingModeFirst = "first"
+	RoutingModeAll   = "all"
+)
+
+// RuleConfig は条件式に一致したイベントの通知先
+// message を指定した場合は、イベントや通知先のテンプレートの代わりに使用する
+type RuleConfig struct {
+	Name    string   `yaml:"name,omitempty"`
+	Match   string   `yaml:"match,omitempty"`
+	Sinks   []string `yaml:"sinks"`
+	Message string   `yaml:"message,omitempty"`
+}
+
+// SinksConfig はツイート・コマンド以外の通知先
+type SinksConfig struct {
+	Discord *WebhookSinkConfig `yaml:"discord,omitempty"`
+	Slack   *WebhookSinkConfig `yaml:"slack,omitempty"`
+}
+
+// WebhookSinkConfig は Webhook で投稿する通知先
+// message を省略した場合はイベントの notification.message を使用する
+type WebhookSinkConfig struct {
+	URL     string `yaml:"url"`
+	Message string `yaml:"message,omitempty"`
+}
+
+// DiscoveryConfig は監視対象のユーザーが参加する他のユーザーのスペースを検索する設定
+type DiscoveryConfig struct {
+	Queries  []string `yaml:"queries"`
+	Interval int64    `yaml:"interval,omitempty"`
+}
+
+// SearchConfig はタイトルで検索したスペースをフォローしているユーザーのスペースと同様に通知する設定
+type SearchConfig struct {
+	Queries  []string `yaml:"queries"`
+	State    string   `yaml:"state,omitempty"`
+	Interval int64    `yaml:"interval,omitempty"`
+}
+
+const (
+	SearchStateAll       = "all"
+	SearchStateLive      = "live"
+	SearchStateScheduled = "scheduled"
+)
+
+// LinksConfig はフォローしているユーザーのツイートに含まれるスペースのリンクを通知する設定
+type LinksConfig struct {
+	Interval int64 `yaml:"interval,omitempty"`
+}
+
+type CardConfig struct {
+	Font       string  `yaml:"font,omitempty"`
+	Width      int     `yaml:"width,omitempty"`
+	Height     int     `yaml:"height,omitempty"`
+	Background string  `yaml:"background,omitempty"`
+	Foreground string  `yaml:"foreground,omitempty"`
+	TitleSize  float64 `yaml:"title_size,omitempty"`
+	TextSize   float64 `yaml:"text_size,omitempty"`
+	TimeFormat string  `yaml:"time_format,omitempty"`
+}
+
+type OutboxConfig struct {
+	MaxAttempts      int   `yaml:"max_attempts,omitempty"`
+	RetryInterval    int64 `yaml:"retry_interval,omitempty"`
+	MaxRetryInterval int64 `yaml:"max_retry_interval,omitempty"`
+}
+
+type DatabaseConfig struct {
+	Driver    string           `yaml:"driver,omitempty"`
+	Path      string           `yaml:"path,omitempty"`
+	Retention *RetentionConfig `yaml:"retention,omitempty"`
+	Backup    *BackupConfig    `yaml:"backup,omitempty"`
+}
+
+type BackupConfig struct {
+	Directory string `yaml:"directory"`
+	Interval  int64  `yaml:"interval,omitempty"`
+	Keep      int    `yaml:"keep,omitempty"`
+}
+
+type RetentionConfig struct {
+	MaxAge        int64 `yaml:"max_age"`
+	HistoryMaxAge int64 `yaml:"history_max_age,omitempty"`
+	Interval      int64 `yaml:"interval,omitempty"`
+}
+
+const (
+	StaleActionDelete = "delete"
+	StaleActionReply  = "reply"
+)
+
+type StaleConfig struct {
+	Canceled    *StaleItemConfig `yaml:"canceled,omitempty"`
+	Rescheduled *StaleItemConfig `yaml:"rescheduled,omitempty"`
+}
+
+type StaleItemConfig struct {
+	Action  string `yaml:"action"`
+	Message string `yaml:"message,omitempty"`
+}
+
+type ReportConfig struct {
+	Weekday      string `yaml:"weekday,omitempty"`
+	Time         string `yaml:"time,omitempty"`
+	Timezone     string `yaml:"timezone,omitempty"`
+	Period       int64  `yaml:"period,omitempty"`
+	Top          int    `yaml:"top,omitempty"`
 	Notification *struct {
-		Message string `yaml:"message,omitempty"`
+		Message string `yaml:"message"`
 	} `yaml:"notification,omitempty"`
-	Command *struct {
-		Name             string   `yaml:"name"`
-		Args             []string `yaml:"args"`
-		WorkingDirectory string   `yaml:"working_directory"`
-		CaptureStderr    bool     `yaml:"capture_stderr"`
-	} `yaml:"command,omitempty"`
+	Command *CommandConfig `yaml:"command,omitempty"`
+	// Sinks を省略した場合は notification をツイートし、command を実行する
+	Sinks []string `yaml:"sinks,omitempty"`
 }
 
 type HealthCheckConfig struct {
@@ -65,6 +270,12 @@ type HealthCheckConfig struct {
 	Port    *int `yaml:"port,omitempty"`
 }
 
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address,omitempty"`
+	Token   string `yaml:"token,omitempty"`
+}
+
 type LoggerConfig struct {
 	Level LogLevel `yaml:"level"`
 	Info  *string  `yaml:"info,omitempty"`
@@ -158,6 +369,9 @@ func CheckValidConfig(config *Config) error {
 			if notif.Message == "" {
 				return errors.New("invalid config: event.schedule.notification.message")
 			}
+			if err := bot.CheckTweetTemplate(notif.Message); err != nil {
+				return fmt.Errorf("invalid config: event.schedule.notification.message: %w", err)
+			}
 		}
 		if cmd := schedule.Command; cmd != nil {
 			if cmd.Name == "" {
@@ -178,6 +392,9 @@ func CheckValidConfig(config *Config) error {
 			if notif.Message == "" {
 				return errors.New("invalid config: event.schedule_remind.notification.message")
 			}
+			if err := bot.CheckTweetTemplate(notif.Message); err != nil {
+				return fmt.Errorf("invalid config: event.schedule_remind.notification.message: %w", err)
+			}
 		}
 		if cmd := scheduleRemind.Command; cmd != nil {
 			if cmd.Name == "" {
@@ -195,6 +412,9 @@ func CheckValidConfig(config *Config) error {
 			if notif.Message == "" {
 				return errors.New("invalid config: event.start.notification.message")
 			}
+			if err := bot.CheckTweetTemplate(notif.Message); err != nil {
+				return fmt.Errorf("invalid config: event.start.notification.message: %w", err)
+			}
 		}
 		if cmd := start.Command; cmd != nil {
 			if cmd.Name == "" {
@@ -206,6 +426,254 @@ func CheckValidConfig(config *Config) error {
 		}
 	}
 
+	// End
+	if end := config.Event.End; end != nil {
+		if notif := end.Notification; notif != nil {
+			if notif.Message == "" {
+				return errors.New("invalid config: event.end.notification.message")
+			}
+			if err := bot.CheckTweetTemplate(notif.Message); err != nil {
+				return fmt.Errorf("invalid config: event.end.notification.message: %w", err)
+			}
+		}
+		if cmd := end.Command; cmd != nil {
+			if cmd.Name == "" {
+				return errors.New("invalid config: event.end.command.name")
+			}
+			if cmd.WorkingDirectory == "" {
+				return errors.New("invalid config: event.end.command.working_directory")
+			}
+		}
+	}
+
+	// Milestone
+	if milestone := config.Event.Milestone; milestone != nil {
+		if len(milestone.Thresholds) == 0 {
+			return errors.New("invalid config: event.milestone.thresholds")
+		}
+		for _, threshold := range milestone.Thresholds {
+			if threshold <= 0 {
+				return errors.New("invalid config: event.milestone.thresholds")
+			}
+		}
+		if notif := milestone.Notification; notif != nil {
+			if notif.Message == "" {
+				return errors.New("invalid config: event.milestone.notification.message")
+			}
+			if err := bot.CheckTweetTemplate(notif.Message); err != nil {
+				return fmt.Errorf("invalid config: event.milestone.notification.message: %w", err)
+			}
+		}
+		if cmd := milestone.Command; cmd != nil {
+			if cmd.Name == "" {
+				return errors.New("invalid config: event.milestone.command.name")
+			}
+			if cmd.WorkingDirectory == "" {
+				return errors.New("invalid config: event.milestone.command.working_directory")
+			}
+		}
+	}
+
+	// Joined
+	if joined := config.Event.Joined; joined != nil {
+		if notif := joined.Notification; notif != nil {
+			if notif.Message == "" {
+				return errors.New("invalid config: event.joined.notification.message")
+			}
+			if err := bot.CheckTweetTemplate(notif.Message); err != nil {
+				return fmt.Errorf("invalid config: event.joined.notification.message: %w", err)
+			}
+		}
+		if cmd := joined.Command; cmd != nil {
+			if cmd.Name == "" {
+				return errors.New("invalid config: event.joined.command.name")
+			}
+			if cmd.WorkingDirectory == "" {
+				return errors.New("invalid config: event.joined.command.working_directory")
+			}
+		}
+	}
+
+	// Filter, QuietHours
+	items := []struct {
+		name string
+		item *EventItemConfig
+	}{
+		{"event.schedule", config.Event.Schedule},
+		{"event.schedule_remind", config.Event.ScheduleRemind},
+		{"event.start", config.Event.Start},
+		{"event.end", config.Event.End},
+		{"event.milestone", config.Event.Milestone},
+		{"event.joined", config.Event.Joined},
+	}
+	for _, i := range items {
+		if i.item == nil {
+			continue
+		}
+		if err := checkFilterConfig(i.item.Filter, i.name+".filter"); err != nil {
+			return err
+		}
+		if err := checkQuietHoursConfig(i.item.QuietHours, i.name+".quiet_hours"); err != nil {
+			return err
+		}
+	}
+
+	// Creators
+	if err := checkCreatorKeys(config.Creators); err != nil {
+		return err
+	}
+	for key, creator := range config.Creators {
+		if err := checkCreatorConfig(&config.Event, creator, "creators."+key); err != nil {
+			return err
+		}
+	}
+
+	// Sinks
+	if sinks := config.Sinks; sinks != nil {
+		if err := checkWebhookSinkConfig(sinks.Discord, "sinks.discord"); err != nil {
+			return err
+		}
+		if err := checkWebhookSinkConfig(sinks.Slack, "sinks.slack"); err != nil {
+			return err
+		}
+	}
+
+	// Routing
+	if routing := config.Routing; routing != nil {
+		if err := checkRoutingConfig(config); err != nil {
+			return err
+		}
+	}
+
+	// Stale
+	if stale := config.Event.Stale; stale != nil {
+		if err := checkStaleItemConfig(stale.Canceled, "event.stale.canceled"); err != nil {
+			return err
+		}
+		if err := checkStaleItemConfig(stale.Rescheduled, "event.stale.rescheduled"); err != nil {
+			return err
+		}
+	}
+
+	// Discovery
+	if discovery := config.Discovery; discovery != nil {
+		if config.Event.Joined == nil {
+			return errors.New("invalid config: event.joined")
+		}
+		if len(discovery.Queries) == 0 {
+			return errors.New("invalid config: discovery.queries")
+		}
+		for _, q := range discovery.Queries {
+			if q == "" {
+				return errors.New("invalid config: discovery.queries")
+			}
+		}
+		if discovery.Interval < 0 {
+			return errors.New("invalid config: discovery.interval")
+		}
+	}
+
+	// Search
+	if search := config.Search; search != nil {
+		if len(search.Queries) == 0 {
+			return errors.New("invalid config: search.queries")
+		}
+		for _, q := range search.Queries {
+			if q == "" {
+				return errors.New("invalid config: search.queries")
+			}
+		}
+		switch search.State {
+		case "", SearchStateAll, SearchStateLive, SearchStateScheduled:
+		default:
+			return errors.New("invalid config: search.state")
+		}
+		if search.Interval < 0 {
+			return errors.New("invalid config: search.interval")
+		}
+	}
+
+	// Links
+	if links := config.Links; links != nil {
+		if links.Interval < 0 {
+			return errors.New("invalid config: links.interval")
+		}
+	}
+
+	// Card
+	if card := config.Card; card != nil {
+		if card.Width < 0 || card.Height < 0 {
+			return errors.New("invalid config: card.width, card.height")
+		}
+		if card.Background != "" {
+			if _, err := bot.ParseColor(card.Background); err != nil {
+				return fmt.Errorf("invalid config: card.background: %w", err)
+			}
+		}
+		if card.Foreground != "" {
+			if _, err := bot.ParseColor(card.Foreground); err != nil {
+				return fmt.Errorf("invalid config: card.foreground: %w", err)
+			}
+		}
+	}
+
+	// Outbox
+	if outbox := config.Outbox; outbox != nil {
+		if outbox.MaxAttempts < 0 {
+			return errors.New("invalid config: outbox.max_attempts")
+		}
+		if outbox.RetryInterval < 0 {
+			return errors.New("invalid config: outbox.retry_interval")
+		}
+		if outbox.MaxRetryInterval < 0 {
+			return errors.New("invalid config: outbox.max_retry_interval")
+		}
+	}
+
+	// Database
+	switch config.Database.Driver {
+	case "", db.DriverBolt, db.DriverSQLite, db.DriverMemory:
+	default:
+		return errors.New("invalid config: database.driver")
+	}
+
+	if retention := config.Database.Retention; retention != nil {
+		if retention.MaxAge <= 0 {
+			return errors.New("invalid config: database.retention.max_age")
+		}
+		if retention.HistoryMaxAge < 0 {
+			return errors.New("invalid config: database.retention.history_max_age")
+		}
+		if retention.Interval < 0 {
+			return errors.New("invalid config: database.retention.interval")
+		}
+	}
+
+	if backup := config.Database.Backup; backup != nil {
+		if backup.Directory == "" {
+			return errors.New("invalid config: database.backup.directory")
+		}
+		if backup.Interval < 0 {
+			return errors.New("invalid config: database.backup.interval")
+		}
+		if backup.Keep < 0 {
+			return errors.New("invalid config: database.backup.keep")
+		}
+	}
+
+	// Report
+	if conf := config.Report; conf != nil {
+		if err := checkReportConfig(conf, config.Sinks); err != nil {
+			return err
+		}
+	}
+
+	// Admin
+	// 外部から接続できるアドレスではトークンを必須にする
+	if config.Admin.Enabled && config.Admin.Token == "" && !isLoopbackAddress(adminAddress(&config.Admin)) {
+		return errors.New("invalid config: admin_server.token")
+	}
+
 	// HealthCheck
 	if config.HealthCheck.Enabled && config.HealthCheck.Port == nil {
 		return errors.New("config not found: healthcheck.port")
@@ -228,3 +696,311 @@ func CheckValidConfig(config *Config) error {
 
 	return nil
 }
+
+func checkStaleItemConfig(item *StaleItemConfig, name string) error {
+	if item == nil {
+		return nil
+	}
+	switch item.Action {
+	case StaleActionDelete:
+		break
+	case StaleActionReply:
+		if item.Message == "" {
+			return errors.New("invalid config: " + name + ".message")
+		}
+		if err := bot.CheckTweetTemplate(item.Message); err != nil {
+			return fmt.Errorf("invalid config: %s.message: %w", name, err)
+		}
+	default:
+		return errors.New("invalid config: " + name + ".action")
+	}
+	return nil
+}
+
+func checkFilterConfig(filter *FilterConfig, name string) error {
+	_, err := compileFilter(filter, name)
+	return err
+}
+
+func checkQuietHoursConfig(conf *QuietHoursConfig, name string) error {
+	if conf == nil {
+		return nil
+	}
+	start, err := time.Parse(quietHoursFormat, conf.Start)
+	if err != nil {
+		return errors.New("invalid config: " + name + ".start")
+	}
+	end, err := time.Parse(quietHoursFormat, conf.End)
+	if err != nil || end.Equal(start) {
+		return errors.New("invalid config: " + name + ".end")
+	}
+	if _, err := quietLocation(conf); err != nil {
+		return errors.New("invalid config: " + name + ".timezone")
+	}
+	switch conf.Action {
+	case "", QuietActionDrop, QuietActionSend, QuietActionDefer:
+	default:
+		return errors.New("invalid config: " + name + ".action")
+	}
+	return nil
+}
+
+// checkCreatorKeys は大文字と小文字を区別しないと同じになるキーを拒否する
+// ユーザー名は大文字と小文字を区別せずに照合するため、どちらの設定を使うか決まらない
+func checkCreatorKeys(creators map[string]*CreatorConfig) error {
+	keys := make([]string, 0, len(creators))
+	for key := range creators {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := make(map[string]string)
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+		if other, ok := seen[lower]; ok {
+			return errors.New("invalid config: creators." + key + ": creators." + other)
+		}
+		seen[lower] = key
+	}
+	return nil
+}
+
+func checkCreatorConfig(event *EventConfig, conf *CreatorConfig, name string) error {
+	if conf == nil {
+		return errors.New("invalid config: " + name)
+	}
+	items := []struct {
+		name     string
+		item     *CreatorEventConfig
+		global   *EventItemConfig
+		required bool
+	}{
+		{"schedule", conf.Schedule, event.Schedule, false},
+		{"schedule_remind", conf.ScheduleRemind, event.ScheduleRemind, false},
+		{"start", conf.Start, event.Start, false},
+		{"end", conf.End, event.End, false},
+		// 節目と参加の通知は event の設定が必要
+		{"milestone", conf.Milestone, event.Milestone, true},
+		{"joined", conf.Joined, event.Joined, true},
+	}
+	for _, i := range items {
+		if i.item == nil {
+			continue
+		}
+		itemName := name + "." + i.name
+		enabled := i.item.Enabled != nil && *i.item.Enabled
+		if enabled && i.global == nil && i.required {
+			return errors.New("invalid config: " + itemName + ".enabled: event." + i.name)
+		}
+		if i.item.Before < 0 {
+			return errors.New("invalid config: " + itemName + ".before")
+		}
+		if enabled && i.global == nil && i.name == "schedule_remind" && i.item.Before == 0 {
+			return errors.New("invalid config: " + itemName + ".before")
+		}
+		// event の設定がない場合はテンプレートがないと通知できない
+		if enabled && i.global == nil && i.item.Message == "" {
+			return errors.New("invalid config: " + itemName + ".message")
+		}
+		if len(i.item.Args) > 0 && (i.global == nil || i.global.Command == nil) {
+			return errors.New("invalid config: " + itemName + ".args: event." + i.name + ".command")
+		}
+		if i.item.Message != "" {
+			if err := bot.CheckTweetTemplate(i.item.Message); err != nil {
+				return fmt.Errorf("invalid config: %s.message: %w", itemName, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkWebhookSinkConfig(conf *WebhookSinkConfig, name string) error {
+	if conf == nil {
+		return nil
+	}
+	if conf.URL == "" {
+		return errors.New("invalid config: " + name + ".url")
+	}
+	if conf.Message != "" {
+		if err := bot.CheckTemplate(conf.Message); err != nil {
+			return fmt.Errorf("invalid config: %s.message: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func checkRoutingConfig(config *Config) error {
+	conf, sinks := config.Routing, config.Sinks
+	switch conf.Mode {
+	case "", RoutingModeFirst, RoutingModeAll:
+	default:
+		return errors.New("invalid config: routing.mode")
+	}
+	if len(conf.Rules) == 0 {
+		return errors.New("invalid config: routing.rules")
+	}
+
+	for i, r := range conf.Rules {
+		name := fmt.Sprintf("routing.rules[%d]", i)
+		expr, err := compileRule(r.Match)
+		if err != nil {
+			return fmt.Errorf("invalid config: %s.match: %w", name, err)
+		}
+		if len(r.Sinks) == 0 {
+			return errors.New("invalid config: " + name + ".sinks")
+		}
+		tweet := false
+		for _, sink := range r.Sinks {
+			switch sink {
+			case sinkTweet:
+				tweet = true
+			case sinkCommand:
+			case sinkDiscord:
+				if sinks == nil || sinks.Discord == nil {
+					return errors.New("invalid config: " + name + ".sinks: sinks.discord")
+				}
+			case sinkSlack:
+				if sinks == nil || sinks.Slack == nil {
+					return errors.New("invalid config: " + name + ".sinks: sinks.slack")
+				}
+			default:
+				return errors.New("invalid config: " + name + ".sinks: " + sink)
+			}
+		}
+		if r.Message != "" {
+			check := bot.CheckTemplate
+			if tweet {
+				check = bot.CheckTweetTemplate
+			}
+			if err := check(r.Message); err != nil {
+				return fmt.Errorf("invalid config: %s.message: %w", name, err)
+			}
+		}
+
+		// 規則に一致しうるイベントごとに、通知先のコマンドとテンプレートが決まることを確認する
+		for status := db.SpaceNotificationStatus_SCHEDULE; status <= db.SpaceNotificationStatus_JOINED; status++ {
+			if !expr.MayMatch(rule.Env{"event": eventNames[status]}) {
+				continue
+			}
+			for _, c := range routedEventConfigs(config, status) {
+				if err := checkRoutedSinks(r, c.item, sinks); err != "" {
+					return errors.New("invalid config: " + name + ".sinks: " + c.name + err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type routedEventConfig struct {
+	name string
+	item *EventItemConfig
+}
+
+// routedEventConfigs は通知される可能性のあるイベントの設定を、作成者ごとの設定を適用したものも含めて返す
+// 開始は event の設定がなくても通知する
+func routedEventConfigs(config *Config, status db.SpaceNotificationStatus) []routedEventConfig {
+	event := eventNames[status]
+	item, _ := eventItemConfig(&config.Event, status)
+
+	var configs []routedEventConfig
+	if item != nil || status == db.SpaceNotificationStatus_START {
+		configs = append(configs, routedEventConfig{"event." + event, item})
+	}
+	keys := make([]string, 0, len(config.Creators))
+	for key := range config.Creators {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		override := config.Creators[key].event(status)
+		if override == nil {
+			continue
+		}
+		if merged := mergeEventConfig(item, override); merged != nil {
+			configs = append(configs, routedEventConfig{"creators." + key + "." + event, merged})
+		}
+	}
+	return configs
+}
+
+// checkRoutedSinks は規則の通知先に通知できない場合に、不足している設定の名前を返す
+func checkRoutedSinks(r RuleConfig, item *EventItemConfig, sinks *SinksConfig) string {
+	message := r.Message
+	if message == "" && item != nil && item.Notification != nil {
+		message = item.Notification.Message
+	}
+	for _, sink := range r.Sinks {
+		switch sink {
+		case sinkCommand:
+			if item == nil || item.Command == nil {
+				return ".command"
+			}
+		case sinkTweet:
+			if message == "" {
+				return ".notification.message"
+			}
+		case sinkDiscord, sinkSlack:
+			if message == "" && (sinks == nil || sinks.webhook(sink).Message == "") {
+				return ".notification.message"
+			}
+		}
+	}
+	return ""
+}
+
+func checkReportConfig(conf *ReportConfig, sinks *SinksConfig) error {
+	// 定期投稿は曜日と時刻の両方を指定した場合に有効
+	if conf.Weekday != "" || conf.Time != "" {
+		if _, err := parseWeekday(conf.Weekday); err != nil {
+			return errors.New("invalid config: report.weekday")
+		}
+		if _, err := time.Parse(reportTimeFormat, conf.Time); err != nil {
+			return errors.New("invalid config: report.time")
+		}
+	}
+	if _, err := reportLocation(conf); err != nil {
+		return errors.New("invalid config: report.timezone")
+	}
+	if conf.Period < 0 {
+		return errors.New("invalid config: report.period")
+	}
+	if conf.Top < 0 {
+		return errors.New("invalid config: report.top")
+	}
+	if conf.Notification != nil {
+		if err := report.CheckTemplate(conf.Notification.Message); err != nil {
+			return errors.New("invalid config: report.notification.message")
+		}
+	}
+	if conf.Command != nil && conf.Command.Name == "" {
+		return errors.New("invalid config: report.command.name")
+	}
+
+	for _, sink := range reportSinks(conf) {
+		switch sink {
+		case sinkTweet, sinkDiscord, sinkSlack:
+			if conf.Notification == nil || conf.Notification.Message == "" {
+				return errors.New("invalid config: report.notification.message")
+			}
+		case sinkCommand:
+			if conf.Command == nil {
+				return errors.New("invalid config: report.command")
+			}
+		default:
+			return errors.New("invalid config: report.sinks: unknown sink: " + sink)
+		}
+
+		switch sink {
+		case sinkTweet:
+			// ホストを省略してもツイートに収まらないテンプレートはエラーにする
+			if err := report.CheckTweetTemplate(conf.Notification.Message); err != nil {
+				return fmt.Errorf("invalid config: report.notification.message: %w", err)
+			}
+		case sinkDiscord, sinkSlack:
+			if sinks == nil || sinks.webhook(sink) == nil {
+				return errors.New("invalid config: report.sinks: sink is not configured: " + sink)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+// getCreatorConfig はユーザー ID またはユーザー名に対する設定を返す
+// ユーザー名は大文字と小文字を区別しない
+// 区別しないと同じになるキーは設定の検証で拒否するため、一致するキーは一つに決まる
+func (w *watcher) getCreatorConfig(user *twitter2.User) *CreatorConfig {
+	if user == nil || len(w.config.Creators) == 0 {
+		return nil
+	}
+	if conf, ok := w.config.Creators[user.ID]; ok {
+		return conf
+	}
+	if user.Username == "" {
+		return nil
+	}
+	for key, conf := range w.config.Creators {
+		if strings.EqualFold(key, user.Username) {
+			return conf
+		}
+	}
+	return nil
+}
+
+// getCreatorEventConfig はユーザーごとに上書きするイベントの設定を返す
+func (w *watcher) getCreatorEventConfig(status db.SpaceNotificationStatus, user *twitter2.User) *CreatorEventConfig {
+	return w.getCreatorConfig(user).event(status)
+}
+
+// event は通知ステータスに対するイベントの設定を返す
+func (c *CreatorConfig) event(status db.SpaceNotificationStatus) *CreatorEventConfig {
+	if c == nil {
+		return nil
+	}
+	switch status {
+	case db.SpaceNotificationStatus_SCHEDULE:
+		return c.Schedule
+	case db.SpaceNotificationStatus_SCHEDULE_REMIND:
+		return c.ScheduleRemind
+	case db.SpaceNotificationStatus_START:
+		return c.Start
+	case db.SpaceNotificationStatus_END:
+		return c.End
+	case db.SpaceNotificationStatus_MILESTONE:
+		return c.Milestone
+	case db.SpaceNotificationStatus_JOINED:
+		return c.Joined
+	}
+	return nil
+}
+
+// mergeEventConfig はイベントの設定にユーザーごとの設定を適用する
+// 元の設定は変更しない
+func mergeEventConfig(conf *EventItemConfig, override *CreatorEventConfig) *EventItemConfig {
+	if override == nil {
+		return conf
+	}
+	if override.Enabled != nil && !*override.Enabled {
+		return nil
+	}
+	if conf == nil && override.Enabled == nil {
+		return nil
+	}
+
+	merged := &EventItemConfig{}
+	if conf != nil {
+		*merged = *conf
+	}
+	if override.Before > 0 {
+		merged.Before = override.Before
+	}
+	if override.Message != "" {
+		notification := &NotificationConfig{}
+		if merged.Notification != nil {
+			*notification = *merged.Notification
+		}
+		notification.Message = override.Message
+		merged.Notification = notification
+	}
+	if len(override.Args) > 0 && merged.Command != nil {
+		command := *merged.Command
+		command.Args = override.Args
+		merged.Command = &command
+	}
+	return merged
+}
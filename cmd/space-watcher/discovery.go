@@ -0,0 +1,165 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+const (
+	defaultDiscoveryInterval = 300
+
+	joinedRoleHost    = "host"
+	joinedRoleSpeaker = "speaker"
+)
+
+// startDiscovery は監視対象のユーザーが参加する他のユーザーのスペースを定期的に検索する
+// 一度参加を通知したスペースは記録されるため、以降は終了まで ID で状態を確認する
+// 間隔は検索と共有する spaces/search のレート制限に合わせて延ばす
+func (w *watcher) startDiscovery(ctx context.Context, conf *DiscoveryConfig) {
+	baseInterval := conf.Interval
+	if baseInterval <= 0 {
+		baseInterval = defaultDiscoveryInterval
+	}
+	interval := baseInterval
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			var rate *twitter2.RateLimit
+			for _, query := range conf.Queries {
+				r, err := w.discoverSpaces(ctx, query)
+				if r != nil {
+					rate = r
+				}
+				if err != nil {
+					w.logger.Errorw("discovery error", "query", query, "error", err)
+				}
+			}
+
+			if nextInterval := pollInterval(rate, baseInterval, interval, w.spacesSearchRequests()); nextInterval != interval {
+				interval = nextInterval
+				ticker.Reset(time.Duration(interval) * time.Second)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (w *watcher) discoverSpaces(ctx context.Context, query string) (*twitter2.RateLimit, error) {
+	resp, rate, err := w.clientV2.SearchSpaces(ctx, twitter2.SpacesSearchRequest{
+		Query:       query,
+		Expansions:  spaceExpansions,
+		SpaceFields: spaceFields,
+		UserFields:  userFields,
+	})
+	if err != nil {
+		return rate, err
+	}
+	w.logger.Infow("discovery result", "query", query, "spaces", len(resp.Data), "rate", rate)
+
+	users := make(map[string]twitter2.User)
+	if resp.Includes != nil && resp.Includes.Users != nil {
+		for _, u := range *resp.Includes.Users {
+			users[u.ID] = u
+		}
+	}
+
+	for _, s := range resp.Data {
+		space := s
+		if err := w.processJoined(&space, users); err != nil {
+			return rate, err
+		}
+	}
+	return rate, nil
+}
+
+// processJoined は監視対象のユーザーが他のユーザーのスペースにホスト・スピーカーとして参加したことを通知する
+// 参加はユーザーごとに 1 度だけ通知する
+func (w *watcher) processJoined(space *twitter2.Space, users map[string]twitter2.User) error {
+	if w.config.Event.Joined == nil || w.watched[space.CreatorID] {
+		return nil
+	}
+	if space.State == nil || (*space.State != db.StateLive && *space.State != db.StateScheduled) {
+		return nil
+	}
+
+	roles := []struct {
+		role string
+		ids  *[]string
+	}{
+		{joinedRoleHost, space.HostIds},
+		{joinedRoleSpeaker, space.SpeakerIDs},
+	}
+	for _, r := range roles {
+		if r.ids == nil {
+			continue
+		}
+		for _, id := range *r.ids {
+			if !w.watched[id] {
+				continue
+			}
+			claimed, err := w.dbClient.ClaimJoined(space.ID, id)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				continue
+			}
+			if err := w.notifyJoined(space, users, users[id], r.role); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *watcher) notifyJoined(space *twitter2.Space, users map[string]twitter2.User, joined twitter2.User, role string) error {
+	w.logger.Infow("joined", "space_id", space.ID, "user_id", joined.ID, "role", role)
+
+	// 終了まで状態を確認できるよう、参加を通知したスペースを記録する
+	if err := w.dbClient.SetState(space.ID, *space.State); err != nil {
+		return err
+	}
+
+	creator, ok := users[space.CreatorID]
+	if !ok {
+		creator = twitter2.User{ID: space.CreatorID}
+	}
+
+	if reason := w.rejectReason(db.SpaceNotificationStatus_JOINED, space, &creator); reason != "" {
+		w.logger.Infow("space filtered", "space_id", space.ID, "status", db.SpaceNotificationStatus_JOINED, "title", space.Title, "reason", reason)
+		return nil
+	}
+	return w.enqueueSnapshot(db.SpaceNotificationStatus_JOINED, joined.ID, &spaceSnapshot{
+		Space:  *space,
+		Users:  relatedUsers(space, users),
+		Joined: &joined,
+		Role:   role,
+	}, &creator)
+}
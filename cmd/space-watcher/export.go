@@ -0,0 +1,276 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/qitoi/space-watcher/db"
+)
+
+const (
+	exportFormatJSONL = "jsonl"
+	exportFormatCSV   = "csv"
+
+	exportKindSpace = "space"
+	exportKindEvent = "event"
+)
+
+var csvHeader = []string{"kind", "id", "record"}
+
+type exportRecord struct {
+	Kind   string          `json:"kind"`
+	ID     string          `json:"id"`
+	Record json.RawMessage `json:"record"`
+}
+
+type recordWriter interface {
+	Write(r *exportRecord) error
+	Flush() error
+}
+
+type recordReader interface {
+	Read() (*exportRecord, error)
+}
+
+// ExportDatabase はスペースの記録と履歴を JSON Lines または CSV で出力する
+func ExportDatabase(config *Config, args []string) error {
+	flags := pflag.NewFlagSet("export", pflag.ContinueOnError)
+	format := flags.StringP("format", "f", exportFormatJSONL, "output format (jsonl, csv)")
+	output := flags.StringP("output", "o", "", "output file (default: stdout)")
+	history := flags.Bool("history", true, "include event history")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	w, err := newRecordWriter(*format, out)
+	if err != nil {
+		return err
+	}
+
+	dbClient, err := openDatabase(&config.Database)
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	marshal := protojson.MarshalOptions{UseProtoNames: true}
+
+	err = dbClient.ForEachSpace(func(s *db.Space) error {
+		data, err := marshal.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return w.Write(&exportRecord{Kind: exportKindSpace, ID: s.Id, Record: data})
+	})
+	if err != nil {
+		return err
+	}
+
+	if *history {
+		err = dbClient.ForEachEvent(func(id string, e *db.Event) error {
+			data, err := marshal.Marshal(e)
+			if err != nil {
+				return err
+			}
+			return w.Write(&exportRecord{Kind: exportKindEvent, ID: id, Record: data})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// ImportDatabase は ExportDatabase で出力した記録を取り込む
+// スペースは ID で統合し、通知ステータスを戻さないため取り込み後に古いスペースを再通知しない
+func ImportDatabase(config *Config, args []string) error {
+	flags := pflag.NewFlagSet("import", pflag.ContinueOnError)
+	format := flags.StringP("format", "f", "", "input format (jsonl, csv) (default: by file extension)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("input file is required")
+	}
+
+	name := flags.Arg(0)
+	if *format == "" {
+		*format = exportFormatJSONL
+		if strings.HasSuffix(name, ".csv") {
+			*format = exportFormatCSV
+		}
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, err := newRecordReader(*format, file)
+	if err != nil {
+		return err
+	}
+
+	dbClient, err := openDatabase(&config.Database)
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	spaces, events := 0, 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch record.Kind {
+		case exportKindSpace:
+			var s db.Space
+			if err := protojson.Unmarshal(record.Record, &s); err != nil {
+				return err
+			}
+			if err := dbClient.MergeSpace(&s); err != nil {
+				return err
+			}
+			spaces++
+		case exportKindEvent:
+			var e db.Event
+			if err := protojson.Unmarshal(record.Record, &e); err != nil {
+				return err
+			}
+			if err := dbClient.ImportEvent(record.ID, &e); err != nil {
+				return err
+			}
+			events++
+		default:
+			return fmt.Errorf("unknown record kind: %s", record.Kind)
+		}
+	}
+
+	fmt.Printf("imported %d spaces, %d events\n", spaces, events)
+	return nil
+}
+
+func newRecordWriter(format string, w io.Writer) (recordWriter, error) {
+	switch format {
+	case exportFormatJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case exportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	}
+	return nil, errors.New("unknown format: " + format)
+}
+
+func newRecordReader(format string, r io.Reader) (recordReader, error) {
+	switch format {
+	case exportFormatJSONL:
+		return &jsonlReader{dec: json.NewDecoder(r)}, nil
+	case exportFormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+			return nil, errors.New("invalid csv header")
+		}
+		return &csvReader{r: cr}, nil
+	}
+	return nil, errors.New("unknown format: " + format)
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(r *exportRecord) error {
+	return w.enc.Encode(r)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.w.Flush()
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+}
+
+func (r *jsonlReader) Read() (*exportRecord, error) {
+	var record exportRecord
+	if err := r.dec.Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvWriter) Write(r *exportRecord) error {
+	return w.w.Write([]string{r.Kind, r.ID, string(r.Record)})
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type csvReader struct {
+	r *csv.Reader
+}
+
+func (r *csvReader) Read() (*exportRecord, error) {
+	row, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return &exportRecord{Kind: row[0], ID: row[1], Record: json.RawMessage(row[2])}, nil
+}
@@ -0,0 +1,137 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/qitoi/space-watcher/db"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, driver := range []string{db.DriverBolt, db.DriverSQLite} {
+		for _, format := range []string{exportFormatJSONL, exportFormatCSV} {
+			t.Run(driver+"/"+format, func(t *testing.T) {
+				dir := t.TempDir()
+				src := &Config{Database: DatabaseConfig{Driver: driver, Path: filepath.Join(dir, "src.db")}}
+				dst := &Config{Database: DatabaseConfig{Driver: driver, Path: filepath.Join(dir, "dst.db")}}
+				output := filepath.Join(dir, "export."+format)
+
+				writeTestRecords(t, &src.Database)
+
+				if err := ExportDatabase(src, []string{"--format", format, "--output", output}); err != nil {
+					t.Fatal(err)
+				}
+				if err := ImportDatabase(dst, []string{output}); err != nil {
+					t.Fatal(err)
+				}
+
+				expectedSpaces, expectedEvents := readTestRecords(t, &src.Database)
+				actualSpaces, actualEvents := readTestRecords(t, &dst.Database)
+
+				if len(actualSpaces) != len(expectedSpaces) {
+					t.Errorf("spaces, actual: %d, expected: %d", len(actualSpaces), len(expectedSpaces))
+				}
+				for id, expected := range expectedSpaces {
+					if actual := actualSpaces[id]; !proto.Equal(actual, expected) {
+						t.Errorf("space %s, actual: %v, expected: %v", id, actual, expected)
+					}
+				}
+				if len(actualEvents) != len(expectedEvents) {
+					t.Errorf("events, actual: %d, expected: %d", len(actualEvents), len(expectedEvents))
+				}
+				for id, expected := range expectedEvents {
+					if actual := actualEvents[id]; !proto.Equal(actual, expected) {
+						t.Errorf("event %s, actual: %v, expected: %v", id, actual, expected)
+					}
+				}
+			})
+		}
+	}
+}
+
+// writeTestRecords は出力の確認に使用するスペースの記録と履歴を書き込む
+func writeTestRecords(t *testing.T, config *DatabaseConfig) {
+	t.Helper()
+
+	store, err := openDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now().Truncate(time.Second)
+	if err := store.RegisterStart("space1", "user1", "user1", "title, \"quoted\"", now, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddTweet("space1", db.SpaceNotificationStatus_START, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetState("space1", db.StateEnded); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RegisterSchedule("space2", "user2", "user2", "予定\n改行", now.Add(time.Hour), now); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []*db.Event{
+		{SpaceId: "space1", CreatorId: "user1", Type: db.EventType_EVENT_OBSERVED, Timestamp: timestamppb.New(now), State: db.StateLive},
+		{SpaceId: "space1", CreatorId: "user1", Type: db.EventType_EVENT_DELIVERED, Timestamp: timestamppb.New(now), NotificationStatus: db.SpaceNotificationStatus_START, Sink: sinkTweet},
+		{SpaceId: "space1", CreatorId: "user1", Type: db.EventType_EVENT_OBSERVED, Timestamp: timestamppb.New(now.Add(time.Hour)), State: db.StateEnded},
+	}
+	for _, e := range events {
+		if err := store.RecordObservation(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTestRecords はスペースの記録と履歴を ID ごとに読み込む
+func readTestRecords(t *testing.T, config *DatabaseConfig) (map[string]*db.Space, map[string]*db.Event) {
+	t.Helper()
+
+	store, err := openDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	spaces := make(map[string]*db.Space)
+	err = store.ForEachSpace(func(s *db.Space) error {
+		spaces[s.Id] = s
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(map[string]*db.Event)
+	err = store.ForEachEvent(func(id string, e *db.Event) error {
+		events[id] = e
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return spaces, events
+}
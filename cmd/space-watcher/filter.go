@@ -0,0 +1,135 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+// spaceFilter はタイトルの正規表現をコンパイル済みのフィルター
+type spaceFilter struct {
+	*FilterConfig
+	includeTitle []*regexp.Regexp
+	excludeTitle []*regexp.Regexp
+}
+
+func compileFilter(filter *FilterConfig, name string) (*spaceFilter, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	f := &spaceFilter{FilterConfig: filter}
+	for _, pattern := range filter.IncludeTitle {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %s.include_title: %w", name, err)
+		}
+		f.includeTitle = append(f.includeTitle, re)
+	}
+	for _, pattern := range filter.ExcludeTitle {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %s.exclude_title: %w", name, err)
+		}
+		f.excludeTitle = append(f.excludeTitle, re)
+	}
+	return f, nil
+}
+
+// compileFilters はイベントごとのフィルターをコンパイルする
+// フィルターはクリエイターごとに上書きできないため、全体の設定のみを対象にする
+func compileFilters(event *EventConfig) (map[db.SpaceNotificationStatus]*spaceFilter, error) {
+	filters := make(map[db.SpaceNotificationStatus]*spaceFilter)
+	for status, name := range eventNames {
+		conf, err := eventItemConfig(event, status)
+		if err != nil {
+			return nil, err
+		}
+		if conf == nil || conf.Filter == nil {
+			continue
+		}
+		f, err := compileFilter(conf.Filter, "event."+name+".filter")
+		if err != nil {
+			return nil, err
+		}
+		filters[status] = f
+	}
+	return filters, nil
+}
+
+// rejectReason はイベントの条件に一致しないスペースについて、その理由を返す
+// 条件に一致する場合は空文字列を返す
+func (w *watcher) rejectReason(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User) string {
+	conf, err := w.getEventConfig(status, user)
+	if err != nil || conf == nil {
+		return ""
+	}
+	return filterSpace(w.filters[status], space)
+}
+
+func filterSpace(filter *spaceFilter, space *twitter2.Space) string {
+	if filter == nil {
+		return ""
+	}
+
+	if len(filter.includeTitle) > 0 {
+		matched := false
+		for _, re := range filter.includeTitle {
+			if re.MatchString(space.Title) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "title does not match include_title"
+		}
+	}
+	for _, re := range filter.excludeTitle {
+		if re.MatchString(space.Title) {
+			return fmt.Sprintf("title matches exclude_title: %s", re)
+		}
+	}
+
+	if len(filter.Lang) > 0 {
+		lang := ""
+		if space.Lang != nil {
+			lang = *space.Lang
+		}
+		allowed := false
+		for _, l := range filter.Lang {
+			if l == lang {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("lang is not allowed: %q", lang)
+		}
+	}
+
+	if filter.IsTicketed != nil {
+		ticketed := space.IsTicketed != nil && *space.IsTicketed
+		if ticketed != *filter.IsTicketed {
+			return fmt.Sprintf("is_ticketed: %v", ticketed)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,199 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+const (
+	defaultLinksInterval = 300
+	linksMaxResults      = 100
+)
+
+// startLinks はフォローしているユーザーのツイートに含まれるスペースのリンクを定期的に通知の処理に渡す
+// 取得の間隔はユーザー数とタイムラインのレート制限から決める
+func (w *watcher) startLinks(ctx context.Context, conf *LinksConfig, userIDs []string) {
+	baseInterval := conf.Interval
+	if baseInterval <= 0 {
+		baseInterval = defaultLinksInterval
+	}
+	interval := baseInterval
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		// 起動前のツイートは対象にしない
+		sinceIDs := make(map[string]string)
+		initialized := make(map[string]bool)
+
+		for {
+			spaceIDs, rate := w.collectLinkedSpaces(ctx, userIDs, sinceIDs, initialized)
+
+			if len(spaceIDs) > 0 {
+				if err := w.processLinkedSpaces(ctx, spaceIDs); err != nil {
+					w.logger.Errorw("linked space error", "error", err)
+				}
+			}
+
+			if nextInterval := pollInterval(rate, baseInterval, interval, len(userIDs)); nextInterval != interval {
+				interval = nextInterval
+				ticker.Reset(time.Duration(interval) * time.Second)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// collectLinkedSpaces はユーザーごとに前回以降のツイートを取得し、リンクされたスペースの ID を返す
+// 初めて取得に成功するまでのユーザーは起動前のツイートを通知しないよう、最新のツイートの ID のみ記録する
+// 1 回の取得は最新の linksMaxResults 件までで、間隔中にそれより多くツイートした場合は古いものを確認しない
+func (w *watcher) collectLinkedSpaces(ctx context.Context, userIDs []string, sinceIDs map[string]string, initialized map[string]bool) ([]string, *twitter2.RateLimit) {
+	var spaceIDs []string
+	seen := make(map[string]bool)
+	var rate *twitter2.RateLimit
+
+	for _, userID := range userIDs {
+		skip := !initialized[userID]
+		req := twitter2.UserTweetsRequest{
+			UserID:      userID,
+			SinceID:     sinceIDs[userID],
+			TweetFields: []string{"entities"},
+		}
+		if skip {
+			req.MaxResults = 5
+		} else {
+			req.MaxResults = linksMaxResults
+		}
+
+		resp, r, err := w.clientV2.GetUserTweets(ctx, req)
+		if r != nil {
+			rate = r
+		}
+		if err != nil {
+			w.logger.Errorw("get user tweets error", "user_id", userID, "error", err)
+			continue
+		}
+		initialized[userID] = true
+		if resp.Meta != nil && resp.Meta.NewestID != "" {
+			sinceIDs[userID] = resp.Meta.NewestID
+		}
+		if skip {
+			continue
+		}
+		if resp.Meta != nil && resp.Meta.ResultCount >= linksMaxResults {
+			w.logger.Warnw("user tweets may be truncated", "user_id", userID, "max_results", linksMaxResults)
+		}
+
+		for _, id := range linkedSpaceIDs(resp.Data) {
+			if !seen[id] {
+				seen[id] = true
+				spaceIDs = append(spaceIDs, id)
+			}
+		}
+	}
+
+	return spaceIDs, rate
+}
+
+// linkedSpaceIDs はツイートの URL からスペースの ID を取り出す
+func linkedSpaceIDs(tweets []twitter2.Tweet) []string {
+	var ids []string
+	for _, t := range tweets {
+		if t.Entities == nil {
+			continue
+		}
+		for _, u := range t.Entities.URLs {
+			if id, ok := twitter2.ParseSpaceURL(u.ExpandedURL); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// processLinkedSpaces は記録のないスペースを ID で取得し、通知の処理に渡す
+// 予定・配信中のスペースは監視するスペースに登録し、予定のリマインドや開始を通知できるよう終了まで状態を確認する
+// 記録済みのスペースは登録済みのため取得しない
+func (w *watcher) processLinkedSpaces(ctx context.Context, spaceIDs []string) error {
+	var ids []string
+	for _, id := range spaceIDs {
+		record, err := w.dbClient.GetSpace(id)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxLookupSpaces {
+			n = maxLookupSpaces
+		}
+
+		resp, _, err := w.clientV2.GetSpacesByIDs(ctx, twitter2.SpacesByIDsRequest{
+			IDs:         ids[:n],
+			Expansions:  spaceExpansions,
+			SpaceFields: spaceFields,
+			UserFields:  userFields,
+		})
+		if err != nil {
+			return err
+		}
+		ids = ids[n:]
+
+		users := make(map[string]twitter2.User)
+		if resp.Includes != nil && resp.Includes.Users != nil {
+			for _, u := range *resp.Includes.Users {
+				users[u.ID] = u
+			}
+		}
+
+		// 終了・キャンセル済みのスペースは通知しない
+		var spaces []twitter2.Space
+		for _, s := range resp.Data {
+			if s.State != nil && (*s.State == db.StateLive || *s.State == db.StateScheduled) {
+				spaces = append(spaces, s)
+			}
+		}
+		if len(spaces) > 0 {
+			w.logger.Infow("linked spaces", "spaces", spaces)
+			if err := w.processSpaces(spaces, users); err != nil {
+				return err
+			}
+		}
+		for _, s := range spaces {
+			if _, err := w.dbClient.AddWatchedSpace(s.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
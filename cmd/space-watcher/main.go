@@ -17,19 +17,41 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 
 	"github.com/spf13/pflag"
+
+	"github.com/qitoi/space-watcher/db"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command]\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	fmt.Fprintln(os.Stderr, "  purge <space_id>...    delete tweets posted for the spaces (through the admin server if enabled)")
+	fmt.Fprintln(os.Stderr, "  compact                compact the database file (stop the bot first)")
+	fmt.Fprintln(os.Stderr, "  backup                 back up the database (through the admin server if enabled)")
+	fmt.Fprintln(os.Stderr, "  report [flags]         summarize spaces and hosts from the history")
+	fmt.Fprintln(os.Stderr, "  watch [flags] [space]  watch spaces by id or url (--remove to stop, list without arguments)")
+	fmt.Fprintln(os.Stderr, "  export [flags]         export spaces and history as JSON Lines or CSV")
+	fmt.Fprintln(os.Stderr, "  import [flags] <file>  merge exported spaces and history into the database")
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	pflag.PrintDefaults()
+}
+
 func main() {
 	var init bool
+	var ephemeral bool
 	var help bool
 
 	pflag.BoolVarP(&init, "init", "", false, "initialize token")
+	pflag.BoolVarP(&ephemeral, "ephemeral", "", false, "keep the notification state in memory only")
 	pflag.BoolVarP(&help, "help", "h", false, "help")
 
+	// コマンド以降のフラグはコマンドごとに解析する
+	pflag.CommandLine.SetInterspersed(false)
+	pflag.Usage = usage
 	pflag.Parse()
 
 	if help {
@@ -45,6 +67,10 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if ephemeral {
+		config.Database.Driver = db.DriverMemory
+	}
+
 	if init {
 		if err := InitializeToken(config); err != nil {
 			log.Fatal(err)
@@ -52,7 +78,28 @@ func main() {
 		os.Exit(0)
 	}
 
-	if err := Start(config); err != nil {
+	switch command := pflag.Arg(0); command {
+	case "":
+		err = Start(config)
+	case "purge":
+		err = PurgeTweets(config, pflag.Args()[1:])
+	case "compact":
+		err = CompactDatabase(config)
+	case "backup":
+		err = BackupDatabase(config)
+	case "report":
+		err = ReportSpaces(config, pflag.Args()[1:])
+	case "watch":
+		err = WatchSpaces(config, pflag.Args()[1:])
+	case "export":
+		err = ExportDatabase(config, pflag.Args()[1:])
+	case "import":
+		err = ImportDatabase(config, pflag.Args()[1:])
+	default:
+		pflag.Usage()
+		log.Fatalf("unknown command: %s", command)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,73 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+// processMilestones は参加者数が節目を超えたスペースを通知する
+// 節目は配送の登録前に記録するため、同じ節目を二重に登録しない
+func (w *watcher) processMilestones(space *twitter2.Space, user *twitter2.User, users map[string]twitter2.User) error {
+	conf, err := w.getEventConfig(db.SpaceNotificationStatus_MILESTONE, user)
+	if err != nil || conf == nil || space.ParticipantCount == nil {
+		return err
+	}
+
+	// 開始の通知より前に節目を通知しない
+	status, err := w.dbClient.GetNotifiedStatus(space.ID)
+	if err != nil {
+		return err
+	}
+	if status < db.SpaceNotificationStatus_START {
+		return nil
+	}
+
+	// 一度に複数の節目を超えた場合は最大のものだけを通知する
+	var reached int64
+	for _, threshold := range conf.Thresholds {
+		if *space.ParticipantCount < threshold {
+			continue
+		}
+		claimed, err := w.dbClient.ClaimMilestone(space.ID, threshold)
+		if err != nil {
+			return err
+		}
+		if claimed && threshold > reached {
+			reached = threshold
+		}
+	}
+	if reached == 0 {
+		return nil
+	}
+
+	if reason := w.rejectReason(db.SpaceNotificationStatus_MILESTONE, space, user); reason != "" {
+		w.logger.Infow("space filtered", "space_id", space.ID, "status", db.SpaceNotificationStatus_MILESTONE, "title", space.Title, "reason", reason)
+		return nil
+	}
+
+	w.logger.Infow("milestone", "space_id", space.ID, "participant_count", *space.ParticipantCount, "milestone", reached)
+
+	return w.enqueueSnapshot(db.SpaceNotificationStatus_MILESTONE, strconv.FormatInt(reached, 10), &spaceSnapshot{
+		Space:     *space,
+		Users:     relatedUsers(space, users),
+		Milestone: reached,
+	}, user)
+}
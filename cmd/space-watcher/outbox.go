@@ -0,0 +1,222 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/qitoi/space-watcher/bot"
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+const (
+	sinkCommand = "command"
+	sinkTweet   = "tweet"
+	sinkDiscord = "discord"
+	sinkSlack   = "slack"
+)
+
+const (
+	defaultOutboxMaxAttempts      = 5
+	defaultOutboxRetryInterval    = 30
+	defaultOutboxMaxRetryInterval = 3600
+)
+
+// spaceSnapshot は配送時にテンプレートで使用するスペースと、ホスト・スピーカー・招待されたユーザー
+type spaceSnapshot struct {
+	twitter2.Space
+	Users     []twitter2.User `json:"users,omitempty"`
+	Milestone int64           `json:"milestone,omitempty"`
+	Joined    *twitter2.User  `json:"joined,omitempty"`
+	Role      string          `json:"role,omitempty"`
+}
+
+func (w *watcher) enqueueDeliveries(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User, users map[string]twitter2.User) error {
+	return w.enqueueSnapshot(status, "", &spaceSnapshot{
+		Space: *space,
+		Users: relatedUsers(space, users),
+	}, user)
+}
+
+// enqueueSnapshot は通知先ごとの配送を登録する
+// key は同じスペース・ステータスで複数回通知するイベントを区別する
+func (w *watcher) enqueueSnapshot(status db.SpaceNotificationStatus, key string, snapshot *spaceSnapshot, user *twitter2.User) error {
+	sinks, err := w.getSinks(status, &snapshot.Space, user)
+	if err != nil || len(sinks) == 0 {
+		return err
+	}
+
+	spaceData, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	userData, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return w.dbClient.EnqueueDeliveries(snapshot.Space.ID, status, key, sinks, spaceData, userData)
+}
+
+// processOutbox は未配送の通知を配送し、失敗したものは間隔を空けて再送する
+func (w *watcher) processOutbox() error {
+	now := time.Now()
+	deliveries, err := w.dbClient.GetDueDeliveries(now)
+	if err != nil {
+		return err
+	}
+	deliveries, err = w.revalidateDeliveries(context.Background(), deliveries)
+	if err != nil {
+		return err
+	}
+
+	maxAttempts := defaultOutboxMaxAttempts
+	if w.config.Outbox != nil && w.config.Outbox.MaxAttempts > 0 {
+		maxAttempts = w.config.Outbox.MaxAttempts
+	}
+
+	for _, d := range deliveries {
+		if ok, err := w.applyQuietHours(d, now); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		err := w.deliver(d)
+		if err == nil {
+			if err := w.dbClient.MarkDelivered(d); err != nil {
+				return err
+			}
+			w.logger.Infow("delivery completed", "space_id", d.SpaceId, "status", d.NotificationStatus, "sink", d.Sink)
+			continue
+		}
+
+		if int(d.Attempts)+1 >= maxAttempts {
+			if err := w.dbClient.MarkDead(d, err); err != nil {
+				return err
+			}
+			w.logger.Errorw("delivery dead", "space_id", d.SpaceId, "status", d.NotificationStatus, "sink", d.Sink, "attempts", d.Attempts, "error", d.LastError)
+			continue
+		}
+
+		next := time.Now().Add(w.retryInterval(int(d.Attempts) + 1))
+		if err := w.dbClient.MarkRetry(d, err, next); err != nil {
+			return err
+		}
+		w.logger.Warnw("delivery failed", "space_id", d.SpaceId, "status", d.NotificationStatus, "sink", d.Sink, "attempts", d.Attempts, "next_attempt", next, "error", d.LastError)
+	}
+
+	return nil
+}
+
+func (w *watcher) deliver(d *db.Delivery) error {
+	if d.NotificationStatus == db.SpaceNotificationStatus_REPORT {
+		return w.deliverReport(d)
+	}
+
+	data, err := w.deliveryMessageData(d)
+	if err != nil {
+		return err
+	}
+	return w.send(d.Sink, d.NotificationStatus, data)
+}
+
+// deliveryMessageData は配送に保存したスペースとユーザーからテンプレートの値を作る
+func (w *watcher) deliveryMessageData(d *db.Delivery) (*bot.MessageData, error) {
+	var space spaceSnapshot
+	if err := json.Unmarshal(d.Space, &space); err != nil {
+		return nil, err
+	}
+	var user twitter2.User
+	if err := json.Unmarshal(d.User, &user); err != nil {
+		return nil, err
+	}
+
+	data, err := w.newMessageData(&space.Space, &user)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]twitter2.User)
+	for _, u := range space.Users {
+		users[u.ID] = u
+	}
+	data.ResolveUsers(users)
+	data.Milestone = space.Milestone
+	if space.Joined != nil {
+		data.Joined = *space.Joined
+	}
+	data.Role = space.Role
+	return data, nil
+}
+
+// relatedUsers はスペースのホスト・スピーカー・招待されたユーザーのうち、users に含まれるものを返す
+func relatedUsers(space *twitter2.Space, users map[string]twitter2.User) []twitter2.User {
+	var related []twitter2.User
+	seen := make(map[string]bool)
+	for _, ids := range []*[]string{space.HostIds, space.SpeakerIDs, space.InvitedUserIDs} {
+		if ids == nil {
+			continue
+		}
+		for _, id := range *ids {
+			if u, ok := users[id]; ok && !seen[id] {
+				seen[id] = true
+				related = append(related, u)
+			}
+		}
+	}
+	return related
+}
+
+// newMessageData は記録済みの参加者数を含めたテンプレートの値を作る
+func (w *watcher) newMessageData(space *twitter2.Space, user *twitter2.User) (*bot.MessageData, error) {
+	data := bot.NewMessageData(space, user)
+
+	record, err := w.dbClient.GetSpace(space.ID)
+	if err != nil {
+		return nil, err
+	}
+	if p := record.GetParticipants(); p != nil {
+		data.PeakParticipants = p.Peak()
+		data.AverageParticipants = p.Average()
+	}
+	return data, nil
+}
+
+// retryInterval は失敗回数に応じて指数的に再送間隔を延ばす
+func (w *watcher) retryInterval(attempts int) time.Duration {
+	interval := int64(defaultOutboxRetryInterval)
+	maxInterval := int64(defaultOutboxMaxRetryInterval)
+	if conf := w.config.Outbox; conf != nil {
+		if conf.RetryInterval > 0 {
+			interval = conf.RetryInterval
+		}
+		if conf.MaxRetryInterval > 0 {
+			maxInterval = conf.MaxRetryInterval
+		}
+	}
+
+	for i := 1; i < attempts && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+	return time.Duration(interval) * time.Second
+}
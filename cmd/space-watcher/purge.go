@@ -0,0 +1,101 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	twitter11 "github.com/dghubble/go-twitter/twitter"
+)
+
+type purgeResult struct {
+	Purged []string `json:"purged"`
+}
+
+// purgeTweets は指定したスペースについて bot が投稿したツイートを削除する
+func (w *watcher) purgeTweets(ids []string) (*purgeResult, error) {
+	result := &purgeResult{}
+	for _, spaceID := range ids {
+		if err := w.deleteTweets(spaceID); err != nil {
+			return result, err
+		}
+		w.logger.Infow("purge completed", "space_id", spaceID)
+		result.Purged = append(result.Purged, spaceID)
+	}
+	return result, nil
+}
+
+func (w *watcher) handlePurge(r *http.Request) (interface{}, error) {
+	ids := r.URL.Query()["id"]
+	if len(ids) == 0 {
+		return nil, errors.New("space id is required")
+	}
+	return w.purgeTweets(ids)
+}
+
+// PurgeTweets は指定したスペースについて bot が投稿したツイートを削除する
+// 管理用サーバーが有効な場合は起動中のプロセスに依頼し、無効な場合はデータベースを直接開く
+func PurgeTweets(config *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("space id is required")
+	}
+	if err := CheckValidConfig(config); err != nil {
+		return err
+	}
+
+	var result *purgeResult
+	if config.Admin.Enabled {
+		params := url.Values{}
+		for _, id := range args {
+			params.Add("id", id)
+		}
+		result = &purgeResult{}
+		if err := callAdmin(&config.Admin, http.MethodPost, "/purge", params, result); err != nil {
+			return err
+		}
+	} else {
+		log, err := getLogger(config)
+		if err != nil {
+			return err
+		}
+		defer log.Sync()
+
+		dbClient, err := openDatabase(&config.Database)
+		if err != nil {
+			return err
+		}
+		defer dbClient.Close()
+
+		w := &watcher{
+			config:    config,
+			logger:    log.Sugar(),
+			clientV11: twitter11.NewClient(newHTTPClientV11(config)),
+			dbClient:  dbClient,
+		}
+		if result, err = w.purgeTweets(args); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range result.Purged {
+		fmt.Printf("purged: %s\n", id)
+	}
+	return nil
+}
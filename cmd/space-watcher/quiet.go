@@ -0,0 +1,256 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+const (
+	quietHoursFormat = "15:04"
+)
+
+// quietActions はイベントごとの静かな時間帯の既定の動作
+// 予定の告知は延期し、リマインドや節目は時機を逃すため破棄し、開始は通知する
+var quietActions = map[db.SpaceNotificationStatus]string{
+	db.SpaceNotificationStatus_SCHEDULE:        QuietActionDefer,
+	db.SpaceNotificationStatus_SCHEDULE_REMIND: QuietActionDrop,
+	db.SpaceNotificationStatus_START:           QuietActionSend,
+	db.SpaceNotificationStatus_END:             QuietActionDefer,
+	db.SpaceNotificationStatus_MILESTONE:       QuietActionDrop,
+	db.SpaceNotificationStatus_JOINED:          QuietActionDrop,
+}
+
+// quietUntil は t が静かな時間帯に含まれる場合に、時間帯の終了時刻を返す
+func quietUntil(conf *QuietHoursConfig, t time.Time) (time.Time, bool) {
+	if conf == nil {
+		return time.Time{}, false
+	}
+	start, err := time.Parse(quietHoursFormat, conf.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := time.Parse(quietHoursFormat, conf.End)
+	if err != nil {
+		return time.Time{}, false
+	}
+	loc, err := quietLocation(conf)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	local := t.In(loc)
+	minutes := local.Hour()*60 + local.Minute()
+	startMinutes := start.Hour()*60 + start.Minute()
+	endMinutes := end.Hour()*60 + end.Minute()
+	endAt := func(days int) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days, end.Hour(), end.Minute(), 0, 0, loc)
+	}
+
+	if startMinutes < endMinutes {
+		if startMinutes <= minutes && minutes < endMinutes {
+			return endAt(0), true
+		}
+		return time.Time{}, false
+	}
+
+	// 日をまたぐ時間帯
+	if minutes >= startMinutes {
+		return endAt(1), true
+	}
+	if minutes < endMinutes {
+		return endAt(0), true
+	}
+	return time.Time{}, false
+}
+
+func quietLocation(conf *QuietHoursConfig) (*time.Location, error) {
+	if conf.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(conf.Timezone)
+}
+
+// getQuietAction は静かな時間帯に含まれる場合に、その動作と終了時刻を返す
+// 静かな時間帯でない場合は send を返す
+func (w *watcher) getQuietAction(status db.SpaceNotificationStatus, user *twitter2.User, now time.Time) (string, time.Time, error) {
+	conf, err := w.getEventConfig(status, user)
+	if err != nil || conf == nil {
+		return QuietActionSend, time.Time{}, err
+	}
+	until, quiet := quietUntil(conf.QuietHours, now)
+	if !quiet {
+		return QuietActionSend, time.Time{}, nil
+	}
+	if conf.QuietHours.Action != "" {
+		return conf.QuietHours.Action, until, nil
+	}
+	return quietActions[status], until, nil
+}
+
+// applyQuietHours は静かな時間帯の配送を延期または破棄し、配送を続けるか返す
+func (w *watcher) applyQuietHours(d *db.Delivery, now time.Time) (bool, error) {
+	// レポートは投稿する時刻を設定するため対象外
+	if d.NotificationStatus == db.SpaceNotificationStatus_REPORT {
+		return true, nil
+	}
+
+	var user twitter2.User
+	if err := json.Unmarshal(d.User, &user); err != nil {
+		return false, err
+	}
+
+	action, until, err := w.getQuietAction(d.NotificationStatus, &user, now)
+	if err != nil {
+		return false, err
+	}
+	switch action {
+	case QuietActionDefer:
+		if err := w.dbClient.MarkDeferred(d, until); err != nil {
+			return false, err
+		}
+		w.logger.Infow("delivery deferred", "space_id", d.SpaceId, "status", d.NotificationStatus, "sink", d.Sink, "until", until)
+		return false, nil
+	case QuietActionDrop:
+		if err := w.dbClient.MarkDropped(d, "quiet hours"); err != nil {
+			return false, err
+		}
+		w.logger.Infow("delivery dropped", "space_id", d.SpaceId, "status", d.NotificationStatus, "sink", d.Sink, "reason", "quiet hours")
+		return false, nil
+	}
+	return true, nil
+}
+
+// isDeferred は静かな時間帯のために延期された配送か返す
+// 失敗による再送は配送回数が増えるため含まない
+func isDeferred(d *db.Delivery) bool {
+	return d.Attempts == 0 && d.NextAttemptAt.AsTime().After(d.CreatedAt.AsTime())
+}
+
+// expectedStates は配送時にスペースがとるべき状態
+var expectedStates = map[db.SpaceNotificationStatus]string{
+	db.SpaceNotificationStatus_SCHEDULE:        db.StateScheduled,
+	db.SpaceNotificationStatus_SCHEDULE_REMIND: db.StateScheduled,
+	db.SpaceNotificationStatus_START:           db.StateLive,
+	db.SpaceNotificationStatus_END:             db.StateEnded,
+	db.SpaceNotificationStatus_MILESTONE:       db.StateLive,
+}
+
+// revalidateDeliveries は延期していた配送のスペースの現在の状態を確認する
+// 状態が変わったスペースの配送は破棄し、配送を続けるものはスペースの情報を最新にして返す
+// 確認できなかったスペースの配送は返さず、次回に確認する
+func (w *watcher) revalidateDeliveries(ctx context.Context, deliveries []*db.Delivery) ([]*db.Delivery, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, d := range deliveries {
+		if isDeferred(d) && !seen[d.SpaceId] {
+			seen[d.SpaceId] = true
+			ids = append(ids, d.SpaceId)
+		}
+	}
+	if len(ids) == 0 {
+		return deliveries, nil
+	}
+
+	current := make(map[string]twitter2.Space)
+	failed := make(map[string]bool)
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxLookupSpaces {
+			n = maxLookupSpaces
+		}
+		resp, rate, err := w.clientV2.GetSpacesByIDs(ctx, twitter2.SpacesByIDsRequest{
+			IDs:         ids[:n],
+			SpaceFields: spaceFields,
+		})
+		w.lookups.add(rate)
+		if err != nil {
+			w.logger.Errorw("revalidate error", "space_ids", ids[:n], "error", err)
+			for _, id := range ids[:n] {
+				failed[id] = true
+			}
+		} else {
+			for _, s := range resp.Data {
+				current[s.ID] = s
+			}
+		}
+		ids = ids[n:]
+	}
+
+	var valid []*db.Delivery
+	for _, d := range deliveries {
+		if !isDeferred(d) {
+			valid = append(valid, d)
+			continue
+		}
+		if failed[d.SpaceId] {
+			continue
+		}
+
+		s, ok := current[d.SpaceId]
+		if !ok && d.NotificationStatus == db.SpaceNotificationStatus_END {
+			// 終了したスペースは取得できないことがあるため、保存時の情報で通知する
+			valid = append(valid, d)
+			continue
+		}
+
+		reason := ""
+		if !ok {
+			reason = "space not found"
+		} else if expected, ok := expectedStates[d.NotificationStatus]; ok && (s.State == nil || *s.State != expected) {
+			reason = "space state changed"
+		} else if d.NotificationStatus == db.SpaceNotificationStatus_JOINED && (s.State == nil || (*s.State != db.StateScheduled && *s.State != db.StateLive)) {
+			// 参加は予定・配信中のどちらのスペースでも通知する
+			reason = "space state changed"
+		}
+		if reason != "" {
+			if err := w.dbClient.MarkDropped(d, reason); err != nil {
+				return nil, err
+			}
+			w.logger.Infow("delivery dropped", "space_id", d.SpaceId, "status", d.NotificationStatus, "sink", d.Sink, "reason", reason)
+			continue
+		}
+
+		// 延期中に変わったタイトルや日時で通知する
+		if err := refreshSnapshot(d, &s); err != nil {
+			return nil, err
+		}
+		valid = append(valid, d)
+	}
+	return valid, nil
+}
+
+// refreshSnapshot は配送に保存したスペースの情報を置き換える
+// ホスト・スピーカー・招待されたユーザーは保存時のものを使用する
+func refreshSnapshot(d *db.Delivery, space *twitter2.Space) error {
+	var snapshot spaceSnapshot
+	if err := json.Unmarshal(d.Space, &snapshot); err != nil {
+		return err
+	}
+	snapshot.Space = *space
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	d.Space = data
+	return nil
+}
@@ -0,0 +1,297 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/qitoi/space-watcher/db"
+	"github.com/qitoi/space-watcher/report"
+)
+
+const (
+	reportTimeFormat    = "15:04"
+	reportDateFormat    = "2006-01-02"
+	defaultReportPeriod = 7 * 24 * 60 * 60
+)
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) {
+			return d, nil
+		}
+	}
+	return 0, errors.New("invalid weekday: " + s)
+}
+
+// reportLocation はレポートの集計に使うタイムゾーンを返す (未設定の場合はローカル)
+func reportLocation(conf *ReportConfig) (*time.Location, error) {
+	if conf == nil || conf.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(conf.Timezone)
+}
+
+func reportPeriod(conf *ReportConfig) time.Duration {
+	if conf == nil || conf.Period <= 0 {
+		return defaultReportPeriod * time.Second
+	}
+	return time.Duration(conf.Period) * time.Second
+}
+
+// nextReportTime は now より後で最初の weekday の clock の時刻を返す
+func nextReportTime(now time.Time, weekday time.Weekday, clock time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// startReport は設定した曜日・時刻にレポートを投稿する
+func (w *watcher) startReport(ctx context.Context, conf *ReportConfig) {
+	weekday, _ := parseWeekday(conf.Weekday)
+	clock, _ := time.Parse(reportTimeFormat, conf.Time)
+	loc, _ := reportLocation(conf)
+
+	go func() {
+		for {
+			next := nextReportTime(time.Now(), weekday, clock, loc)
+			w.logger.Infow("next report", "at", next)
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			r, err := report.Build(w.dbClient, report.Options{
+				From:     next.Add(-reportPeriod(conf)),
+				To:       next,
+				Location: loc,
+				Top:      conf.Top,
+			})
+			if err != nil {
+				w.logger.Errorw("report build error", "error", err)
+				continue
+			}
+			if err := w.enqueueReport(conf, r, next); err != nil {
+				w.logger.Errorw("report enqueue error", "error", err)
+			}
+		}
+	}()
+}
+
+// reportSinks はレポートの通知先を返す
+func reportSinks(conf *ReportConfig) []string {
+	if len(conf.Sinks) > 0 {
+		return conf.Sinks
+	}
+	var sinks []string
+	if conf.Notification != nil {
+		sinks = append(sinks, sinkTweet)
+	}
+	if conf.Command != nil {
+		sinks = append(sinks, sinkCommand)
+	}
+	return sinks
+}
+
+// enqueueReport はレポートを通知と同じ outbox から配送する
+// 集計の終了時刻ごとに 1 度だけ登録するため、再起動しても重複しない
+func (w *watcher) enqueueReport(conf *ReportConfig, r *report.Report, at time.Time) error {
+	sinks := reportSinks(conf)
+	if len(sinks) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	id := fmt.Sprintf("report-%d", at.Unix())
+	return w.dbClient.EnqueueDeliveries(id, db.SpaceNotificationStatus_REPORT, "", sinks, data, nil)
+}
+
+// deliverReport は配送に保存したレポートを通知先に送る
+func (w *watcher) deliverReport(d *db.Delivery) error {
+	conf := w.config.Report
+	if conf == nil {
+		return errors.New("report is not configured")
+	}
+	var r report.Report
+	if err := json.Unmarshal(d.Space, &r); err != nil {
+		return err
+	}
+	loc, err := reportLocation(conf)
+	if err != nil {
+		return err
+	}
+
+	if d.Sink == sinkCommand {
+		if conf.Command == nil {
+			return errors.New("report.command is not configured")
+		}
+		return w.runCommand(conf.Command, func(s string) (string, error) {
+			return report.Render(s, &r, loc)
+		})
+	}
+
+	if conf.Notification == nil || conf.Notification.Message == "" {
+		return errors.New("report.notification.message is not configured")
+	}
+	switch d.Sink {
+	case sinkTweet:
+		message, err := report.RenderTweet(conf.Notification.Message, &r, loc)
+		if err != nil {
+			return err
+		}
+		tweet, _, err := w.clientV11.Statuses.Update(message, nil)
+		if err != nil {
+			return err
+		}
+		w.logger.Infow("report tweet completed", "message", message, "tweet_id", tweet.ID)
+	case sinkDiscord, sinkSlack:
+		message, err := report.Render(conf.Notification.Message, &r, loc)
+		if err != nil {
+			return err
+		}
+		if err := w.postWebhookMessage(d.Sink, message); err != nil {
+			return err
+		}
+		w.logger.Infow("report webhook completed", "sink", d.Sink, "message", message)
+	default:
+		return errors.New("unknown sink: " + d.Sink)
+	}
+	return nil
+}
+
+func (w *watcher) handleReport(r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, q.Get("from"))
+	if err != nil {
+		return nil, err
+	}
+	to, err := time.Parse(time.RFC3339, q.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+	top, _ := strconv.Atoi(q.Get("top"))
+	loc, err := reportLocation(w.config.Report)
+	if err != nil {
+		return nil, err
+	}
+
+	return report.Build(w.dbClient, report.Options{From: from, To: to, Location: loc, Top: top})
+}
+
+// ReportSpaces は期間中のスペースとホストの集計を出力する
+// 管理用サーバーが有効な場合は起動中のプロセスから取得する
+func ReportSpaces(config *Config, args []string) error {
+	conf := config.Report
+	top := 0
+	message := ""
+	if conf != nil {
+		top = conf.Top
+		if conf.Notification != nil {
+			message = conf.Notification.Message
+		}
+	}
+
+	flags := pflag.NewFlagSet("report", pflag.ContinueOnError)
+	format := flags.StringP("format", "f", report.FormatTable, "output format (table, json, template)")
+	tmpl := flags.String("template", message, "template for template format (default: report.notification.message)")
+	fromDate := flags.String("from", "", "first day (YYYY-MM-DD) (default: period before to)")
+	toDate := flags.String("to", "", "day after the last day (YYYY-MM-DD) (default: now)")
+	flags.IntVar(&top, "top", top, "number of hosts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	loc, err := reportLocation(conf)
+	if err != nil {
+		return err
+	}
+
+	to := time.Now()
+	if *toDate != "" {
+		if to, err = time.ParseInLocation(reportDateFormat, *toDate, loc); err != nil {
+			return err
+		}
+	}
+	from := to.Add(-reportPeriod(conf))
+	if *fromDate != "" {
+		if from, err = time.ParseInLocation(reportDateFormat, *fromDate, loc); err != nil {
+			return err
+		}
+	}
+
+	var r *report.Report
+	if config.Admin.Enabled {
+		params := url.Values{}
+		params.Set("from", from.Format(time.RFC3339))
+		params.Set("to", to.Format(time.RFC3339))
+		params.Set("top", strconv.Itoa(top))
+		r = &report.Report{}
+		if err := callAdmin(&config.Admin, http.MethodGet, "/report", params, r); err != nil {
+			return err
+		}
+	} else {
+		dbClient, err := openDatabase(&config.Database)
+		if err != nil {
+			return err
+		}
+		defer dbClient.Close()
+
+		r, err = report.Build(dbClient, report.Options{From: from, To: to, Location: loc, Top: top})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch *format {
+	case report.FormatTable:
+		return r.WriteTable(os.Stdout, loc)
+	case report.FormatJSON:
+		return r.WriteJSON(os.Stdout)
+	case report.FormatTemplate:
+		if *tmpl == "" {
+			return errors.New("template is required")
+		}
+		message, err := report.Render(*tmpl, r, loc)
+		if err != nil {
+			return err
+		}
+		fmt.Println(message)
+		return nil
+	}
+	return errors.New("unknown format: " + *format)
+}
@@ -0,0 +1,150 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/qitoi/space-watcher/db"
+	"github.com/qitoi/space-watcher/report"
+)
+
+func TestNextReportTime(t *testing.T) {
+	loc := time.FixedZone("JST", 9*60*60)
+	clock, _ := time.Parse(reportTimeFormat, "21:00")
+
+	// 2021-10-06 は水曜日
+	cases := []struct {
+		now      time.Time
+		weekday  time.Weekday
+		expected time.Time
+	}{
+		{time.Date(2021, 10, 6, 12, 0, 0, 0, loc), time.Sunday, time.Date(2021, 10, 10, 21, 0, 0, 0, loc)},
+		{time.Date(2021, 10, 6, 12, 0, 0, 0, loc), time.Wednesday, time.Date(2021, 10, 6, 21, 0, 0, 0, loc)},
+		{time.Date(2021, 10, 6, 21, 0, 0, 0, loc), time.Wednesday, time.Date(2021, 10, 13, 21, 0, 0, 0, loc)},
+		{time.Date(2021, 10, 6, 13, 0, 0, 0, time.UTC), time.Wednesday, time.Date(2021, 10, 13, 21, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		if actual := nextReportTime(c.now, c.weekday, clock, loc); !actual.Equal(c.expected) {
+			t.Errorf("nextReportTime(%v, %v), actual: %v, expected: %v", c.now, c.weekday, actual, c.expected)
+		}
+	}
+}
+
+func TestDeliverReport(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer srv.Close()
+
+	w, store := newTestWatcher(t, fmt.Sprintf(`
+report:
+    notification:
+        message: "{{.Spaces}} spaces"
+    command:
+        name: "true"
+    sinks: [discord, command]
+sinks:
+    discord:
+        url: %s
+`, srv.URL))
+
+	// レポートも通知と同じ outbox から配送する
+	at := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := w.enqueueReport(w.config.Report, &report.Report{Spaces: 3}, at); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.processOutbox(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delivered, err := store.GetDeliveries(db.DeliveryState_DELIVERY_DELIVERED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 2 {
+		t.Errorf("delivered reports, actual: %v", delivered)
+	}
+	if !strings.Contains(body, "3 spaces") {
+		t.Errorf("report webhook body, actual: %s", body)
+	}
+}
+
+func TestCheckReportConfig(t *testing.T) {
+	cases := []struct {
+		conf  string
+		valid bool
+	}{
+		{"notification:\n    message: \"{{.Spaces}}\"", true},
+		{"notification:\n    message: \"" + strings.Repeat("a", 281) + "\"", false},
+		{"notification:\n    message: \"" + strings.Repeat("a", 281) + "\"\nsinks: [discord]", true},
+		{"notification:\n    message: \"{{.Spaces}}\"\nsinks: [slack]", false},
+		{"sinks: [tweet]", false},
+		{"sinks: [command]", false},
+	}
+	sinks := &SinksConfig{Discord: &WebhookSinkConfig{URL: "http://localhost/"}}
+	for i, c := range cases {
+		var conf ReportConfig
+		if err := yaml.Unmarshal([]byte(c.conf), &conf); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkReportConfig(&conf, sinks); (err == nil) != c.valid {
+			t.Errorf("checkReportConfig[%d], actual: %v, expected valid: %v", i, err, c.valid)
+		}
+	}
+}
+
+func TestReportDuration(t *testing.T) {
+	// 終了の通知を設定していなくても、終了を記録して配信時間を集計する
+	w, store := newTestWatcher(t, `
+report:
+    notification:
+        message: "{{.Spaces}} spaces"
+`)
+	startedAt := time.Now().Add(-time.Hour)
+	users := `"includes":{"users":[{"id":"user1","name":"User","username":"user1"}]}`
+	paths := map[string]string{
+		"/spaces/by/creator_ids": `{"data":[` + testSpaceJSON("live", startedAt) + `],` + users + `}`,
+		"/spaces":                `{"data":[` + testSpaceJSON("ended", startedAt) + `],` + users + `}`,
+	}
+	newTestAPI(t, w, paths)
+
+	w.watch(context.Background(), []string{"user1"})
+	paths["/spaces/by/creator_ids"] = `{"meta":{"result_count":0}}`
+	w.watch(context.Background(), []string{"user1"})
+
+	r, err := report.Build(store, report.Options{From: startedAt.Add(-time.Hour), To: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Hosts) != 1 || r.Hosts[0].Measured != 1 || r.Hosts[0].TotalDuration() < 59*time.Minute {
+		t.Errorf("report hosts, actual: %+v", r.Hosts)
+	}
+}
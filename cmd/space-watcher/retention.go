@@ -0,0 +1,85 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/qitoi/space-watcher/db"
+)
+
+const (
+	defaultRetentionInterval = 3600
+)
+
+// startRetention は古いスペースの記録を定期的に削除する
+func (w *watcher) startRetention(ctx context.Context, conf *RetentionConfig) {
+	interval := conf.Interval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			w.applyRetention(conf)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (w *watcher) applyRetention(conf *RetentionConfig) {
+	now := time.Now()
+
+	before := now.Add(-time.Duration(conf.MaxAge) * time.Second)
+	deleted, err := w.dbClient.DeleteClosedSpaces(before)
+	if err != nil {
+		w.logger.Errorw("retention error", "error", err)
+		return
+	}
+	w.logger.Infow("retention completed", "before", before, "deleted", deleted)
+
+	if conf.HistoryMaxAge > 0 {
+		before := now.Add(-time.Duration(conf.HistoryMaxAge) * time.Second)
+		deleted, err := w.dbClient.DeleteHistory(before)
+		if err != nil {
+			w.logger.Errorw("history retention error", "error", err)
+			return
+		}
+		w.logger.Infow("history retention completed", "before", before, "deleted", deleted)
+	}
+}
+
+// CompactDatabase はデータベースファイルを再構築する (bolt の場合は bot の停止中に実行する)
+func CompactDatabase(config *Config) error {
+	path := databasePath(&config.Database)
+	before, after, err := db.CompactStore(config.Database.Driver, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %s: %d -> %d bytes\n", path, before, after)
+	return nil
+}
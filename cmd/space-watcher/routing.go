@@ -0,0 +1,230 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/qitoi/space-watcher/bot"
+	"github.com/qitoi/space-watcher/db"
+	"github.com/qitoi/space-watcher/rule"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+// eventNames は条件式の event に使用するイベントの名前
+var eventNames = map[db.SpaceNotificationStatus]string{
+	db.SpaceNotificationStatus_SCHEDULE:        "schedule",
+	db.SpaceNotificationStatus_SCHEDULE_REMIND: "schedule_remind",
+	db.SpaceNotificationStatus_START:           "start",
+	db.SpaceNotificationStatus_END:             "end",
+	db.SpaceNotificationStatus_MILESTONE:       "milestone",
+	db.SpaceNotificationStatus_JOINED:          "joined",
+}
+
+// ruleVars は条件式から参照できる変数
+var ruleVars = map[string]bool{
+	"event":        true,
+	"creator":      true,
+	"creator_id":   true,
+	"title":        true,
+	"lang":         true,
+	"participants": true,
+	"is_ticketed":  true,
+}
+
+type compiledRule struct {
+	RuleConfig
+	expr *rule.Expr
+}
+
+// compileRule は条件式をコンパイルし、参照できない変数を使用している場合はエラーにする
+func compileRule(src string) (*rule.Expr, error) {
+	expr, err := rule.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range expr.Vars() {
+		if !ruleVars[v] {
+			return nil, fmt.Errorf("undefined variable: %s", v)
+		}
+	}
+	return expr, nil
+}
+
+func compileRouting(conf *RoutingConfig) ([]compiledRule, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	rules := make([]compiledRule, len(conf.Rules))
+	for i, r := range conf.Rules {
+		expr, err := compileRule(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("routing.rules[%d]: %w", i, err)
+		}
+		rules[i] = compiledRule{RuleConfig: r, expr: expr}
+	}
+	return rules, nil
+}
+
+func ruleEnv(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User) rule.Env {
+	env := rule.Env{
+		"event":        eventNames[status],
+		"creator":      user.Username,
+		"creator_id":   space.CreatorID,
+		"title":        space.Title,
+		"lang":         "",
+		"participants": int64(0),
+		"is_ticketed":  false,
+	}
+	if space.Lang != nil {
+		env["lang"] = *space.Lang
+	}
+	if space.ParticipantCount != nil {
+		env["participants"] = *space.ParticipantCount
+	}
+	if space.IsTicketed != nil {
+		env["is_ticketed"] = *space.IsTicketed
+	}
+	return env
+}
+
+// route は通知先と、規則で指定されたテンプレート
+type route struct {
+	sink    string
+	message string
+}
+
+// getRoutes は通知ステータスとスペースに対する通知先を返す
+// routing が設定されていない場合はイベントの設定から通知先を決める
+func (w *watcher) getRoutes(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User) ([]route, error) {
+	if w.config.Routing == nil {
+		conf, err := w.getEventConfig(status, user)
+		if err != nil || conf == nil {
+			return nil, err
+		}
+
+		var routes []route
+		if conf.Command != nil {
+			routes = append(routes, route{sink: sinkCommand})
+		}
+		if conf.Notification != nil {
+			routes = append(routes, route{sink: sinkTweet})
+		}
+		return routes, nil
+	}
+
+	// 作成者ごとの設定で無効にしたイベントは規則に関わらず通知しない
+	if override := w.getCreatorEventConfig(status, user); override != nil && override.Enabled != nil && !*override.Enabled {
+		return nil, nil
+	}
+
+	env := ruleEnv(status, space, user)
+	var routes []route
+	seen := make(map[string]bool)
+	for i, r := range w.rules {
+		matched, err := r.expr.Match(env)
+		if err != nil {
+			// 評価できない規則は一致しなかったものとして扱う
+			w.logger.Errorw("rule error", "rule", i, "name", r.Name, "space_id", space.ID, "error", err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+		for _, sink := range r.Sinks {
+			if !seen[sink] {
+				seen[sink] = true
+				routes = append(routes, route{sink: sink, message: r.Message})
+			}
+		}
+		if w.config.Routing.Mode != RoutingModeAll {
+			break
+		}
+	}
+	return routes, nil
+}
+
+// getSinks は通知ステータスとスペースに対する通知先の名前を返す
+func (w *watcher) getSinks(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User) ([]string, error) {
+	routes, err := w.getRoutes(status, space, user)
+	if err != nil {
+		return nil, err
+	}
+	sinks := make([]string, len(routes))
+	for i, r := range routes {
+		sinks[i] = r.sink
+	}
+	return sinks, nil
+}
+
+// getSinkMessage は通知先に投稿するテンプレートを返す
+// 規則、作成者ごとの設定、通知先、イベントのテンプレートの順に優先する
+func (w *watcher) getSinkMessage(status db.SpaceNotificationStatus, sink string, data *bot.MessageData) (string, error) {
+	routes, err := w.getRoutes(status, &data.Space, &data.User)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range routes {
+		if r.sink == sink && r.message != "" {
+			return r.message, nil
+		}
+	}
+
+	if override := w.getCreatorEventConfig(status, &data.User); override != nil && override.Message != "" {
+		return override.Message, nil
+	}
+
+	if sinks := w.config.Sinks; sinks != nil {
+		if conf := sinks.webhook(sink); conf != nil && conf.Message != "" {
+			return conf.Message, nil
+		}
+	}
+
+	conf, err := w.getEventConfig(status, &data.User)
+	if err != nil {
+		return "", err
+	}
+	if conf == nil || conf.Notification == nil {
+		return "", nil
+	}
+	return conf.Notification.Message, nil
+}
+
+// webhook は Webhook の通知先の設定を返す
+func (c *SinksConfig) webhook(sink string) *WebhookSinkConfig {
+	switch sink {
+	case sinkDiscord:
+		return c.Discord
+	case sinkSlack:
+		return c.Slack
+	}
+	return nil
+}
+
+// send は通知先に通知する
+func (w *watcher) send(sink string, status db.SpaceNotificationStatus, data *bot.MessageData) error {
+	switch sink {
+	case sinkCommand:
+		return w.execCommand(status, data)
+	case sinkTweet:
+		return w.tweetSpace(status, data)
+	case sinkDiscord, sinkSlack:
+		return w.postWebhook(sink, status, data)
+	}
+	return errors.New("unknown sink: " + sink)
+}
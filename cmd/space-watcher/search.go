@@ -0,0 +1,127 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"time"
+
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+const (
+	defaultSearchInterval = 60
+)
+
+// startSearch はタイトルで検索したスペースを定期的に通知の処理に渡す
+// 検索の間隔はフォローしているユーザーのスペースの取得とは別に、ディスカバリーと共有する検索のレート制限から決める
+func (w *watcher) startSearch(ctx context.Context, conf *SearchConfig) {
+	baseInterval := conf.Interval
+	if baseInterval <= 0 {
+		baseInterval = defaultSearchInterval
+	}
+	interval := baseInterval
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			spaces, users, rate, err := w.searchSpaces(ctx, conf)
+			if err != nil {
+				w.logger.Errorw("search spaces error", "error", err)
+			}
+			w.logger.Infow("search spaces result", "spaces", spaces, "users", users, "rate", rate)
+
+			if len(spaces) > 0 {
+				if err := w.processSpaces(spaces, users); err != nil {
+					w.logger.Errorw("notify searched space error", "error", err)
+				}
+			}
+
+			if nextInterval := pollInterval(rate, baseInterval, interval, w.spacesSearchRequests()); nextInterval != interval {
+				interval = nextInterval
+				ticker.Reset(time.Duration(interval) * time.Second)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// spacesSearchRequests は spaces/search のレート制限を共有する検索とディスカバリーの 1 回あたりのリクエスト数の合計を返す
+// それぞれがこの回数で間隔を決めることで、合計でレート制限の残りの回数に収まる
+func (w *watcher) spacesSearchRequests() int {
+	requests := 0
+	if w.config.Search != nil {
+		requests += len(w.config.Search.Queries)
+	}
+	if w.config.Discovery != nil {
+		requests += len(w.config.Discovery.Queries)
+	}
+	return requests
+}
+
+// searchSpaces はすべてのクエリで検索し、重複を除いたスペースと最後のレート制限を返す
+// 一部のクエリが失敗しても、成功したクエリの結果は返す
+func (w *watcher) searchSpaces(ctx context.Context, conf *SearchConfig) ([]twitter2.Space, map[string]twitter2.User, *twitter2.RateLimit, error) {
+	state := conf.State
+	if state == "" {
+		state = SearchStateAll
+	}
+
+	var spaces []twitter2.Space
+	users := make(map[string]twitter2.User)
+	seen := make(map[string]bool)
+	var rate *twitter2.RateLimit
+	var lastErr error
+
+	for _, query := range conf.Queries {
+		resp, r, err := w.clientV2.SearchSpaces(ctx, twitter2.SpacesSearchRequest{
+			Query:       query,
+			State:       state,
+			Expansions:  spaceExpansions,
+			SpaceFields: spaceFields,
+			UserFields:  userFields,
+		})
+		if r != nil {
+			rate = r
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, s := range resp.Data {
+			if !seen[s.ID] {
+				seen[s.ID] = true
+				spaces = append(spaces, s)
+			}
+		}
+		if resp.Includes != nil && resp.Includes.Users != nil {
+			for _, u := range *resp.Includes.Users {
+				users[u.ID] = u
+			}
+		}
+	}
+
+	return spaces, users, rate, lastErr
+}
@@ -0,0 +1,265 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+const (
+	// spaces の ids に指定できる上限
+	maxLookupSpaces = 100
+)
+
+// processStaleSpaces はキャンセル・終了・日時変更されたスペースの告知ツイートを処理する
+func (w *watcher) processStaleSpaces(ctx context.Context, spaces []twitter2.Space, users map[string]twitter2.User) error {
+	records, err := w.dbClient.GetActiveSpaces()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]twitter2.Space)
+	for _, s := range spaces {
+		current[s.ID] = s
+	}
+
+	var missing []*db.Space
+	for _, record := range records {
+		if s, ok := current[record.Id]; ok {
+			u := users[s.CreatorID]
+			if err := w.checkRescheduled(record, &s, &u); err != nil {
+				return err
+			}
+			continue
+		}
+		missing = append(missing, record)
+	}
+
+	// 取得結果に含まれないスペースは個別に状態を確認する
+	for len(missing) > 0 {
+		n := len(missing)
+		if n > maxLookupSpaces {
+			n = maxLookupSpaces
+		}
+		if err := w.lookupStaleSpaces(ctx, missing[:n]); err != nil {
+			return err
+		}
+		missing = missing[n:]
+	}
+
+	return nil
+}
+
+func (w *watcher) lookupStaleSpaces(ctx context.Context, records []*db.Space) error {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.Id
+	}
+
+	resp, rate, err := w.clientV2.GetSpacesByIDs(ctx, twitter2.SpacesByIDsRequest{
+		IDs:         ids,
+		Expansions:  spaceExpansions,
+		SpaceFields: spaceFields,
+		UserFields:  userFields,
+	})
+	w.lookups.add(rate)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]twitter2.Space)
+	for _, s := range resp.Data {
+		found[s.ID] = s
+	}
+	notFound := resp.Errors.NotFoundIDs()
+	users := make(map[string]twitter2.User)
+	if resp.Includes != nil && resp.Includes.Users != nil {
+		for _, u := range *resp.Includes.Users {
+			users[u.ID] = u
+		}
+	}
+
+	for _, record := range records {
+		s, ok := found[record.Id]
+		if !ok {
+			// 削除されたスペースはキャンセル扱い
+			if notFound[record.Id] {
+				space, user := restoreSpace(record)
+				if err := w.closeSpace(record, db.StateCanceled, space, user, nil); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		u, ok := users[s.CreatorID]
+		if !ok {
+			_, user := restoreSpace(record)
+			u = *user
+		}
+
+		if s.State == nil {
+			continue
+		}
+		switch *s.State {
+		case db.StateScheduled:
+			if err := w.checkRescheduled(record, &s, &u); err != nil {
+				return err
+			}
+			if err := w.processJoined(&s, users); err != nil {
+				return err
+			}
+		case db.StateLive:
+			if err := w.processJoined(&s, users); err != nil {
+				return err
+			}
+		case db.StateEnded, db.StateCanceled:
+			if err := w.closeSpace(record, *s.State, &s, &u, users); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *watcher) checkRescheduled(record *db.Space, space *twitter2.Space, user *twitter2.User) error {
+	if record.NotificationStatus >= db.SpaceNotificationStatus_START || record.ScheduledStart == nil {
+		return nil
+	}
+	if space.State == nil || *space.State != db.StateScheduled || space.ScheduledStart == nil {
+		return nil
+	}
+	if space.ScheduledStart.Equal(record.ScheduledStart.AsTime()) {
+		return nil
+	}
+
+	w.logger.Infow("space rescheduled", "space", *space, "prev_scheduled_start", record.ScheduledStart.AsTime())
+
+	var conf *StaleItemConfig
+	if w.config.Event.Stale != nil {
+		conf = w.config.Event.Stale.Rescheduled
+	}
+	if conf != nil && len(record.Tweets) > 0 {
+		switch conf.Action {
+		case StaleActionDelete:
+			// 告知を削除して未通知に戻し、新しい日時で告知し直す
+			if err := w.deleteTweets(record.Id); err != nil {
+				return err
+			}
+			return w.dbClient.DeleteSpace(record.Id)
+		case StaleActionReply:
+			if err := w.replyStale(conf, record, space, user); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.dbClient.SetScheduledStart(record.Id, *space.ScheduledStart)
+}
+
+func (w *watcher) closeSpace(record *db.Space, state string, space *twitter2.Space, user *twitter2.User, users map[string]twitter2.User) error {
+	w.logger.Infow("space closed", "space_id", record.Id, "state", state)
+
+	// 開始前に終了・キャンセルされたスペースの告知を訂正する
+	// 参加の通知のみのスペースは予約を告知していないため訂正しない
+	var conf *StaleItemConfig
+	if w.config.Event.Stale != nil {
+		conf = w.config.Event.Stale.Canceled
+	}
+	if conf != nil && len(record.Tweets) > 0 && record.NotificationStatus > db.SpaceNotificationStatus_NONE && record.NotificationStatus < db.SpaceNotificationStatus_START {
+		switch conf.Action {
+		case StaleActionDelete:
+			if err := w.deleteTweets(record.Id); err != nil {
+				return err
+			}
+		case StaleActionReply:
+			if err := w.replyStale(conf, record, space, user); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.recordObservation(space, user, state)
+
+	// 開始を通知したスペースは終了を通知する
+	end, err := w.getEventConfig(db.SpaceNotificationStatus_END, user)
+	if err != nil {
+		return err
+	}
+	if state == db.StateEnded && end != nil && record.NotificationStatus == db.SpaceNotificationStatus_START {
+		s := *space
+		restored, _ := restoreSpace(record)
+		if s.StartedAt == nil {
+			s.StartedAt = restored.StartedAt
+		}
+		if s.CreatedAt == nil {
+			s.CreatedAt = restored.CreatedAt
+		}
+		if s.StartedAt != nil && s.CreatedAt != nil {
+			if err := w.claimAndNotify(db.SpaceNotificationStatus_END, &s, user, users); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.dbClient.SetState(record.Id, state)
+}
+
+func (w *watcher) replyStale(conf *StaleItemConfig, record *db.Space, space *twitter2.Space, user *twitter2.User) error {
+	data, err := w.newMessageData(space, user)
+	if err != nil {
+		return err
+	}
+	message, err := data.RenderTweet(conf.Message)
+	if err != nil {
+		return err
+	}
+	return w.postTweet(record.Id, record.NotificationStatus, message, nil)
+}
+
+// restoreSpace は API から取得できないスペースの情報を記録から復元する
+func restoreSpace(record *db.Space) (*twitter2.Space, *twitter2.User) {
+	space := &twitter2.Space{
+		ID:        record.Id,
+		CreatorID: record.CreatorId,
+		Title:     record.Title,
+	}
+	if record.ScheduledStart != nil {
+		t := record.ScheduledStart.AsTime()
+		space.ScheduledStart = &t
+	}
+	if record.StartedAt != nil {
+		t := record.StartedAt.AsTime()
+		space.StartedAt = &t
+	}
+	if record.CreatedAt != nil {
+		t := record.CreatedAt.AsTime()
+		space.CreatedAt = &t
+	}
+
+	user := &twitter2.User{
+		ID:       record.CreatorId,
+		Name:     record.ScreenName,
+		Username: record.ScreenName,
+	}
+
+	return space, user
+}
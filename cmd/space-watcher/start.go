@@ -31,6 +31,7 @@ import (
 	twitter11 "github.com/dghubble/go-twitter/twitter"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/qitoi/space-watcher/bot"
 	"github.com/qitoi/space-watcher/db"
@@ -39,12 +40,58 @@ import (
 	twitter2 "github.com/qitoi/space-watcher/twitter"
 )
 
+const (
+	defaultDatabasePath = "./space-watcher.db"
+)
+
+var (
+	spaceExpansions = []string{"creator_id", "host_ids", "speaker_ids", "invited_user_ids"}
+	spaceFields     = []string{"id", "title", "creator_id", "state", "started_at", "scheduled_start", "created_at", "updated_at", "participant_count", "host_ids", "speaker_ids", "invited_user_ids", "lang", "is_ticketed"}
+	userFields      = []string{"id", "name", "username", "profile_image_url"}
+)
+
+// openDatabase は設定されたストレージを開く
+func openDatabase(config *DatabaseConfig) (db.Store, error) {
+	return db.OpenStore(config.Driver, databasePath(config))
+}
+
+func databasePath(config *DatabaseConfig) string {
+	if config.Path != "" {
+		return config.Path
+	}
+	return defaultDatabasePath
+}
+
 type watcher struct {
-	config    *Config
-	logger    *zap.SugaredLogger
-	clientV11 *twitter11.Client
-	clientV2  *twitter2.Client
-	dbClient  *db.Client
+	config      *Config
+	logger      *zap.SugaredLogger
+	clientV11   *twitter11.Client
+	clientV2    *twitter2.Client
+	mediaClient *twitter2.MediaClient
+	dbClient    db.Store
+	card        *bot.CardRenderer
+	rules       []compiledRule
+	filters     map[db.SpaceNotificationStatus]*spaceFilter
+
+	// watched は監視対象のユーザー ID
+	watched map[string]bool
+
+	// lookups は 1 回の取得の間にスペースを ID で取得した回数とレート制限
+	lookups lookupUsage
+}
+
+// lookupUsage はスペースを ID で取得したリクエストの数と、最後に取得したときのレート制限
+type lookupUsage struct {
+	rate     *twitter2.RateLimit
+	requests int
+}
+
+// add はスペースを ID で取得したリクエストを記録する
+func (u *lookupUsage) add(rate *twitter2.RateLimit) {
+	u.requests++
+	if rate != nil {
+		u.rate = rate
+	}
 }
 
 func Start(config *Config) error {
@@ -65,27 +112,28 @@ func Start(config *Config) error {
 	// signal handler (usr1: reopen log file)
 	startSignalHandler(log)
 
-	dbClient, err := db.Open("./space-watcher.db")
+	dbClient, err := openDatabase(&config.Database)
+	if err != nil {
+		return err
+	}
 	defer dbClient.Close()
 
-	// twitter api v1.1 client
-	auth := oauth1.NewAuth(config.Twitter.ConsumerKey, config.Twitter.ConsumerSecret)
-	httpClient := auth.GetHttpClient(context.Background(), config.Twitter.AccessToken, config.Twitter.AccessSecret)
-	clientV11 := twitter11.NewClient(httpClient)
-
-	// twitter api v2 client
-	clientV2 := twitter2.NewClient(config.Twitter.BearerToken)
-
-	w := &watcher{
-		config:    config,
-		logger:    log.Sugar(),
-		clientV11: clientV11,
-		clientV2:  clientV2,
-		dbClient:  dbClient,
+	w, err := newWatcher(config, log.Sugar(), dbClient)
+	if err != nil {
+		return err
 	}
 
 	w.logger.Infow("start", "bot_id", w.config.Twitter.UserID)
 
+	// 前回の異常終了で確定されなかった通知の確保を解放する
+	recovered, err := dbClient.RecoverClaims()
+	if err != nil {
+		return err
+	}
+	for _, s := range recovered {
+		w.logger.Warnw("recover pending claim", "space_id", s.Id, "status", s.Claim.NotificationStatus, "claimed_at", s.Claim.ClaimedAt.AsTime())
+	}
+
 	// monitoring target = followings
 	ids, err := w.getFollowings(config.Twitter.UserID)
 	if err != nil {
@@ -98,6 +146,44 @@ func Start(config *Config) error {
 
 	w.logger.Infow("target users", "users", creatorIDs)
 
+	w.watched = make(map[string]bool)
+	for _, id := range creatorIDs {
+		w.watched[id] = true
+	}
+
+	if retention := config.Database.Retention; retention != nil {
+		w.startRetention(ctx, retention)
+	}
+
+	if backup := config.Database.Backup; backup != nil {
+		if config.Database.Driver == db.DriverMemory {
+			w.logger.Warnw("backup is disabled for memory store")
+		} else {
+			w.startBackup(ctx, backup)
+		}
+	}
+
+	if conf := config.Report; conf != nil && conf.Weekday != "" {
+		w.startReport(ctx, conf)
+	}
+
+	if conf := config.Discovery; conf != nil {
+		w.startDiscovery(ctx, conf)
+	}
+
+	if conf := config.Search; conf != nil {
+		w.startSearch(ctx, conf)
+	}
+
+	if conf := config.Links; conf != nil {
+		w.startLinks(ctx, conf, creatorIDs)
+	}
+
+	// start http server for admin
+	if config.Admin.Enabled {
+		w.startAdminServer(&w.config.Admin)
+	}
+
 	// start http server for health check
 	if config.HealthCheck.Enabled {
 		w.startHealthCheckServer(*w.config.HealthCheck.Port)
@@ -108,6 +194,49 @@ func Start(config *Config) error {
 	return nil
 }
 
+// newWatcher は Twitter のクライアントを作成し、dbClient に通知状態を保存する watcher を返す
+func newWatcher(config *Config, logger *zap.SugaredLogger, dbClient db.Store) (*watcher, error) {
+	// twitter api v1.1 client
+	httpClient := newHTTPClientV11(config)
+	clientV11 := twitter11.NewClient(httpClient)
+	mediaClient := twitter2.NewMediaClient(httpClient)
+
+	// twitter api v2 client
+	clientV2 := twitter2.NewClient(config.Twitter.BearerToken)
+
+	card, err := newCardRenderer(config.Card)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := compileRouting(config.Routing)
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := compileFilters(&config.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &watcher{
+		config:      config,
+		logger:      logger,
+		clientV11:   clientV11,
+		clientV2:    clientV2,
+		mediaClient: mediaClient,
+		dbClient:    dbClient,
+		card:        card,
+		rules:       rules,
+		filters:     filters,
+	}, nil
+}
+
+func newHTTPClientV11(config *Config) *http.Client {
+	auth := oauth1.NewAuth(config.Twitter.ConsumerKey, config.Twitter.ConsumerSecret)
+	return auth.GetHttpClient(context.Background(), config.Twitter.AccessToken, config.Twitter.AccessSecret)
+}
+
 func getLogger(config *Config) (*logger.Logger, error) {
 	var err error
 	infoLog := logger.Wrap(os.Stdout)
@@ -136,38 +265,75 @@ func (w *watcher) startWatch(ctx context.Context, creatorIDs []string) {
 
 	ticker := time.NewTicker(time.Duration(interval) * time.Second)
 	for range ticker.C {
-		spaces, users, rate, err := w.getSpaces(ctx, creatorIDs)
+		rate, lookups := w.watch(ctx, creatorIDs)
+
+		if nextInterval := watchInterval(rate, lookups, baseInterval, interval); nextInterval != interval {
+			interval = nextInterval
+			ticker.Reset(time.Duration(interval) * time.Second)
+		}
+	}
+}
+
+// watch は監視対象のユーザーのスペースを 1 度取得して処理し、取得時のレート制限と ID での取得の回数を返す
+func (w *watcher) watch(ctx context.Context, creatorIDs []string) (*twitter2.RateLimit, lookupUsage) {
+	w.lookups = lookupUsage{}
+
+	spaces, users, rate, err := w.getSpaces(ctx, creatorIDs)
+	if err != nil {
+		w.logger.Errorw("watch spaces error", "error", err)
+	}
+	w.logger.Infow("watch spaces result", "spaces", spaces, "users", users, "rate", rate)
+
+	if spaces != nil && users != nil {
+		err = w.processSpaces(spaces, users)
 		if err != nil {
-			w.logger.Errorw("watch spaces error", "error", err)
+			w.logger.Errorw("notify space error", "error", err)
 		}
-		w.logger.Infow("watch spaces result", "spaces", spaces, "users", users, "rate", rate)
 
-		if spaces != nil && users != nil {
-			err = w.processSpaces(spaces, users)
-			if err != nil {
-				w.logger.Errorw("notify space error", "error", err)
-			}
+		// 記録の状態は終了の通知・訂正だけでなく保持期間やレポートにも使うため、常に確認する
+		err = w.processStaleSpaces(ctx, spaces, users)
+		if err != nil {
+			w.logger.Errorw("stale space error", "error", err)
 		}
+	}
 
-		if rate != nil {
-			resetTime := rate.Reset.Sub(time.Now()).Seconds()
-			intervalForReset := int64(math.Ceil(resetTime / float64(rate.Remaining+1)))
-			nextInterval := interval
+	if err := w.processWatchedSpaces(ctx); err != nil {
+		w.logger.Errorw("watched space error", "error", err)
+	}
 
-			if intervalForReset != interval {
-				nextInterval = intervalForReset
-			}
+	if err := w.processOutbox(); err != nil {
+		w.logger.Errorw("outbox error", "error", err)
+	}
 
-			if nextInterval < baseInterval {
-				nextInterval = baseInterval
-			}
+	return rate, w.lookups
+}
 
-			if nextInterval != interval {
-				interval = nextInterval
-				ticker.Reset(time.Duration(interval) * time.Second)
-			}
+// watchInterval は作成者での取得と ID での取得のどちらのレート制限の残りの回数にも収まる間隔を返す
+// ID での取得は作成者での取得と別のレート制限を使用する
+func watchInterval(rate *twitter2.RateLimit, lookups lookupUsage, baseInterval, interval int64) int64 {
+	nextInterval := pollInterval(rate, baseInterval, interval, 1)
+	if lookups.rate != nil {
+		if i := pollInterval(lookups.rate, baseInterval, interval, lookups.requests); i > nextInterval {
+			nextInterval = i
 		}
 	}
+	return nextInterval
+}
+
+// pollInterval は 1 回の取得で requests 回リクエストする場合に、レート制限のリセットまでに残りの回数を使い切る間隔を返す
+// 間隔は baseInterval より短くしない
+func pollInterval(rate *twitter2.RateLimit, baseInterval, interval int64, requests int) int64 {
+	if rate == nil {
+		return interval
+	}
+
+	resetTime := rate.Reset.Sub(time.Now()).Seconds()
+	nextInterval := int64(math.Ceil(resetTime * float64(requests) / float64(rate.Remaining+1)))
+
+	if nextInterval < baseInterval {
+		nextInterval = baseInterval
+	}
+	return nextInterval
 }
 
 func (w *watcher) getFollowings(userID int64) ([]int64, error) {
@@ -186,9 +352,9 @@ func (w *watcher) getSpaces(ctx context.Context, creatorIDs []string) ([]twitter
 		ctx,
 		twitter2.SpacesByCreatorIDsRequest{
 			UserIDs:     creatorIDs,
-			Expansions:  []string{"creator_id"},
-			SpaceFields: []string{"id", "title", "creator_id", "state", "started_at", "scheduled_start", "created_at", "updated_at"},
-			UserFields:  []string{"id", "name", "username"},
+			Expansions:  spaceExpansions,
+			SpaceFields: spaceFields,
+			UserFields:  userFields,
 		})
 	if err != nil {
 		return nil, nil, rate, err
@@ -219,7 +385,7 @@ func (w *watcher) processSpaces(spaces []twitter2.Space, users map[string]twitte
 		u := users[s.CreatorID]
 		go func() {
 			defer wg.Done()
-			ch <- w.processSpace(&s, &u)
+			ch <- w.processSpace(&s, &u, users)
 		}()
 	}
 
@@ -239,43 +405,104 @@ func (w *watcher) processSpaces(spaces []twitter2.Space, users map[string]twitte
 	return err
 }
 
-func (w *watcher) processSpace(space *twitter2.Space, user *twitter2.User) error {
-	currentStatus, err := w.getNotificationStatus(space)
+// processSpace はスペースの状態に応じて通知する
+// users はホスト・スピーカー・招待されたユーザーの解決に使用する
+func (w *watcher) processSpace(space *twitter2.Space, user *twitter2.User, users map[string]twitter2.User) error {
+	if space.State != nil {
+		w.recordObservation(space, user, *space.State)
+		if *space.State == db.StateLive && space.ParticipantCount != nil {
+			w.recordParticipantCount(space)
+			if err := w.processMilestones(space, user, users); err != nil {
+				return err
+			}
+		}
+	}
+
+	currentStatus, err := w.getNotificationStatus(space, user)
 	if err != nil {
 		return err
 	}
 
-	if notified, err := w.dbClient.CheckNotified(space.ID, currentStatus); err != nil {
+	if currentStatus == db.SpaceNotificationStatus_NONE {
+		return nil
+	}
+
+	return w.claimAndNotify(currentStatus, space, user, users)
+}
+
+func (w *watcher) claimAndNotify(currentStatus db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User, users map[string]twitter2.User) error {
+	// 通知済みの確認と通知の確保を同時に行い、重複した通知を防ぐ
+	if claimed, err := w.dbClient.Claim(space.ID, currentStatus); err != nil {
 		return err
-	} else if notified {
+	} else if !claimed {
 		return nil
 	}
 
 	w.logger.Infow("notify", "space", *space, "user", *user, "status", currentStatus)
 
-	// exec command
-	if err := w.execCommand(currentStatus, space, user); err != nil {
+	if err := w.notifySpace(currentStatus, space, user, users); err != nil {
+		if e := w.dbClient.FailClaim(space.ID, currentStatus); e != nil {
+			w.logger.Errorw("fail claim error", "space_id", space.ID, "status", currentStatus, "error", e)
+		}
 		return err
 	}
 
-	// tweet
-	if err := w.tweetSpace(currentStatus, space, user); err != nil {
+	return nil
+}
+
+func (w *watcher) notifySpace(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User, users map[string]twitter2.User) error {
+	// 通知先ごとの配送は outbox から行う
+	// 条件に一致しないスペースは配送せず、通知済みとして記録する
+	if reason := w.rejectReason(status, space, user); reason != "" {
+		w.logger.Infow("space filtered", "space_id", space.ID, "status", status, "title", space.Title, "reason", reason)
+	} else if err := w.enqueueDeliveries(status, space, user, users); err != nil {
 		return err
 	}
 
-	switch currentStatus {
+	switch status {
 	case db.SpaceNotificationStatus_SCHEDULE:
 		return w.dbClient.RegisterSchedule(space.ID, user.ID, user.Username, space.Title, *space.ScheduledStart, *space.CreatedAt)
 	case db.SpaceNotificationStatus_SCHEDULE_REMIND:
 		return w.dbClient.RegisterScheduleRemind(space.ID, user.ID, user.Username, space.Title, *space.ScheduledStart, *space.CreatedAt)
 	case db.SpaceNotificationStatus_START:
 		return w.dbClient.RegisterStart(space.ID, user.ID, user.Username, space.Title, *space.StartedAt, *space.CreatedAt)
+	case db.SpaceNotificationStatus_END:
+		return w.dbClient.RegisterEnd(space.ID, user.ID, user.Username, space.Title, *space.StartedAt, *space.CreatedAt)
 	}
 
 	return nil
 }
 
-func (w *watcher) getNotificationStatus(space *twitter2.Space) (db.SpaceNotificationStatus, error) {
+// recordObservation はスペースの状態の変化を履歴に記録する
+func (w *watcher) recordObservation(space *twitter2.Space, user *twitter2.User, state string) {
+	e := &db.Event{
+		SpaceId:    space.ID,
+		CreatorId:  space.CreatorID,
+		ScreenName: user.Username,
+		State:      state,
+		Title:      space.Title,
+	}
+	if space.ScheduledStart != nil {
+		e.ScheduledStart = timestamppb.New(*space.ScheduledStart)
+	}
+	if space.StartedAt != nil {
+		e.StartedAt = timestamppb.New(*space.StartedAt)
+	}
+
+	if err := w.dbClient.RecordObservation(e); err != nil {
+		w.logger.Errorw("record history error", "space_id", space.ID, "error", err)
+	}
+}
+
+// recordParticipantCount は配信中のスペースの参加者数を記録する
+func (w *watcher) recordParticipantCount(space *twitter2.Space) {
+	if err := w.dbClient.AddParticipantCount(space.ID, time.Now(), *space.ParticipantCount); err != nil {
+		w.logger.Errorw("record participant count error", "space_id", space.ID, "error", err)
+	}
+}
+
+// getNotificationStatus はスペースの状態と作成者の設定から通知ステータスを返す
+func (w *watcher) getNotificationStatus(space *twitter2.Space, user *twitter2.User) (db.SpaceNotificationStatus, error) {
 	if space.State == nil {
 		return db.SpaceNotificationStatus_NONE, errors.New("invalid space info")
 	}
@@ -287,15 +514,15 @@ func (w *watcher) getNotificationStatus(space *twitter2.Space) (db.SpaceNotifica
 
 		// リマインド通知が有効で、リマインド時間を過ぎていればリマインド
 		start := *space.ScheduledStart
-		if w.config.Event.ScheduleRemind != nil {
-			reminderTime := start.Add(-time.Duration(w.config.Event.ScheduleRemind.Before) * time.Second)
+		if remind, _ := w.getEventConfig(db.SpaceNotificationStatus_SCHEDULE_REMIND, user); remind != nil {
+			reminderTime := start.Add(-time.Duration(remind.Before) * time.Second)
 			if time.Now().After(reminderTime) {
 				return db.SpaceNotificationStatus_SCHEDULE_REMIND, nil
 			}
 		}
 
 		// スケジュール作成通知が有効
-		if w.config.Event.Schedule != nil {
+		if schedule, _ := w.getEventConfig(db.SpaceNotificationStatus_SCHEDULE, user); schedule != nil {
 			return db.SpaceNotificationStatus_SCHEDULE, nil
 		}
 
@@ -304,88 +531,174 @@ func (w *watcher) getNotificationStatus(space *twitter2.Space) (db.SpaceNotifica
 		// 開始済み
 		return db.SpaceNotificationStatus_START, nil
 	}
+	// 終了は記録の状態と合わせて closeSpace で通知する
 
 	return db.SpaceNotificationStatus_NONE, nil
 }
 
-func (w *watcher) tweetSpace(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User) error {
-	var conf *EventItemConfig
+// getEventConfig はイベントの設定に作成者ごとの設定を適用して返す
+// user が nil の場合は event の設定を返す
+func (w *watcher) getEventConfig(status db.SpaceNotificationStatus, user *twitter2.User) (*EventItemConfig, error) {
+	conf, err := eventItemConfig(&w.config.Event, status)
+	if err != nil {
+		return nil, err
+	}
+	return mergeEventConfig(conf, w.getCreatorEventConfig(status, user)), nil
+}
+
+// eventItemConfig はクリエイターごとの上書きを適用する前のイベントの設定を返す
+func eventItemConfig(event *EventConfig, status db.SpaceNotificationStatus) (*EventItemConfig, error) {
 	switch status {
 	case db.SpaceNotificationStatus_SCHEDULE:
-		conf = w.config.Event.Schedule
-		break
+		return event.Schedule, nil
 	case db.SpaceNotificationStatus_SCHEDULE_REMIND:
-		conf = w.config.Event.ScheduleRemind
-		break
+		return event.ScheduleRemind, nil
 	case db.SpaceNotificationStatus_START:
-		conf = w.config.Event.Start
-		break
+		return event.Start, nil
+	case db.SpaceNotificationStatus_END:
+		return event.End, nil
+	case db.SpaceNotificationStatus_MILESTONE:
+		return event.Milestone, nil
+	case db.SpaceNotificationStatus_JOINED:
+		return event.Joined, nil
 	default:
-		return errors.New("invalid notification status")
+		return nil, errors.New("invalid notification status")
 	}
+}
 
-	if conf == nil || conf.Notification == nil {
-		return nil
+func (w *watcher) tweetSpace(status db.SpaceNotificationStatus, data *bot.MessageData) error {
+	conf, err := w.getEventConfig(status, &data.User)
+	if err != nil {
+		return err
 	}
 
-	template := conf.Notification.Message
-	message, err := bot.RenderTemplate(template, space, user)
+	template, err := w.getSinkMessage(status, sinkTweet, data)
 	if err != nil {
 		return err
 	}
-	tweet, _, err := w.clientV11.Statuses.Update(message, nil)
+	if template == "" {
+		return errors.New("message is not configured: " + sinkTweet)
+	}
+	message, err := data.RenderTweet(template)
 	if err != nil {
 		return err
 	}
-	w.logger.Infow("tweet completed", "message", message, "tweet_id", tweet.ID)
+
+	var mediaIDs []int64
+	if conf != nil && conf.Notification != nil && conf.Notification.Card != nil {
+		card := conf.Notification.Card
+		mediaID, err := w.uploadCard(context.Background(), status, card.AltText, data)
+		if err != nil {
+			// 画像の添付に失敗してもテキストのみで通知する
+			w.logger.Errorw("card upload error", "space_id", data.Space.ID, "error", err)
+		} else {
+			mediaIDs = append(mediaIDs, mediaID)
+		}
+	}
+
+	return w.postTweet(data.Space.ID, status, message, mediaIDs)
+}
+
+func (w *watcher) postTweet(spaceID string, status db.SpaceNotificationStatus, message string, mediaIDs []int64) error {
+	// 同じスペースの通知はスレッドにまとめる
+	params := &twitter11.StatusUpdateParams{
+		MediaIds: mediaIDs,
+	}
+	tweets, err := w.dbClient.GetTweets(spaceID)
+	if err != nil {
+		return err
+	}
+	if len(tweets) > 0 {
+		params.InReplyToStatusID = tweets[len(tweets)-1].Id
+	}
+
+	tweet, _, err := w.clientV11.Statuses.Update(message, params)
+	if err != nil {
+		return err
+	}
+	w.logger.Infow("tweet completed", "message", message, "tweet_id", tweet.ID, "in_reply_to", params.InReplyToStatusID)
+
+	// 投稿済みのツイートを再送しないよう、記録に失敗しても配信済みとして扱う
+	if err := w.dbClient.AddTweet(spaceID, status, tweet.ID); err != nil {
+		w.logger.Errorw("add tweet error", "space_id", spaceID, "tweet_id", tweet.ID, "error", err)
+	}
+
 	return nil
 }
 
-func (w *watcher) execCommand(status db.SpaceNotificationStatus, space *twitter2.Space, user *twitter2.User) error {
-	var conf *EventItemConfig
-	switch status {
-	case db.SpaceNotificationStatus_SCHEDULE:
-		conf = w.config.Event.Schedule
-		break
-	case db.SpaceNotificationStatus_SCHEDULE_REMIND:
-		conf = w.config.Event.ScheduleRemind
-		break
-	case db.SpaceNotificationStatus_START:
-		conf = w.config.Event.Start
-		break
-	default:
-		return errors.New("invalid notification status")
+func (w *watcher) deleteTweets(spaceID string) error {
+	tweets, err := w.dbClient.GetTweets(spaceID)
+	if err != nil {
+		return err
 	}
 
-	if conf == nil || conf.Command == nil {
-		return nil
+	for _, tweet := range tweets {
+		_, _, err := w.clientV11.Statuses.Destroy(tweet.Id, nil)
+		if err != nil && !isTweetNotFound(err) {
+			return err
+		}
+		w.logger.Infow("tweet deleted", "space_id", spaceID, "tweet_id", tweet.Id)
 	}
 
-	go func() {
-		args := make([]string, len(conf.Command.Args))
-		for i, s := range conf.Command.Args {
-			arg, err := bot.RenderTemplate(s, space, user)
-			if err != nil {
-				w.logger.Errorw("command build error", "error", err)
-				return
+	return w.dbClient.ClearTweets(spaceID)
+}
+
+func isTweetNotFound(err error) bool {
+	// 144: No status found with that ID.
+	if apiErr, ok := err.(twitter11.APIError); ok {
+		for _, e := range apiErr.Errors {
+			if e.Code == 144 {
+				return true
 			}
-			args[i] = arg
 		}
+	}
+	return false
+}
+
+func (w *watcher) execCommand(status db.SpaceNotificationStatus, data *bot.MessageData) error {
+	conf, err := w.getEventConfig(status, &data.User)
+	if err != nil {
+		return err
+	}
 
-		stderr := &bytes.Buffer{}
-		cmd := createCommand(conf.Command.Name, args)
-		cmd.Dir = conf.Command.WorkingDirectory
+	if conf == nil || conf.Command == nil {
+		return errors.New("command is not configured")
+	}
 
-		if conf.Command.CaptureStderr {
-			cmd.Stderr = stderr
+	return w.runCommand(conf.Command, data.Render)
+}
+
+// runCommand は render で展開した引数でコマンドを起動する
+// 録音などの長時間のコマンドで監視を止めないよう、起動できた時点で配信済みとし、終了はログに残す
+func (w *watcher) runCommand(conf *CommandConfig, render func(string) (string, error)) error {
+	args := make([]string, len(conf.Args))
+	for i, s := range conf.Args {
+		arg, err := render(s)
+		if err != nil {
+			return err
 		}
+		args[i] = arg
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd := createCommand(conf.Name, args)
+	cmd.Dir = conf.WorkingDirectory
+
+	if conf.CaptureStderr {
+		cmd.Stderr = stderr
+	}
 
-		w.logger.Infow("command start", "command", cmd.String())
-		if err := cmd.Run(); err != nil {
-			w.logger.Errorw("command exec error", "error", err, "stderr", stderr.String())
+	w.logger.Infow("command start", "command", cmd.String())
+	if err := cmd.Start(); err != nil {
+		w.logger.Errorw("command start error", "command", cmd.String(), "error", err)
+		return err
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			w.logger.Errorw("command exec error", "command", cmd.String(), "error", err, "stderr", stderr.String())
 			return
 		}
-
 		w.logger.Infow("command completed", "command", cmd.String(), "code", cmd.ProcessState.ExitCode())
 	}()
 
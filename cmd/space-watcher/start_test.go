@@ -0,0 +1,1712 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	twitter11 "github.com/dghubble/go-twitter/twitter"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/qitoi/space-watcher/bot"
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+// newTestWatcher はメモリ上のストレージを使用する watcher を返す
+func newTestWatcher(t *testing.T, conf string) (*watcher, db.Store) {
+	t.Helper()
+
+	var config Config
+	if err := yaml.Unmarshal([]byte(conf), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	store := db.NewMemoryClient()
+	w, err := newWatcher(&config, zap.NewNop().Sugar(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, store
+}
+
+func newTestSpace(state string, scheduledStart time.Time) *twitter2.Space {
+	now := time.Now()
+	space := &twitter2.Space{
+		ID:        "space1",
+		CreatorID: "user1",
+		Title:     "title",
+		State:     &state,
+		CreatedAt: &now,
+	}
+	if state == "scheduled" {
+		space.ScheduledStart = &scheduledStart
+	} else {
+		space.StartedAt = &now
+	}
+	return space
+}
+
+// newTestAPI は API v2 への要求にパスごとの応答を返すサーバーを watcher に設定する
+// 応答は呼び出し元で paths を更新して変更できる
+func newTestAPI(t *testing.T, w *watcher, paths map[string]string) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		body, ok := paths[r.URL.Path]
+		if !ok {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(res, `{"title":"Service Unavailable"}`)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		io.WriteString(res, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	w.clientV2 = twitter2.NewClientWithURL("", srv.URL+"/")
+}
+
+// testAPIV11 は API v1.1 に投稿・削除を依頼されたツイートを記録する
+type testAPIV11 struct {
+	updates     []url.Values
+	destroyed   []int64
+	notFound    map[int64]bool
+	unavailable bool
+}
+
+// newTestAPIV11 は API v1.1 への要求を記録するサーバーを watcher に設定する
+// 投稿したツイートには 1 から順に ID を割り当て、notFound の ID の削除は見つからないエラーを返す
+// unavailable の間はすべての要求に失敗する
+func newTestAPIV11(t *testing.T, w *watcher) *testAPIV11 {
+	t.Helper()
+
+	api := &testAPIV11{notFound: make(map[int64]bool)}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		switch {
+		case api.unavailable:
+			res.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(res, `{"errors":[{"code":130,"message":"Over capacity"}]}`)
+		case r.URL.Path == "/1.1/statuses/update.json":
+			api.updates = append(api.updates, r.PostForm)
+			fmt.Fprintf(res, `{"id":%d}`, len(api.updates))
+		case strings.HasPrefix(r.URL.Path, "/1.1/statuses/destroy/"):
+			id, _ := strconv.ParseInt(strings.TrimSuffix(path.Base(r.URL.Path), ".json"), 10, 64)
+			api.destroyed = append(api.destroyed, id)
+			if api.notFound[id] {
+				res.WriteHeader(http.StatusNotFound)
+				io.WriteString(res, `{"errors":[{"code":144,"message":"No status found with that ID."}]}`)
+				return
+			}
+			fmt.Fprintf(res, `{"id":%d}`, id)
+		default:
+			res.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(res, `{"errors":[{"code":130,"message":"Over capacity"}]}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.clientV11 = twitter11.NewClient(&http.Client{Transport: testTransport{target: target}})
+	return api
+}
+
+// testTransport は要求の宛先をテスト用のサーバーに置き換える
+type testTransport struct {
+	target *url.URL
+}
+
+func (t testTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// testSpaceJSON は startedAt に開始した space1 の API の応答を返す
+func testSpaceJSON(state string, startedAt time.Time) string {
+	t := startedAt.UTC().Format(time.RFC3339)
+	return fmt.Sprintf(`{"id":"space1","creator_id":"user1","title":"title","state":"%s","started_at":"%s","created_at":"%s"}`, state, t, t)
+}
+
+func TestProcessSpace(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    schedule:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+    schedule_remind:
+        before: 600
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+        command:
+            name: record
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	statuses := []struct {
+		space    *twitter2.Space
+		expected db.SpaceNotificationStatus
+	}{
+		{newTestSpace("scheduled", time.Now().Add(time.Hour)), db.SpaceNotificationStatus_SCHEDULE},
+		{newTestSpace("scheduled", time.Now().Add(time.Hour)), db.SpaceNotificationStatus_SCHEDULE},
+		{newTestSpace("scheduled", time.Now().Add(time.Minute)), db.SpaceNotificationStatus_SCHEDULE_REMIND},
+		{newTestSpace("live", time.Time{}), db.SpaceNotificationStatus_START},
+		{newTestSpace("live", time.Time{}), db.SpaceNotificationStatus_START},
+	}
+	for i, s := range statuses {
+		if err := w.processSpace(s.space, user, nil); err != nil {
+			t.Fatal(err)
+		}
+		status, err := store.GetNotifiedStatus("space1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != s.expected {
+			t.Errorf("processSpace[%d], actual: %v, expected: %v", i, status, s.expected)
+		}
+	}
+
+	// 同じステータスは 1 度だけ配送し、配送前に次のステータスになったものは破棄する
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("pending deliveries, actual: %d, expected: 2", len(pending))
+	}
+	for _, d := range pending {
+		if d.NotificationStatus != db.SpaceNotificationStatus_START {
+			t.Errorf("pending delivery, actual: %v, expected: %v", d.NotificationStatus, db.SpaceNotificationStatus_START)
+		}
+	}
+
+	superseded, err := store.GetDeliveries(db.DeliveryState_DELIVERY_SUPERSEDED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(superseded) != 2 {
+		t.Errorf("superseded deliveries, actual: %d, expected: 2", len(superseded))
+	}
+}
+
+func TestProcessSpaceDisabled(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	if err := w.processSpace(newTestSpace("scheduled", time.Now().Add(time.Hour)), user, nil); err != nil {
+		t.Fatal(err)
+	}
+	status, err := store.GetNotifiedStatus("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != db.SpaceNotificationStatus_NONE {
+		t.Errorf("processSpace, actual: %v, expected: %v", status, db.SpaceNotificationStatus_NONE)
+	}
+
+	// 通知しなかったスペースも観測した履歴は残す
+	events, err := store.GetHistory("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != db.EventType_EVENT_OBSERVED {
+		t.Errorf("GetHistory, actual: %v", events)
+	}
+}
+
+func TestCloseSpaceEnd(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+    end:
+        notification:
+            message: "{{.Space.Title}} {{.PeakParticipants}} {{.AverageParticipants}}"
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	// 配信中は取得のたびに参加者数を記録する
+	space := newTestSpace("live", time.Time{})
+	count := int64(10)
+	space.ParticipantCount = &count
+	if err := w.processSpace(space, user, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddParticipantCount("space1", time.Now().Add(time.Minute), 30); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := store.GetSpace("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.closeSpace(record, db.StateEnded, newTestSpace("ended", time.Time{}), user, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := store.GetNotifiedStatus("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != db.SpaceNotificationStatus_END {
+		t.Errorf("closeSpace, actual: %v, expected: %v", status, db.SpaceNotificationStatus_END)
+	}
+
+	data, err := w.newMessageData(newTestSpace("ended", time.Time{}), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := data.RenderTweet(w.config.Event.End.Notification.Message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "title 30 20"; actual != expected {
+		t.Errorf("RenderTweet, actual: %s, expected: %s", actual, expected)
+	}
+}
+
+func TestWatchRetention(t *testing.T) {
+	// 終了の通知・訂正を設定していなくても記録を閉じ、保持期間で削除する
+	w, store := newTestWatcher(t, `
+database:
+    retention:
+        max_age: 3600
+`)
+	users := `"includes":{"users":[{"id":"user1","name":"User","username":"user1"}]}`
+	paths := map[string]string{
+		"/spaces/by/creator_ids": `{"data":[` + testSpaceJSON("live", time.Now()) + `],` + users + `}`,
+		"/spaces":                `{"data":[` + testSpaceJSON("ended", time.Now()) + `],` + users + `}`,
+	}
+	newTestAPI(t, w, paths)
+
+	if _, lookups := w.watch(context.Background(), []string{"user1"}); lookups.requests != 0 {
+		t.Errorf("watch lookups, actual: %d, expected: 0", lookups.requests)
+	}
+	if active, err := store.GetActiveSpaces(); err != nil || len(active) != 1 {
+		t.Fatalf("GetActiveSpaces, actual: %v, %v", active, err)
+	}
+
+	// 一覧から消えたスペースは個別に確認して終了を記録し、間隔の計算に ID での取得を含める
+	paths["/spaces/by/creator_ids"] = `{"meta":{"result_count":0}}`
+	if _, lookups := w.watch(context.Background(), []string{"user1"}); lookups.requests != 1 || lookups.rate == nil {
+		t.Errorf("watch lookups, actual: %v", lookups)
+	}
+	s, err := store.GetSpace("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != db.StateEnded || s.ClosedAt == nil {
+		t.Fatalf("closed space, actual: %v", s)
+	}
+
+	deleted, err := store.DeleteClosedSpaces(time.Now().Add(time.Duration(w.config.Database.Retention.MaxAge) * time.Second))
+	if err != nil || deleted != 1 {
+		t.Errorf("DeleteClosedSpaces, actual: %v, %v", deleted, err)
+	}
+}
+
+func TestProcessMilestones(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    milestone:
+        thresholds: [100, 1000, 10000]
+        command:
+            name: "true"
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	// 開始を通知する前の節目は記録しない
+	for i, count := range []int64{1500, 1500, 20000} {
+		space := newTestSpace("live", time.Time{})
+		c := count
+		space.ParticipantCount = &c
+		if err := w.processSpace(space, user, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := store.GetSpace("space1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := [][]int64{nil, {100, 1000}, {100, 1000, 10000}}[i]
+		if len(s.Milestones) != len(expected) {
+			t.Fatalf("processSpace[%d] milestones, actual: %v, expected: %v", i, s.Milestones, expected)
+		}
+		for j := range expected {
+			if s.Milestones[j] != expected[j] {
+				t.Errorf("processSpace[%d] milestones, actual: %v, expected: %v", i, s.Milestones, expected)
+			}
+		}
+	}
+
+	// 通知した節目ごとに配送を登録する
+	due, err := store.GetDueDeliveries(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	milestones := make(map[string]int64)
+	for _, d := range due {
+		if d.NotificationStatus != db.SpaceNotificationStatus_MILESTONE {
+			continue
+		}
+		data, err := w.deliveryMessageData(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		milestones[d.Key] = data.Milestone
+	}
+	if len(milestones) != 2 || milestones["1000"] != 1000 || milestones["10000"] != 10000 {
+		t.Errorf("milestone deliveries, actual: %v", milestones)
+	}
+}
+
+func TestDeliveryMessageData(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+	users := map[string]twitter2.User{
+		"user1": *user,
+		"user2": {ID: "user2", Username: "user2"},
+		"user3": {ID: "user3", Username: "user3"},
+	}
+
+	space := newTestSpace("live", time.Time{})
+	space.HostIds = &[]string{"user1", "user2"}
+	space.SpeakerIDs = &[]string{"user3", "unknown"}
+	if err := w.processSpace(space, user, users); err != nil {
+		t.Fatal(err)
+	}
+
+	// 配送時には取得時に解決したユーザーを使用する
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("pending deliveries, actual: %d, expected: 1", len(pending))
+	}
+	data, err := w.deliveryMessageData(pending[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := data.Render("{{mentions .Hosts}} / {{mentions .Speakers}} / {{len .Invited}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "@.user1 @.user2 / @.user3 / 0"; actual != expected {
+		t.Errorf("Render, actual: %s, expected: %s", actual, expected)
+	}
+}
+
+func TestProcessJoined(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    joined:
+        command:
+            name: "true"
+`)
+	w.watched = map[string]bool{"user1": true, "user2": true}
+	users := map[string]twitter2.User{
+		"other": {ID: "other", Username: "other"},
+		"user2": {ID: "user2", Username: "user2"},
+	}
+
+	// 監視対象のユーザーのスペースは通常の通知で扱う
+	own := newTestSpace("live", time.Time{})
+	own.HostIds = &[]string{"user1", "user2"}
+	if err := w.processJoined(own, users); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := store.GetSpace("space1"); err != nil {
+		t.Fatal(err)
+	} else if s != nil {
+		t.Errorf("processJoined own space, actual: %v", s)
+	}
+
+	space := newTestSpace("live", time.Time{})
+	space.ID = "space2"
+	space.CreatorID = "other"
+	space.HostIds = &[]string{"other", "user2"}
+	space.SpeakerIDs = &[]string{"user2", "user3"}
+	for i := 0; i < 2; i++ {
+		if err := w.processJoined(space, users); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := store.GetSpace("space2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.GetJoinedUserIds()) != 1 || s.JoinedUserIds[0] != "user2" {
+		t.Errorf("processJoined, actual: %v", s.GetJoinedUserIds())
+	}
+
+	// 参加を通知したスペースは終了まで状態を確認する
+	active, err := store.GetActiveSpaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].Id != "space2" {
+		t.Errorf("GetActiveSpaces, actual: %v", active)
+	}
+
+	// 参加は outbox を経由して配送する
+	due, err := store.GetDueDeliveries(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].NotificationStatus != db.SpaceNotificationStatus_JOINED || due[0].Key != "user2" {
+		t.Fatalf("joined deliveries, actual: %v", due)
+	}
+	data, err := w.deliveryMessageData(due[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Joined.ID != "user2" || data.Role != joinedRoleHost || data.User.ID != "other" {
+		t.Errorf("deliveryMessageData, actual: %v, %v, %v", data.Joined, data.Role, data.User)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	reset := time.Now().Add(900 * time.Second)
+
+	cases := []struct {
+		rate     *twitter2.RateLimit
+		requests int
+		expected int64
+	}{
+		{nil, 1, 10},
+		{&twitter2.RateLimit{Remaining: 299, Reset: reset}, 1, 5},
+		// 1 回の取得で複数回リクエストする場合は間隔を延ばす
+		{&twitter2.RateLimit{Remaining: 299, Reset: reset}, 3, 9},
+		{&twitter2.RateLimit{Remaining: 0, Reset: reset}, 1, 900},
+	}
+	for i, c := range cases {
+		if actual := pollInterval(c.rate, 5, 10, c.requests); actual != c.expected {
+			t.Errorf("pollInterval[%d], actual: %d, expected: %d", i, actual, c.expected)
+		}
+	}
+}
+
+func TestWatchInterval(t *testing.T) {
+	reset := time.Now().Add(900 * time.Second)
+	rate := &twitter2.RateLimit{Remaining: 299, Reset: reset}
+
+	cases := []struct {
+		rate     *twitter2.RateLimit
+		lookups  lookupUsage
+		expected int64
+	}{
+		{rate, lookupUsage{}, 5},
+		{nil, lookupUsage{}, 10},
+		// ID での取得の残りの回数が少なければ間隔を延ばす
+		{rate, lookupUsage{rate: &twitter2.RateLimit{Remaining: 29, Reset: reset}, requests: 2}, 60},
+		{nil, lookupUsage{rate: &twitter2.RateLimit{Remaining: 29, Reset: reset}, requests: 2}, 60},
+		{rate, lookupUsage{rate: &twitter2.RateLimit{Remaining: 899, Reset: reset}, requests: 1}, 5},
+	}
+	for i, c := range cases {
+		if actual := watchInterval(c.rate, c.lookups, 5, 10); actual != c.expected {
+			t.Errorf("watchInterval[%d], actual: %d, expected: %d", i, actual, c.expected)
+		}
+	}
+}
+
+func TestSpacesSearchRequests(t *testing.T) {
+	// 検索とディスカバリーは spaces/search のレート制限を共有する
+	w, _ := newTestWatcher(t, `
+search:
+    queries: [a, b, c]
+discovery:
+    queries: [d, e]
+`)
+	if actual := w.spacesSearchRequests(); actual != 5 {
+		t.Errorf("spacesSearchRequests, actual: %d, expected: 5", actual)
+	}
+
+	reset := time.Now().Add(900 * time.Second)
+	rate := &twitter2.RateLimit{Remaining: 299, Reset: reset}
+	if actual := pollInterval(rate, 5, 10, w.spacesSearchRequests()); actual != 15 {
+		t.Errorf("pollInterval, actual: %d, expected: 15", actual)
+	}
+}
+
+func TestLinkedSpaceIDs(t *testing.T) {
+	var tweets []twitter2.Tweet
+	if err := json.Unmarshal([]byte(`[
+		{"id": "1", "text": "a", "entities": {"urls": [
+			{"expanded_url": "https://twitter.com/i/spaces/1AbCdEfGhIjKl?s=20"},
+			{"expanded_url": "https://example.com/"}
+		]}},
+		{"id": "2", "text": "b"},
+		{"id": "3", "text": "c", "entities": {"urls": [
+			{"expanded_url": "https://mobile.twitter.com/i/spaces/1MnOpQrStUvWx"}
+		]}}
+	]`), &tweets); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := linkedSpaceIDs(tweets)
+	expected := []string{"1AbCdEfGhIjKl", "1MnOpQrStUvWx"}
+	if len(actual) != len(expected) || actual[0] != expected[0] || actual[1] != expected[1] {
+		t.Errorf("linkedSpaceIDs, actual: %v, expected: %v", actual, expected)
+	}
+}
+
+func TestCollectLinkedSpaces(t *testing.T) {
+	w, _ := newTestWatcher(t, `
+links:
+    interval: 300
+`)
+	paths := map[string]string{}
+	newTestAPI(t, w, paths)
+
+	tweets := func(id string) string {
+		return `{"data":[{"id":"` + id + `","text":"a","entities":{"urls":[{"expanded_url":"https://twitter.com/i/spaces/1AbC` + id + `"}]}}],` +
+			`"meta":{"newest_id":"` + id + `","oldest_id":"` + id + `","result_count":1}}`
+	}
+	sinceIDs := make(map[string]string)
+	initialized := make(map[string]bool)
+
+	// 初回の取得に失敗した場合は、次に成功した取得でも起動前のツイートを通知しない
+	if ids, _ := w.collectLinkedSpaces(context.Background(), []string{"user1"}, sinceIDs, initialized); len(ids) != 0 || initialized["user1"] {
+		t.Fatalf("collectLinkedSpaces failed, actual: %v, %v", ids, initialized)
+	}
+	paths["/users/user1/tweets"] = tweets("10")
+	if ids, _ := w.collectLinkedSpaces(context.Background(), []string{"user1"}, sinceIDs, initialized); len(ids) != 0 || !initialized["user1"] || sinceIDs["user1"] != "10" {
+		t.Fatalf("collectLinkedSpaces first, actual: %v, %v, %v", ids, initialized, sinceIDs)
+	}
+
+	paths["/users/user1/tweets"] = tweets("11")
+	if ids, _ := w.collectLinkedSpaces(context.Background(), []string{"user1"}, sinceIDs, initialized); len(ids) != 1 || ids[0] != "1AbC11" {
+		t.Errorf("collectLinkedSpaces, actual: %v", ids)
+	}
+}
+
+func TestProcessLinkedSpaces(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    schedule:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+`)
+	scheduledStart := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	users := `"includes":{"users":[{"id":"user1","name":"User","username":"user1"}]}`
+	paths := map[string]string{
+		"/spaces": `{"data":[{"id":"space1","creator_id":"user1","title":"title","state":"scheduled","scheduled_start":"` + scheduledStart + `","created_at":"` + scheduledStart + `"}],` + users + `}`,
+	}
+	newTestAPI(t, w, paths)
+
+	if err := w.processLinkedSpaces(context.Background(), []string{"space1"}); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := store.GetNotifiedStatus("space1"); err != nil || status != db.SpaceNotificationStatus_SCHEDULE {
+		t.Fatalf("linked space schedule, actual: %v, %v", status, err)
+	}
+
+	// フォローしていないユーザーのスペースも開始を通知する
+	paths["/spaces"] = `{"data":[` + testSpaceJSON("live", time.Now()) + `],` + users + `}`
+	if err := w.processWatchedSpaces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := store.GetNotifiedStatus("space1"); err != nil || status != db.SpaceNotificationStatus_START {
+		t.Errorf("linked space start, actual: %v, %v", status, err)
+	}
+}
+
+func TestUpdateWatchedSpaces(t *testing.T) {
+	store := db.NewMemoryClient()
+
+	result, err := updateWatchedSpaces(store, []string{"1AbCdEfGhIjKl", "https://twitter.com/i/spaces/1MnOpQrStUvWx?s=20"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 2 || len(result.Watched) != 2 || result.Added[1] != "1MnOpQrStUvWx" {
+		t.Errorf("updateWatchedSpaces add, actual: %+v", result)
+	}
+
+	result, err = updateWatchedSpaces(store, []string{"1AbCdEfGhIjKl"}, []string{"1MnOpQrStUvWx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 0 || len(result.Removed) != 1 || len(result.Watched) != 1 || result.Watched[0].SpaceID != "1AbCdEfGhIjKl" {
+		t.Errorf("updateWatchedSpaces remove, actual: %+v", result)
+	}
+
+	if _, err := updateWatchedSpaces(store, []string{"https://example.com/"}, nil); err == nil {
+		t.Error("updateWatchedSpaces invalid, expected error")
+	}
+}
+
+func TestLookupWatchedSpaces(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+    end:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+`)
+	users := `"includes":{"users":[{"id":"user1","name":"User","username":"user1"}]}`
+	paths := map[string]string{
+		"/spaces": `{"data":[` + testSpaceJSON("live", time.Now()) + `],` + users + `}`,
+	}
+	newTestAPI(t, w, paths)
+	if _, err := store.AddWatchedSpace("space1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.processWatchedSpaces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := store.GetNotifiedStatus("space1"); err != nil || status != db.SpaceNotificationStatus_START {
+		t.Fatalf("watched space start, actual: %v, %v", status, err)
+	}
+
+	// 権限がないなど、見つからない以外のエラーでは監視を続ける
+	paths["/spaces"] = `{"errors":[{"resource_id":"space1","resource_type":"space","title":"Forbidden","type":"https://api.twitter.com/2/problems/not-authorized-for-resource"}]}`
+	if err := w.processWatchedSpaces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if watched, err := store.GetWatchedSpaces(); err != nil || len(watched) != 1 {
+		t.Fatalf("watched spaces after error, actual: %v, %v", watched, err)
+	}
+
+	// 終了したスペースは終了を通知して記録を閉じ、監視をやめる
+	paths["/spaces"] = `{"data":[` + testSpaceJSON("ended", time.Now()) + `],` + users + `}`
+	if err := w.processWatchedSpaces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.GetSpace("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != db.StateEnded || s.NotificationStatus != db.SpaceNotificationStatus_END {
+		t.Errorf("watched space end, actual: %v", s)
+	}
+	if watched, err := store.GetWatchedSpaces(); err != nil || len(watched) != 0 {
+		t.Errorf("watched spaces after end, actual: %v, %v", watched, err)
+	}
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ends int
+	for _, d := range pending {
+		if d.NotificationStatus == db.SpaceNotificationStatus_END {
+			ends++
+		}
+	}
+	if ends != 1 {
+		t.Errorf("end deliveries, actual: %d, expected: 1", ends)
+	}
+
+	// 削除されたスペースはキャンセルとして記録を閉じ、監視をやめる
+	paths["/spaces"] = `{"data":[{"id":"space2","creator_id":"user1","title":"title","state":"live","started_at":"2021-01-01T00:00:00Z","created_at":"2021-01-01T00:00:00Z"}],` + users + `}`
+	if _, err := store.AddWatchedSpace("space2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processWatchedSpaces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	paths["/spaces"] = `{"errors":[{"resource_id":"space2","resource_type":"space","title":"Not Found Error","type":"https://api.twitter.com/2/problems/resource-not-found"}]}`
+	if err := w.processWatchedSpaces(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := store.GetSpace("space2"); err != nil || s.State != db.StateCanceled {
+		t.Errorf("watched space not found, actual: %v, %v", s, err)
+	}
+	if watched, err := store.GetWatchedSpaces(); err != nil || len(watched) != 0 {
+		t.Errorf("watched spaces after not found, actual: %v, %v", watched, err)
+	}
+}
+
+func TestProcessSpaceFiltered(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+        filter:
+            exclude_title: ["(?i)test"]
+            lang: ["ja"]
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	lang := "ja"
+	space := newTestSpace("live", time.Time{})
+	space.Title = "Test Space"
+	space.Lang = &lang
+	if err := w.processSpace(space, user, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 条件に一致しないスペースは配送しないが、通知済みとして記録する
+	status, err := store.GetNotifiedStatus("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != db.SpaceNotificationStatus_START {
+		t.Errorf("processSpace, actual: %v, expected: %v", status, db.SpaceNotificationStatus_START)
+	}
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("pending deliveries, actual: %d, expected: 0", len(pending))
+	}
+}
+
+func TestFilterSpace(t *testing.T) {
+	ja, en := "ja", "en"
+	ticketed, notTicketed := true, false
+
+	cases := []struct {
+		filter   *FilterConfig
+		title    string
+		lang     *string
+		ticketed *bool
+		rejected bool
+	}{
+		{nil, "title", nil, nil, false},
+		{&FilterConfig{IncludeTitle: []string{"雑談", "chat"}}, "雑談スペース", nil, nil, false},
+		{&FilterConfig{IncludeTitle: []string{"雑談", "chat"}}, "music", nil, nil, true},
+		{&FilterConfig{ExcludeTitle: []string{"(?i)test"}}, "TEST", nil, nil, true},
+		{&FilterConfig{Lang: []string{"ja"}}, "title", &ja, nil, false},
+		{&FilterConfig{Lang: []string{"ja"}}, "title", &en, nil, true},
+		{&FilterConfig{Lang: []string{"ja"}}, "title", nil, nil, true},
+		{&FilterConfig{IsTicketed: &notTicketed}, "title", nil, nil, false},
+		{&FilterConfig{IsTicketed: &notTicketed}, "title", nil, &ticketed, true},
+		{&FilterConfig{IsTicketed: &ticketed}, "title", nil, &ticketed, false},
+	}
+	for i, c := range cases {
+		space := &twitter2.Space{ID: "space1", Title: c.title, Lang: c.lang, IsTicketed: c.ticketed}
+		filter, err := compileFilter(c.filter, "filter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reason := filterSpace(filter, space)
+		if (reason != "") != c.rejected {
+			t.Errorf("filterSpace[%d], actual: %q, expected rejected: %v", i, reason, c.rejected)
+		}
+	}
+
+	if _, err := compileFilter(&FilterConfig{ExcludeTitle: []string{"("}}, "filter"); err == nil {
+		t.Error("compileFilter invalid pattern, expected error")
+	}
+}
+
+func TestGetRoutes(t *testing.T) {
+	conf := `
+event:
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+routing:
+    mode: %s
+    rules:
+        - name: vip
+          match: 'event == "start" && creator in ["vip"]'
+          sinks: [discord, tweet]
+          message: "VIP {{.Space.Title}}"
+        - name: release
+          match: 'title =~ "#release"'
+          sinks: [slack]
+        - match: 'event == "start"'
+          sinks: [tweet]
+sinks:
+    discord:
+        url: http://localhost/discord
+    slack:
+        url: http://localhost/slack
+`
+	cases := []struct {
+		mode     string
+		username string
+		title    string
+		expected []string
+	}{
+		{"first", "vip", "#release", []string{"discord", "tweet"}},
+		{"first", "user1", "#release", []string{"slack"}},
+		{"first", "user1", "title", []string{"tweet"}},
+		{"all", "vip", "#release", []string{"discord", "slack", "tweet"}},
+		{"all", "user1", "#release", []string{"slack", "tweet"}},
+	}
+	for i, c := range cases {
+		w, _ := newTestWatcher(t, fmt.Sprintf(conf, c.mode))
+		space := newTestSpace("live", time.Time{})
+		space.Title = c.title
+		user := &twitter2.User{ID: "user1", Username: c.username}
+
+		sinks, err := w.getSinks(db.SpaceNotificationStatus_START, space, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(sinks)
+		if len(sinks) != len(c.expected) || strings.Join(sinks, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("getSinks[%d], actual: %v, expected: %v", i, sinks, c.expected)
+		}
+
+		// 一致した規則のテンプレートはツイートにも使用する
+		message, err := w.getSinkMessage(db.SpaceNotificationStatus_START, sinkTweet, bot.NewMessageData(space, user))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "{{.Space.Title}} {{.URL}}"
+		if c.username == "vip" {
+			expected = "VIP {{.Space.Title}}"
+		}
+		if message != expected {
+			t.Errorf("getSinkMessage[%d], actual: %s, expected: %s", i, message, expected)
+		}
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		res.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	w, store := newTestWatcher(t, `
+event:
+    start:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"
+routing:
+    rules:
+        - match: 'participants >= 100'
+          sinks: [discord]
+sinks:
+    discord:
+        url: `+server.URL+`
+        message: "{{.Space.Title}} が開始しました"
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	// 一致する規則がない場合は配送しない
+	space := newTestSpace("live", time.Time{})
+	if err := w.processSpace(space, user, nil); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("pending deliveries, actual: %d, expected: 0", len(pending))
+	}
+
+	count := int64(150)
+	space = newTestSpace("live", time.Time{})
+	space.ID = "space2"
+	space.ParticipantCount = &count
+	if err := w.processSpace(space, user, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processOutbox(); err != nil {
+		t.Fatal(err)
+	}
+
+	delivered, err := store.GetDeliveries(db.DeliveryState_DELIVERY_DELIVERED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || delivered[0].Sink != sinkDiscord {
+		t.Fatalf("delivered, actual: %v", delivered)
+	}
+	if expected := `{"allowed_mentions":{"parse":[]},"content":"title が開始しました"}`; string(body) != expected {
+		t.Errorf("webhook body, actual: %s, expected: %s", body, expected)
+	}
+}
+
+func TestRoutingOutbox(t *testing.T) {
+	var slackBody []byte
+	slack := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		slackBody, _ = io.ReadAll(r.Body)
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer slack.Close()
+	discord := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		res.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer discord.Close()
+
+	w, store := newTestWatcher(t, `
+event:
+    joined: {}
+routing:
+    rules:
+        - sinks: [discord, command, slack]
+          message: "<!channel> {{.Space.Title}} {{.Joined.Username}} {{.Role}}"
+sinks:
+    discord:
+        url: `+discord.URL+`
+    slack:
+        url: `+slack.URL+`
+`)
+	space := newTestSpace("live", time.Time{})
+	space.Title = "Q&A <b>"
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+	joined := twitter2.User{ID: "user2", Username: "user2"}
+
+	// 参加の通知も通知先ごとに配送し、失敗した通知先だけを再送する
+	if err := w.enqueueSnapshot(db.SpaceNotificationStatus_JOINED, joined.ID, &spaceSnapshot{Space: *space, Joined: &joined, Role: joinedRoleSpeaker}, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(slackBody, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "&lt;!channel&gt; Q&amp;A &lt;b&gt; user2 " + joinedRoleSpeaker; payload["text"] != expected {
+		t.Errorf("slack text, actual: %s, expected: %s", payload["text"], expected)
+	}
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[1].Attempts != 1 || pending[0].Key != joined.ID {
+		t.Errorf("pending deliveries, actual: %v", pending)
+	}
+
+	// テンプレートやコマンドのない通知先は失敗する
+	if err := w.send(sinkCommand, db.SpaceNotificationStatus_MILESTONE, bot.NewMessageData(space, user)); err == nil {
+		t.Error("send command, expected error")
+	}
+	if err := w.send(sinkTweet, db.SpaceNotificationStatus_START, bot.NewMessageData(space, user)); err == nil {
+		t.Error("send tweet, expected error")
+	}
+}
+
+func TestCheckRoutingConfig(t *testing.T) {
+	base := `
+event:
+    start:
+        notification:
+            message: "start {{.URL}}"
+    end:
+        command:
+            name: record
+sinks:
+    discord:
+        url: http://localhost/discord
+routing:
+    rules:
+`
+	cases := []struct {
+		rules string
+		valid bool
+	}{
+		{`[{match: 'event == "start"', sinks: [tweet, discord]}]`, true},
+		{`[{match: 'event == "end"', sinks: [command]}]`, true},
+		{`[{match: 'event == "end" && creator == "a"', sinks: [command]}]`, true},
+		{`[{match: 'event == "end"', sinks: [discord], message: "end {{.URL}}"}]`, true},
+		{`[{match: 'event == "end"', sinks: [tweet]}]`, false},
+		{`[{match: 'event == "end"', sinks: [discord]}]`, false},
+		{`[{match: 'event == "start"', sinks: [command]}]`, false},
+		{`[{match: 'creator == "a"', sinks: [command]}]`, false},
+		{`[{match: 'event == "milestone"', sinks: [tweet]}]`, true},
+	}
+	for i, c := range cases {
+		var config Config
+		if err := yaml.Unmarshal([]byte(base+"        "+c.rules), &config); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkRoutingConfig(&config); (err == nil) != c.valid {
+			t.Errorf("checkRoutingConfig[%d], actual: %v, expected valid: %v", i, err, c.valid)
+		}
+	}
+
+	// 作成者ごとに有効にしたイベントもテンプレートが必要
+	var config Config
+	if err := yaml.Unmarshal([]byte(base+`        [{match: 'event == "schedule"', sinks: [tweet]}]
+creators:
+    alice:
+        schedule:
+            enabled: true
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRoutingConfig(&config); err == nil {
+		t.Error("checkRoutingConfig creator, expected error")
+	}
+}
+
+func TestCreatorOverrides(t *testing.T) {
+	w, _ := newTestWatcher(t, `
+event:
+    schedule_remind:
+        before: 600
+        notification:
+            message: "remind {{.URL}}"
+    start:
+        notification:
+            message: "start {{.URL}}"
+        command:
+            name: record
+            args: ["{{.URL}}"]
+creators:
+    Alice:
+        schedule_remind:
+            before: 7200
+        start:
+            message: "#alice start {{.URL}}"
+            args: ["--alice", "{{.URL}}"]
+    bob:
+        schedule:
+            enabled: true
+            message: "schedule {{.URL}}"
+    "12345":
+        start:
+            enabled: false
+`)
+	alice := &twitter2.User{ID: "1", Username: "alice"}
+	bob := &twitter2.User{ID: "2", Username: "bob"}
+	carol := &twitter2.User{ID: "12345", Username: "carol"}
+	dave := &twitter2.User{ID: "4", Username: "dave"}
+
+	// リマインドの時間とイベントの有効・無効はユーザーごとの設定に従う
+	statuses := []struct {
+		user     *twitter2.User
+		expected db.SpaceNotificationStatus
+	}{
+		{alice, db.SpaceNotificationStatus_SCHEDULE_REMIND},
+		{bob, db.SpaceNotificationStatus_SCHEDULE},
+		{dave, db.SpaceNotificationStatus_NONE},
+	}
+	for _, s := range statuses {
+		status, err := w.getNotificationStatus(newTestSpace("scheduled", time.Now().Add(time.Hour)), s.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != s.expected {
+			t.Errorf("getNotificationStatus(%s), actual: %v, expected: %v", s.user.Username, status, s.expected)
+		}
+	}
+
+	space := newTestSpace("live", time.Time{})
+	messages := []struct {
+		user     *twitter2.User
+		expected string
+	}{
+		{alice, "#alice start {{.URL}}"},
+		{dave, "start {{.URL}}"},
+	}
+	for _, m := range messages {
+		message, err := w.getSinkMessage(db.SpaceNotificationStatus_START, sinkTweet, bot.NewMessageData(space, m.user))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message != m.expected {
+			t.Errorf("getSinkMessage(%s), actual: %s, expected: %s", m.user.Username, message, m.expected)
+		}
+	}
+
+	sinks, err := w.getSinks(db.SpaceNotificationStatus_START, space, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 0 {
+		t.Errorf("getSinks(carol), actual: %v, expected: []", sinks)
+	}
+
+	// コマンドの引数も作成者ごとに置き換える
+	conf, err := w.getEventConfig(db.SpaceNotificationStatus_START, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Command == nil || conf.Command.Name != "record" || strings.Join(conf.Command.Args, " ") != "--alice {{.URL}}" {
+		t.Errorf("getEventConfig(alice) command, actual: %+v", conf.Command)
+	}
+
+	// 上書きしても event の設定は変更しない
+	if w.config.Event.ScheduleRemind.Before != 600 || w.config.Event.Start.Notification.Message != "start {{.URL}}" || len(w.config.Event.Start.Command.Args) != 1 {
+		t.Errorf("event config modified: %+v", w.config.Event)
+	}
+}
+
+func TestCheckCreatorConfig(t *testing.T) {
+	var event EventConfig
+	if err := yaml.Unmarshal([]byte(`
+start:
+    notification:
+        message: "start {{.URL}}"
+    command:
+        name: record
+        args: ["{{.URL}}"]
+`), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		conf  string
+		valid bool
+	}{
+		{"start:\n    args: [\"--alice\", \"{{.URL}}\"]", true},
+		{"end:\n    args: [\"{{.URL}}\"]", false},
+		{"schedule:\n    enabled: true\n    message: \"schedule {{.URL}}\"", true},
+		{"schedule:\n    enabled: true", false},
+		{"start:\n    enabled: true", true},
+	}
+	for i, c := range cases {
+		var conf CreatorConfig
+		if err := yaml.Unmarshal([]byte(c.conf), &conf); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkCreatorConfig(&event, &conf, "creators.alice"); (err == nil) != c.valid {
+			t.Errorf("checkCreatorConfig[%d], actual: %v, expected valid: %v", i, err, c.valid)
+		}
+	}
+
+	// 大文字と小文字だけが異なるキーは拒否する
+	if err := checkCreatorKeys(map[string]*CreatorConfig{"alice": {}, "bob": {}}); err != nil {
+		t.Errorf("checkCreatorKeys, actual: %v", err)
+	}
+	if err := checkCreatorKeys(map[string]*CreatorConfig{"alice": {}, "Alice": {}}); err == nil {
+		t.Error("checkCreatorKeys duplicated, expected error")
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	night := &QuietHoursConfig{Start: "23:00", End: "07:00", Timezone: "Asia/Tokyo"}
+	day := &QuietHoursConfig{Start: "12:00", End: "13:30", Timezone: "Asia/Tokyo"}
+
+	cases := []struct {
+		conf     *QuietHoursConfig
+		t        time.Time
+		quiet    bool
+		expected time.Time
+	}{
+		{night, time.Date(2021, 10, 4, 22, 59, 0, 0, loc), false, time.Time{}},
+		{night, time.Date(2021, 10, 4, 23, 0, 0, 0, loc), true, time.Date(2021, 10, 5, 7, 0, 0, 0, loc)},
+		{night, time.Date(2021, 10, 5, 3, 0, 0, 0, loc), true, time.Date(2021, 10, 5, 7, 0, 0, 0, loc)},
+		{night, time.Date(2021, 10, 5, 7, 0, 0, 0, loc), false, time.Time{}},
+		// タイムゾーンを変換して判定する
+		{night, time.Date(2021, 10, 4, 18, 0, 0, 0, time.UTC), true, time.Date(2021, 10, 5, 7, 0, 0, 0, loc)},
+		{day, time.Date(2021, 10, 4, 12, 30, 0, 0, loc), true, time.Date(2021, 10, 4, 13, 30, 0, 0, loc)},
+		{day, time.Date(2021, 10, 4, 14, 0, 0, 0, loc), false, time.Time{}},
+		{nil, time.Date(2021, 10, 4, 12, 30, 0, 0, loc), false, time.Time{}},
+	}
+	for i, c := range cases {
+		until, quiet := quietUntil(c.conf, c.t)
+		if quiet != c.quiet || !until.Equal(c.expected) {
+			t.Errorf("quietUntil[%d], actual: %v %v, expected: %v %v", i, until, quiet, c.expected, c.quiet)
+		}
+	}
+}
+
+func TestProcessOutboxCommand(t *testing.T) {
+	w, store := newTestWatcher(t, `
+event:
+    start:
+        command:
+            name: space-watcher-missing-command
+    end:
+        command:
+            name: sleep
+            args: ["10"]
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	// 起動できなかったコマンドは再試行する
+	if err := w.processSpace(newTestSpace("live", time.Time{}), user, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("failed command delivery, actual: %v", pending)
+	}
+
+	// 起動したコマンドの終了は待たずに配信済みにする
+	if err := store.EnqueueDeliveries("space2", db.SpaceNotificationStatus_END, "", []string{sinkCommand}, []byte(`{"id":"space2"}`), []byte(`{"id":"user1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := w.processOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("processOutbox waited for the command: %v", elapsed)
+	}
+	delivered, err := store.GetDeliveries(db.DeliveryState_DELIVERY_DELIVERED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || delivered[0].SpaceId != "space2" {
+		t.Errorf("delivered deliveries, actual: %v", delivered)
+	}
+}
+
+func TestProcessOutboxQuietHours(t *testing.T) {
+	now := time.Now().UTC()
+	window := fmt.Sprintf(`
+        quiet_hours:
+            start: "%s"
+            end: "%s"
+            timezone: UTC`, now.Add(-time.Hour).Format(quietHoursFormat), now.Add(time.Hour).Format(quietHoursFormat))
+
+	w, store := newTestWatcher(t, `
+event:
+    schedule:
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"`+window+`
+    schedule_remind:
+        before: 600
+        notification:
+            message: "{{.Space.Title}} {{.URL}}"`+window+`
+`)
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+
+	// 予定の告知は時間帯の終了まで延期する
+	if err := w.processSpace(newTestSpace("scheduled", time.Now().Add(time.Hour)), user, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 0 || !pending[0].NextAttemptAt.AsTime().After(time.Now()) || !isDeferred(pending[0]) {
+		t.Fatalf("deferred delivery, actual: %v", pending)
+	}
+
+	// リマインドは破棄する
+	space := newTestSpace("scheduled", time.Now().Add(time.Minute))
+	space.ID = "space2"
+	if err := w.processSpace(space, user, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processOutbox(); err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := store.GetDeliveries(db.DeliveryState_DELIVERY_DROPPED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0].SpaceId != "space2" || dropped[0].NotificationStatus != db.SpaceNotificationStatus_SCHEDULE_REMIND {
+		t.Errorf("dropped delivery, actual: %v", dropped)
+	}
+}
+
+func TestRevalidateDeliveries(t *testing.T) {
+	w, store := newTestWatcher(t, ``)
+	paths := map[string]string{
+		"/spaces": `{"data":[` +
+			`{"id":"space1","creator_id":"user1","title":"new title","state":"live"},` +
+			`{"id":"space2","creator_id":"user1","title":"title","state":"ended"}]}`,
+	}
+	newTestAPI(t, w, paths)
+
+	deliveries := []struct {
+		id     string
+		status db.SpaceNotificationStatus
+	}{
+		{"space1", db.SpaceNotificationStatus_START},
+		{"space2", db.SpaceNotificationStatus_START},
+		{"space3", db.SpaceNotificationStatus_SCHEDULE},
+		{"space4", db.SpaceNotificationStatus_END},
+	}
+	for _, d := range deliveries {
+		space := `{"id":"` + d.id + `","creator_id":"user1","title":"title"}`
+		if err := store.EnqueueDeliveries(d.id, d.status, "", []string{sinkTweet}, []byte(space), []byte(`{"id":"user1"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := store.GetDeliveries(db.DeliveryState_DELIVERY_PENDING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range pending {
+		if err := store.MarkDeferred(d, d.CreatedAt.AsTime().Add(time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	due := func() []*db.Delivery {
+		t.Helper()
+		d, err := store.GetDueDeliveries(time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// 確認できなかった配送は破棄せずに次回に確認する
+	body := paths["/spaces"]
+	delete(paths, "/spaces")
+	valid, err := w.revalidateDeliveries(context.Background(), due())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(valid) != 0 || len(due()) != 4 {
+		t.Fatalf("revalidateDeliveries lookup error, actual: %v", valid)
+	}
+
+	// 状態が変わった配送と見つからない配送は破棄し、終了は見つからなくても配送する
+	paths["/spaces"] = body
+	valid, err = w.revalidateDeliveries(context.Background(), due())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(valid))
+	for i, d := range valid {
+		ids[i] = d.SpaceId
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, ",") != "space1,space4" {
+		t.Fatalf("revalidateDeliveries, actual: %v", ids)
+	}
+	dropped, err := store.GetDeliveries(db.DeliveryState_DELIVERY_DROPPED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := make(map[string]string)
+	for _, d := range dropped {
+		reasons[d.SpaceId] = d.LastError
+	}
+	if len(dropped) != 2 || reasons["space2"] != "space state changed" || reasons["space3"] != "space not found" {
+		t.Errorf("dropped deliveries, actual: %v", reasons)
+	}
+
+	// 配送を続けるものは最新の情報で通知する
+	for _, d := range valid {
+		var snapshot spaceSnapshot
+		if err := json.Unmarshal(d.Space, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		expected := "title"
+		if d.SpaceId == "space1" {
+			expected = "new title"
+		}
+		if snapshot.Title != expected {
+			t.Errorf("snapshot %s title, actual: %s, expected: %s", d.SpaceId, snapshot.Title, expected)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	w, _ := newTestWatcher(t, `
+admin_server:
+    enabled: true
+    token: secret
+`)
+	srv := httptest.NewServer(w.adminHandler(http.MethodGet, func(*http.Request) (interface{}, error) {
+		return map[string]string{"result": "ok"}, nil
+	}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	var out map[string]string
+	if err := callAdmin(&AdminConfig{Address: address, Token: "secret"}, http.MethodGet, "/", nil, &out); err != nil || out["result"] != "ok" {
+		t.Errorf("callAdmin, actual: %v, %v", out, err)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if err := callAdmin(&AdminConfig{Address: address, Token: token}, http.MethodGet, "/", nil, nil); err == nil {
+			t.Errorf("callAdmin with token %q, expected error", token)
+		}
+	}
+
+	addresses := []struct {
+		address  string
+		expected bool
+	}{
+		{"127.0.0.1:18081", true},
+		{"localhost:18081", true},
+		{"[::1]:18081", true},
+		{":18081", false},
+		{"0.0.0.0:18081", false},
+		{"192.168.0.1:18081", false},
+	}
+	for _, a := range addresses {
+		if actual := isLoopbackAddress(a.address); actual != a.expected {
+			t.Errorf("isLoopbackAddress(%s), actual: %v, expected: %v", a.address, actual, a.expected)
+		}
+	}
+}
+
+func TestBackupDatabase(t *testing.T) {
+	dir := t.TempDir()
+	store, err := db.OpenStore(db.DriverBolt, filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// 同じ秒に続けて作成しても上書きせず、新しいものを残す
+	config := &BackupConfig{Directory: filepath.Join(dir, "backup"), Keep: 1}
+	first, err := backupDatabase(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := backupDatabase(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Path == second.Path {
+		t.Fatalf("backup path, actual: %s", second.Path)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(config.Directory, backupPrefix+"*"+backupSuffix))
+	if err != nil || len(backups) != 1 || backups[0] != second.Path {
+		t.Errorf("rotated backups, actual: %v, %v", backups, err)
+	}
+}
+
+// staleTestConfig はスケジュールをツイートで告知する設定
+const staleTestConfig = `
+event:
+    schedule:
+        notification:
+            message: "schedule {{.URL}}"
+    start:
+        notification:
+            message: "start {{.URL}}"
+`
+
+// notifyTestSpace はスペースを通知し、配送まで行う
+func notifyTestSpace(t *testing.T, w *watcher, space *twitter2.Space) {
+	t.Helper()
+
+	if err := w.processSpace(space, &twitter2.User{ID: "user1", Username: "user1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.processOutbox(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckRescheduled(t *testing.T) {
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+	scheduled := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	rescheduled := newTestSpace("scheduled", scheduled.Add(time.Hour))
+
+	// 告知を削除して未通知に戻す
+	w, store := newTestWatcher(t, staleTestConfig)
+	api := newTestAPIV11(t, w)
+	w.config.Event.Stale = &StaleConfig{Rescheduled: &StaleItemConfig{Action: StaleActionDelete}}
+	notifyTestSpace(t, w, newTestSpace("scheduled", scheduled))
+	record, err := store.GetSpace("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.checkRescheduled(record, rescheduled, user); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.destroyed) != 1 || api.destroyed[0] != 1 {
+		t.Errorf("destroyed tweets, actual: %v", api.destroyed)
+	}
+	if record, err := store.GetSpace("space1"); err != nil || record != nil {
+		t.Errorf("GetSpace after delete, actual: %v, %v", record, err)
+	}
+
+	// 告知に返信して新しい日時を記録する
+	w, store = newTestWatcher(t, staleTestConfig)
+	api = newTestAPIV11(t, w)
+	w.config.Event.Stale = &StaleConfig{Rescheduled: &StaleItemConfig{Action: StaleActionReply, Message: "rescheduled {{.URL}}"}}
+	notifyTestSpace(t, w, newTestSpace("scheduled", scheduled))
+	record, err = store.GetSpace("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.checkRescheduled(record, rescheduled, user); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.destroyed) != 0 || len(api.updates) != 2 {
+		t.Fatalf("tweets, actual: %v, destroyed: %v", api.updates, api.destroyed)
+	}
+	if reply := api.updates[1]; !strings.HasPrefix(reply.Get("status"), "rescheduled ") || reply.Get("in_reply_to_status_id") != "1" {
+		t.Errorf("reply, actual: %v", reply)
+	}
+	if record, err := store.GetSpace("space1"); err != nil || !record.ScheduledStart.AsTime().Equal(*rescheduled.ScheduledStart) {
+		t.Errorf("GetSpace after reply, actual: %v, %v", record, err)
+	}
+}
+
+func TestCloseSpaceStale(t *testing.T) {
+	user := &twitter2.User{ID: "user1", Username: "user1"}
+	scheduled := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	cases := []struct {
+		action    string
+		state     string
+		destroyed int
+		updates   int
+	}{
+		// 開始前にキャンセルされたスペースの告知を訂正する
+		{StaleActionDelete, "scheduled", 1, 1},
+		{StaleActionReply, "scheduled", 0, 2},
+		// 開始を通知したスペースの告知は訂正しない
+		{StaleActionDelete, "live", 0, 1},
+		{StaleActionReply, "live", 0, 1},
+	}
+	for i, c := range cases {
+		w, store := newTestWatcher(t, staleTestConfig)
+		api := newTestAPIV11(t, w)
+		w.config.Event.Stale = &StaleConfig{Canceled: &StaleItemConfig{Action: c.action, Message: "canceled {{.URL}}"}}
+		space := newTestSpace(c.state, scheduled)
+		notifyTestSpace(t, w, space)
+
+		record, err := store.GetSpace("space1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := db.StateCanceled
+		if c.state == "live" {
+			state = db.StateEnded
+		}
+		if err := w.closeSpace(record, state, space, user, nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(api.destroyed) != c.destroyed || len(api.updates) != c.updates {
+			t.Errorf("closeSpace[%d], actual: %v, destroyed: %v", i, api.updates, api.destroyed)
+		}
+		if c.updates == 2 && api.updates[1].Get("in_reply_to_status_id") != "1" {
+			t.Errorf("closeSpace[%d] reply, actual: %v", i, api.updates[1])
+		}
+		if record, err := store.GetSpace("space1"); err != nil || record.State != state {
+			t.Errorf("closeSpace[%d] state, actual: %v, %v", i, record, err)
+		}
+	}
+}
+
+func TestPurgeTweets(t *testing.T) {
+	w, store := newTestWatcher(t, ``)
+	api := newTestAPIV11(t, w)
+	api.notFound[2] = true
+	for _, id := range []int64{1, 2} {
+		if err := store.AddTweet("space1", db.SpaceNotificationStatus_SCHEDULE, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.AddTweet("space2", db.SpaceNotificationStatus_SCHEDULE, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// 起動中のプロセスに管理用 API で依頼する
+	srv := httptest.NewServer(w.adminHandler(http.MethodPost, w.handlePurge))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	var result purgeResult
+	if err := callAdmin(&AdminConfig{Address: address}, http.MethodPost, "/", url.Values{"id": {"space1"}}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Purged) != 1 || result.Purged[0] != "space1" {
+		t.Errorf("purge result, actual: %v", result)
+	}
+
+	// 削除済みのツイートは無視し、他のスペースのツイートは削除しない
+	if len(api.destroyed) != 2 || api.destroyed[0] != 1 || api.destroyed[1] != 2 {
+		t.Errorf("destroyed tweets, actual: %v", api.destroyed)
+	}
+	if tweets, err := store.GetTweets("space1"); err != nil || len(tweets) != 0 {
+		t.Errorf("GetTweets space1, actual: %v, %v", tweets, err)
+	}
+	if tweets, err := store.GetTweets("space2"); err != nil || len(tweets) != 1 {
+		t.Errorf("GetTweets space2, actual: %v, %v", tweets, err)
+	}
+
+	// 削除に失敗したツイートは記録に残す
+	api.unavailable = true
+	if _, err := w.purgeTweets([]string{"space2"}); err == nil {
+		t.Error("purgeTweets, expected error")
+	}
+	if tweets, err := store.GetTweets("space2"); err != nil || len(tweets) != 1 {
+		t.Errorf("GetTweets after error, actual: %v, %v", tweets, err)
+	}
+}
+
+func TestTweetThread(t *testing.T) {
+	w, store := newTestWatcher(t, staleTestConfig)
+	api := newTestAPIV11(t, w)
+
+	// 同じスペースの通知は直前のツイートへの返信にする
+	notifyTestSpace(t, w, newTestSpace("scheduled", time.Now().Add(24*time.Hour)))
+	notifyTestSpace(t, w, newTestSpace("live", time.Time{}))
+	if len(api.updates) != 2 {
+		t.Fatalf("tweets, actual: %v", api.updates)
+	}
+	if reply := api.updates[0].Get("in_reply_to_status_id"); reply != "" {
+		t.Errorf("first tweet in_reply_to_status_id, actual: %s", reply)
+	}
+	if reply := api.updates[1].Get("in_reply_to_status_id"); reply != "1" {
+		t.Errorf("second tweet in_reply_to_status_id, actual: %s, expected: 1", reply)
+	}
+
+	tweets, err := store.GetTweets("space1")
+	if err != nil || len(tweets) != 2 || tweets[0].Id != 1 || tweets[1].Id != 2 {
+		t.Errorf("GetTweets, actual: %v, %v", tweets, err)
+	}
+}
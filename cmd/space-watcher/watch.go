@@ -0,0 +1,271 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/qitoi/space-watcher/db"
+	twitter2 "github.com/qitoi/space-watcher/twitter"
+)
+
+var spaceIDPattern = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+
+type watchedSpace struct {
+	SpaceID string    `json:"space_id"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type watchResult struct {
+	Added   []string       `json:"added"`
+	Removed []string       `json:"removed"`
+	Watched []watchedSpace `json:"watched"`
+}
+
+// parseSpaceID はスペースの ID または URL から ID を取り出す
+func parseSpaceID(s string) (string, error) {
+	if id, ok := twitter2.ParseSpaceURL(s); ok {
+		return id, nil
+	}
+	if spaceIDPattern.MatchString(s) {
+		return s, nil
+	}
+	return "", errors.New("invalid space id or url: " + s)
+}
+
+// updateWatchedSpaces は手動で監視するスペースを追加・削除し、監視中の一覧を返す
+func updateWatchedSpaces(dbClient db.Store, add, remove []string) (*watchResult, error) {
+	result := &watchResult{
+		Added:   []string{},
+		Removed: []string{},
+		Watched: []watchedSpace{},
+	}
+
+	for _, s := range add {
+		id, err := parseSpaceID(s)
+		if err != nil {
+			return nil, err
+		}
+		added, err := dbClient.AddWatchedSpace(id)
+		if err != nil {
+			return nil, err
+		}
+		if added {
+			result.Added = append(result.Added, id)
+		}
+	}
+	for _, s := range remove {
+		id, err := parseSpaceID(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := dbClient.RemoveWatchedSpace(id); err != nil {
+			return nil, err
+		}
+		result.Removed = append(result.Removed, id)
+	}
+
+	watched, err := dbClient.GetWatchedSpaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range watched {
+		result.Watched = append(result.Watched, watchedSpace{SpaceID: w.SpaceId, AddedAt: w.AddedAt.AsTime()})
+	}
+	return result, nil
+}
+
+// processWatchedSpaces は手動で監視するスペースを ID で取得して通知の処理に渡す
+// 終了・キャンセル・削除されたスペースは監視を終える
+func (w *watcher) processWatchedSpaces(ctx context.Context) error {
+	watched, err := w.dbClient.GetWatchedSpaces()
+	if err != nil {
+		return err
+	}
+
+	for len(watched) > 0 {
+		n := len(watched)
+		if n > maxLookupSpaces {
+			n = maxLookupSpaces
+		}
+		if err := w.lookupWatchedSpaces(ctx, watched[:n]); err != nil {
+			return err
+		}
+		watched = watched[n:]
+	}
+	return nil
+}
+
+func (w *watcher) lookupWatchedSpaces(ctx context.Context, watched []*db.WatchedSpace) error {
+	ids := make([]string, len(watched))
+	for i, s := range watched {
+		ids[i] = s.SpaceId
+	}
+
+	resp, rate, err := w.clientV2.GetSpacesByIDs(ctx, twitter2.SpacesByIDsRequest{
+		IDs:         ids,
+		Expansions:  spaceExpansions,
+		SpaceFields: spaceFields,
+		UserFields:  userFields,
+	})
+	w.lookups.add(rate)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]twitter2.User)
+	if resp.Includes != nil && resp.Includes.Users != nil {
+		for _, u := range *resp.Includes.Users {
+			users[u.ID] = u
+		}
+	}
+
+	// 削除されたスペースはキャンセル扱い
+	// 権限がないなど、他のエラーのスペースは次回も確認する
+	closed := resp.Errors.NotFoundIDs()
+	for id := range closed {
+		if err := w.closeWatchedSpace(id, db.StateCanceled, nil, users); err != nil {
+			return err
+		}
+	}
+
+	var spaces []twitter2.Space
+	for _, s := range resp.Data {
+		if s.State != nil && db.IsClosedState(*s.State) {
+			space := s
+			if err := w.closeWatchedSpace(space.ID, *space.State, &space, users); err != nil {
+				return err
+			}
+			closed[s.ID] = true
+			continue
+		}
+		spaces = append(spaces, s)
+	}
+
+	if len(spaces) > 0 {
+		if err := w.processSpaces(spaces, users); err != nil {
+			return err
+		}
+	}
+
+	for id := range closed {
+		w.logger.Infow("unwatch closed space", "space_id", id)
+		if err := w.dbClient.RemoveWatchedSpace(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeWatchedSpace は終了・キャンセル・削除された監視中のスペースの記録を閉じ、終了を通知する
+// 記録のないスペースや閉じた記録は何もしない
+func (w *watcher) closeWatchedSpace(id, state string, space *twitter2.Space, users map[string]twitter2.User) error {
+	record, err := w.dbClient.GetSpace(id)
+	if err != nil {
+		return err
+	}
+	if record == nil || !db.IsActiveState(record.State) {
+		return nil
+	}
+
+	restored, user := restoreSpace(record)
+	if space == nil {
+		space = restored
+	} else if u, ok := users[space.CreatorID]; ok {
+		user = &u
+	}
+	return w.closeSpace(record, state, space, user, users)
+}
+
+func (w *watcher) handleWatch(r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	if q.Get("remove") == "true" {
+		return updateWatchedSpaces(w.dbClient, nil, q["id"])
+	}
+	return updateWatchedSpaces(w.dbClient, q["id"], nil)
+}
+
+func (w *watcher) handleWatched(r *http.Request) (interface{}, error) {
+	return updateWatchedSpaces(w.dbClient, nil, nil)
+}
+
+// WatchSpaces は作成者をフォローしていないスペースを手動で監視する
+// 管理用サーバーが有効な場合は起動中のプロセスに依頼し、無効な場合はデータベースを直接開く
+func WatchSpaces(config *Config, args []string) error {
+	flags := pflag.NewFlagSet("watch", pflag.ContinueOnError)
+	remove := flags.Bool("remove", false, "stop watching the spaces")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var add, del []string
+	if *remove {
+		del = flags.Args()
+	} else {
+		add = flags.Args()
+	}
+
+	var result *watchResult
+	if config.Admin.Enabled {
+		result = &watchResult{}
+		if len(add) == 0 && len(del) == 0 {
+			if err := callAdmin(&config.Admin, http.MethodGet, "/watched", nil, result); err != nil {
+				return err
+			}
+		} else {
+			params := url.Values{}
+			for _, id := range flags.Args() {
+				params.Add("id", id)
+			}
+			if *remove {
+				params.Set("remove", "true")
+			}
+			if err := callAdmin(&config.Admin, http.MethodPost, "/watch", params, result); err != nil {
+				return err
+			}
+		}
+	} else {
+		dbClient, err := openDatabase(&config.Database)
+		if err != nil {
+			return err
+		}
+		defer dbClient.Close()
+
+		if result, err = updateWatchedSpaces(dbClient, add, del); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range result.Added {
+		fmt.Printf("added: %s\n", id)
+	}
+	for _, id := range result.Removed {
+		fmt.Printf("removed: %s\n", id)
+	}
+	for _, s := range result.Watched {
+		fmt.Printf("%s\t%s\t%s\n", s.SpaceID, s.AddedAt.Local().Format(time.RFC3339), twitter2.GetSpaceURL(s.SpaceID))
+	}
+	return nil
+}
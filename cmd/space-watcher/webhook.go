@@ -0,0 +1,104 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qitoi/space-watcher/bot"
+	"github.com/qitoi/space-watcher/db"
+)
+
+const (
+	// Discord のメッセージの最大文字数
+	discordMaxLength = 2000
+)
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// postWebhook は Discord, Slack の Webhook にメッセージを投稿する
+func (w *watcher) postWebhook(sink string, status db.SpaceNotificationStatus, data *bot.MessageData) error {
+	template, err := w.getSinkMessage(status, sink, data)
+	if err != nil {
+		return err
+	}
+	if template == "" {
+		return errors.New("message is not configured: " + sink)
+	}
+	message, err := data.Render(template)
+	if err != nil {
+		return err
+	}
+
+	if err := w.postWebhookMessage(sink, message); err != nil {
+		return err
+	}
+
+	w.logger.Infow("webhook completed", "sink", sink, "space_id", data.Space.ID, "message", message)
+	return nil
+}
+
+// postWebhookMessage は展開済みのメッセージを Webhook に投稿する
+func (w *watcher) postWebhookMessage(sink, message string) error {
+	var conf *WebhookSinkConfig
+	if w.config.Sinks != nil {
+		conf = w.config.Sinks.webhook(sink)
+	}
+	if conf == nil {
+		return errors.New("sink is not configured: " + sink)
+	}
+
+	var payload interface{}
+	switch sink {
+	case sinkDiscord:
+		if utf8.RuneCountInString(message) > discordMaxLength {
+			message = string([]rune(message)[:discordMaxLength])
+		}
+		// タイトルなどに含まれるメンションで通知しない
+		payload = map[string]interface{}{
+			"content":          message,
+			"allowed_mentions": map[string][]string{"parse": {}},
+		}
+	default:
+		// Slack は <!channel> などの制御記法を解釈するためエスケープする
+		payload = map[string]string{"text": slackEscaper.Replace(message)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(conf.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook error: %s", resp.Status)
+	}
+
+	return nil
+}
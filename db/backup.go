@@ -0,0 +1,89 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backup は読み取りトランザクションで一貫したスナップショットを dst に書き出し、
+// 書き出したファイルを開き直して検証する
+func (c *Client) Backup(dst string) (int64, error) {
+	tmp := dst + ".tmp"
+
+	var size int64
+	err := c.db.View(func(tx *bolt.Tx) error {
+		f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+
+		size, err = tx.WriteTo(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := Verify(tmp); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// Verify はデータベースファイルを読み取り専用で開き、スキーマと全データを読み出せることを確認する
+func Verify(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if version := readSchemaVersion(tx); version != SchemaVersion() {
+			return fmt.Errorf("%w: %d", ErrUnknownSchemaVersion, version)
+		}
+
+		var checkErr error
+		for err := range tx.Check() {
+			if checkErr == nil {
+				checkErr = err
+			}
+		}
+		return checkErr
+	})
+}
@@ -0,0 +1,110 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Claim は通知済みの確認と通知の確保を 1 つのトランザクションで行う
+// 確保できた場合は通知処理の後に Register* で確定するか、FailClaim で解放すること
+func (c *Client) Claim(spaceID string, status SpaceNotificationStatus) (bool, error) {
+	claimed := false
+	err := c.modify(spaceID, func(record *Space) error {
+		claimed = claimRecord(record, status)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return claimed, nil
+}
+
+// FailClaim は確保した通知を解放し、次回の確認で再度確保できるようにする
+func (c *Client) FailClaim(spaceID string, status SpaceNotificationStatus) error {
+	return c.modify(spaceID, func(record *Space) error {
+		failClaimRecord(record, status)
+		return nil
+	})
+}
+
+// RecoverClaims は確定されずに残った確保を失敗扱いにし、対象のスペースを返す
+// 起動時に前回の異常終了で残った確保を解放するために呼び出す
+func (c *Client) RecoverClaims() ([]*Space, error) {
+	var recovered []*Space
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+
+		var records []*Space
+		err := b.ForEach(func(k, v []byte) error {
+			var s Space
+			if err := proto.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Claim != nil && s.Claim.State == ClaimState_CLAIM_PENDING {
+				records = append(records, &s)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range records {
+			s.Claim.State = ClaimState_CLAIM_FAILED
+			data, err := proto.Marshal(s)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(s.Id), data); err != nil {
+				return err
+			}
+			recovered = append(recovered, s)
+		}
+		return nil
+	})
+	return recovered, err
+}
+
+func claimRecord(record *Space, status SpaceNotificationStatus) bool {
+	if status <= record.NotificationStatus {
+		return false
+	}
+	if claim := record.Claim; claim != nil && claim.State == ClaimState_CLAIM_PENDING && status <= claim.NotificationStatus {
+		return false
+	}
+
+	record.Claim = &Claim{
+		NotificationStatus: status,
+		State:              ClaimState_CLAIM_PENDING,
+		ClaimedAt:          timestamppb.Now(),
+	}
+	return true
+}
+
+func failClaimRecord(record *Space, status SpaceNotificationStatus) {
+	if claim := record.Claim; claim != nil && claim.State == ClaimState_CLAIM_PENDING && claim.NotificationStatus == status {
+		claim.State = ClaimState_CLAIM_FAILED
+	}
+}
@@ -26,24 +26,42 @@ import (
 )
 
 const (
-	bucketSpace = "space"
+	bucketSpace   = "space"
+	bucketOutbox  = "outbox"
+	bucketHistory = "history"
+	bucketWatched = "watched"
 )
 
+const (
+	StateScheduled = "scheduled"
+	StateLive      = "live"
+	StateEnded     = "ended"
+	StateCanceled  = "canceled"
+)
+
+func IsClosedState(state string) bool {
+	return state == StateEnded || state == StateCanceled
+}
+
+// IsActiveState は状態を記録済みで終了・キャンセルしていない場合に true を返す
+// 通知の確保だけで作成された状態のない記録は対象外とする
+func IsActiveState(state string) bool {
+	return state != "" && !IsClosedState(state)
+}
+
 type Client struct {
 	db *bolt.DB
 }
 
 func Open(path string) (*Client, error) {
-	db, err := bolt.Open(path, 0600, nil)
+	// 起動中の別プロセスがロックを保持している場合は待たずにエラーにする
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucketSpace))
-		return err
-	})
-	if err != nil {
+	if err := migrate(db, path); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -93,33 +111,215 @@ func (c *Client) CheckNotified(spaceID string, status SpaceNotificationStatus) (
 }
 
 func (c *Client) RegisterSchedule(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error {
-	return c.register(&Space{
-		Id:                 spaceID,
-		CreatorId:          creatorID,
-		ScreenName:         screenName,
-		Title:              title,
-		NotificationStatus: SpaceNotificationStatus_SCHEDULE,
-		ScheduledStart:     timestamppb.New(scheduledStart),
-		StartedAt:          nil,
-		CreatedAt:          timestamppb.New(createdAt),
-	})
+	return c.register(newScheduleRecord(spaceID, creatorID, screenName, title, SpaceNotificationStatus_SCHEDULE, scheduledStart, createdAt))
 }
 
 func (c *Client) RegisterScheduleRemind(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error {
-	return c.register(&Space{
+	return c.register(newScheduleRecord(spaceID, creatorID, screenName, title, SpaceNotificationStatus_SCHEDULE_REMIND, scheduledStart, createdAt))
+}
+
+func (c *Client) RegisterStart(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error {
+	return c.register(newStartRecord(spaceID, creatorID, screenName, title, startedAt, createdAt))
+}
+
+func (c *Client) RegisterEnd(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error {
+	return c.register(newEndRecord(spaceID, creatorID, screenName, title, startedAt, createdAt))
+}
+
+func (c *Client) GetSpace(spaceID string) (*Space, error) {
+	var record *Space
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+
+		data := b.Get([]byte(spaceID))
+		if data == nil {
+			return nil
+		}
+
+		record = &Space{}
+		return proto.Unmarshal(data, record)
+	})
+	return record, err
+}
+
+// GetActiveSpaces は状態を記録済みで、終了・キャンセルを確認していないスペースの一覧を返す
+func (c *Client) GetActiveSpaces() ([]*Space, error) {
+	var records []*Space
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var s Space
+			if err := proto.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if IsActiveState(s.State) {
+				records = append(records, &s)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (c *Client) GetTweets(spaceID string) ([]*Tweet, error) {
+	record, err := c.GetSpace(spaceID)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return record.Tweets, nil
+}
+
+func (c *Client) AddTweet(spaceID string, status SpaceNotificationStatus, tweetID int64) error {
+	return c.modify(spaceID, func(record *Space) error {
+		record.Tweets = append(record.Tweets, &Tweet{
+			Id:                 tweetID,
+			NotificationStatus: status,
+		})
+		return nil
+	})
+}
+
+func (c *Client) ClearTweets(spaceID string) error {
+	return c.modify(spaceID, func(record *Space) error {
+		record.Tweets = nil
+		return nil
+	})
+}
+
+func (c *Client) SetState(spaceID, state string) error {
+	return c.modify(spaceID, func(record *Space) error {
+		setState(record, state)
+		return nil
+	})
+}
+
+func (c *Client) SetScheduledStart(spaceID string, scheduledStart time.Time) error {
+	return c.modify(spaceID, func(record *Space) error {
+		record.ScheduledStart = timestamppb.New(scheduledStart)
+		return nil
+	})
+}
+
+// DeleteSpace はスペースの記録と配送を削除し、未通知の状態に戻す
+func (c *Client) DeleteSpace(spaceID string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+		if err := b.Delete([]byte(spaceID)); err != nil {
+			return err
+		}
+		return deleteDeliveries(tx, spaceID)
+	})
+}
+
+// AddParticipantCount は配信中の参加者数を記録する
+func (c *Client) AddParticipantCount(spaceID string, t time.Time, count int64) error {
+	return c.modify(spaceID, func(record *Space) error {
+		addParticipantCount(record, t, count)
+		return nil
+	})
+}
+
+// ClaimMilestone は参加者数の節目を通知済みとして記録し、未通知だったかを返す
+func (c *Client) ClaimMilestone(spaceID string, threshold int64) (bool, error) {
+	claimed := false
+	err := c.modify(spaceID, func(record *Space) error {
+		claimed = claimMilestone(record, threshold)
+		return nil
+	})
+	return claimed, err
+}
+
+// ClaimJoined はスペースに参加したユーザーを通知済みとして記録し、未通知だったかを返す
+func (c *Client) ClaimJoined(spaceID, userID string) (bool, error) {
+	claimed := false
+	err := c.modify(spaceID, func(record *Space) error {
+		claimed = claimJoined(record, userID)
+		return nil
+	})
+	return claimed, err
+}
+
+func (c *Client) modify(spaceID string, f func(record *Space) error) error {
+	key := spaceID
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+
+		record := &Space{
+			Id: spaceID,
+		}
+		if data := b.Get([]byte(key)); data != nil {
+			if err := proto.Unmarshal(data, record); err != nil {
+				return err
+			}
+		}
+
+		if err := f(record); err != nil {
+			return err
+		}
+
+		data, err := proto.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+func (c *Client) register(record *Space) error {
+	key := record.Id
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+
+		var prev *Space
+		if data := b.Get([]byte(key)); data != nil {
+			prev = &Space{}
+			if err := proto.Unmarshal(data, prev); err != nil {
+				return err
+			}
+		}
+		inheritRecord(record, prev)
+
+		data, err := proto.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(key), data); err != nil {
+			return err
+		}
+
+		return appendEvent(tx, notifiedEvent(record))
+	})
+}
+
+func newScheduleRecord(spaceID, creatorID, screenName, title string, status SpaceNotificationStatus, scheduledStart, createdAt time.Time) *Space {
+	return &Space{
 		Id:                 spaceID,
 		CreatorId:          creatorID,
 		ScreenName:         screenName,
 		Title:              title,
-		NotificationStatus: SpaceNotificationStatus_SCHEDULE_REMIND,
+		NotificationStatus: status,
 		ScheduledStart:     timestamppb.New(scheduledStart),
 		StartedAt:          nil,
 		CreatedAt:          timestamppb.New(createdAt),
-	})
+		State:              StateScheduled,
+	}
 }
 
-func (c *Client) RegisterStart(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error {
-	return c.register(&Space{
+func newStartRecord(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) *Space {
+	return &Space{
 		Id:                 spaceID,
 		CreatorId:          creatorID,
 		ScreenName:         screenName,
@@ -128,17 +328,52 @@ func (c *Client) RegisterStart(spaceID, creatorID, screenName, title string, sta
 		ScheduledStart:     nil,
 		StartedAt:          timestamppb.New(startedAt),
 		CreatedAt:          timestamppb.New(createdAt),
-	})
+		State:              StateLive,
+	}
 }
 
-func (c *Client) register(record *Space) error {
-	data, err := proto.Marshal(record)
-	if err != nil {
-		return err
+func newEndRecord(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) *Space {
+	record := newStartRecord(spaceID, creatorID, screenName, title, startedAt, createdAt)
+	record.NotificationStatus = SpaceNotificationStatus_END
+	record.State = StateEnded
+	return record
+}
+
+// inheritRecord は登録前の記録から投稿済みツイート・確保・参加者数・節目・参加の通知・終了日時を引き継ぎ、登録したステータスの確保を確定する
+func inheritRecord(record, prev *Space) {
+	if prev != nil {
+		record.Tweets = prev.Tweets
+		record.Claim = prev.Claim
+		record.Participants = prev.Participants
+		record.Milestones = prev.Milestones
+		record.JoinedUserIds = prev.JoinedUserIds
+		if record.ClosedAt == nil {
+			record.ClosedAt = prev.ClosedAt
+		}
+	}
+
+	if record.Claim != nil && record.Claim.NotificationStatus <= record.NotificationStatus {
+		record.Claim.State = ClaimState_CLAIM_COMMITTED
+	}
+}
+
+func notifiedEvent(record *Space) *Event {
+	return &Event{
+		SpaceId:            record.Id,
+		CreatorId:          record.CreatorId,
+		ScreenName:         record.ScreenName,
+		Type:               EventType_EVENT_NOTIFIED,
+		State:              record.State,
+		Title:              record.Title,
+		NotificationStatus: record.NotificationStatus,
+		ScheduledStart:     record.ScheduledStart,
+		StartedAt:          record.StartedAt,
+	}
+}
+
+func setState(record *Space, state string) {
+	record.State = state
+	if IsClosedState(state) && record.ClosedAt == nil {
+		record.ClosedAt = timestamppb.Now()
 	}
-	key := record.Id
-	return c.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketSpace))
-		return b.Put([]byte(key), data)
-	})
 }
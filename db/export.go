@@ -0,0 +1,147 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+)
+
+func (c *Client) ForEachSpace(f func(s *Space) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var s Space
+			if err := proto.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			return f(&s)
+		})
+	})
+}
+
+// ForEachEvent は履歴を ID とともに列挙する
+// ID は ImportEvent で同じ履歴を重複して取り込まないために使用する
+func (c *Client) ForEachEvent(f func(id string, e *Event) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketHistory))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketHistory)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var e Event
+			if err := proto.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			return f(hex.EncodeToString(k), &e)
+		})
+	})
+}
+
+// MergeSpace はスペースの記録を ID で統合する
+// 通知ステータスが進んでいる方を優先し、通知ステータスを戻すことはしない
+func (c *Client) MergeSpace(s *Space) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+
+		var current *Space
+		if data := b.Get([]byte(s.Id)); data != nil {
+			current = &Space{}
+			if err := proto.Unmarshal(data, current); err != nil {
+				return err
+			}
+		}
+
+		data, err := proto.Marshal(mergeRecord(current, s))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(s.Id), data)
+	})
+}
+
+// ImportEvent は ForEachEvent で列挙した履歴を取り込む
+// 同じ ID の履歴が存在する場合は何もしない
+func (c *Client) ImportEvent(id string, e *Event) error {
+	key, err := hex.DecodeString(id)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketHistory))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketHistory)
+		}
+
+		if b.Get(key) != nil {
+			return nil
+		}
+
+		data, err := proto.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+}
+
+// mergeRecord は取り込む記録 s を現在の記録 current に統合した記録を返す
+func mergeRecord(current, s *Space) *Space {
+	merged := proto.Clone(s).(*Space)
+	// 取り込み元で確定していない確保は引き継がない
+	if merged.Claim != nil && merged.Claim.State == ClaimState_CLAIM_PENDING {
+		merged.Claim = nil
+	}
+
+	if current != nil {
+		tweets := mergeTweets(current.Tweets, merged.Tweets)
+		milestones := mergeMilestones(current.Milestones, merged.Milestones)
+		joined := mergeJoined(current.JoinedUserIds, merged.JoinedUserIds)
+		if merged.NotificationStatus <= current.NotificationStatus {
+			merged = current
+		}
+		merged.Tweets = tweets
+		merged.Milestones = milestones
+		merged.JoinedUserIds = joined
+	}
+	return merged
+}
+
+func mergeTweets(a, b []*Tweet) []*Tweet {
+	seen := make(map[int64]bool)
+	var tweets []*Tweet
+	for _, t := range append(append([]*Tweet{}, a...), b...) {
+		if seen[t.Id] {
+			continue
+		}
+		seen[t.Id] = true
+		tweets = append(tweets, t)
+	}
+	return tweets
+}
@@ -0,0 +1,209 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type HistoryQuery struct {
+	CreatorID string
+	From      time.Time
+	To        time.Time
+}
+
+func (q *HistoryQuery) match(e *Event) bool {
+	if q.CreatorID != "" && e.CreatorId != q.CreatorID {
+		return false
+	}
+	t := e.Timestamp.AsTime()
+	if !q.From.IsZero() && t.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !t.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// historyKey はスペース ID、時刻、連番の順に並ぶキーを返す
+func historyKey(spaceID string, t time.Time, seq uint64) []byte {
+	prefix := historyPrefix(spaceID)
+	key := make([]byte, len(prefix)+16)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[len(prefix)+8:], seq)
+	return key
+}
+
+func historyPrefix(spaceID string) []byte {
+	return []byte(spaceID + "/")
+}
+
+// RecordObservation は前回の観測から状態・タイトル・開始予定日時が変化していれば履歴に追加する
+func (c *Client) RecordObservation(e *Event) error {
+	e.Type = EventType_EVENT_OBSERVED
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketHistory))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketHistory)
+		}
+
+		prev, err := lastObservation(b, e.SpaceId)
+		if err != nil {
+			return err
+		}
+		if sameObservation(prev, e) {
+			return nil
+		}
+
+		return appendEvent(tx, e)
+	})
+}
+
+// GetHistory はスペースの履歴を時刻順に返す
+func (c *Client) GetHistory(spaceID string) ([]*Event, error) {
+	var events []*Event
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketHistory))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketHistory)
+		}
+
+		cursor := b.Cursor()
+		prefix := historyPrefix(spaceID)
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var e Event
+			if err := proto.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			events = append(events, &e)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// QueryHistory は作成者・期間で絞り込んだ履歴を時刻順に返す
+func (c *Client) QueryHistory(q HistoryQuery) ([]*Event, error) {
+	var events []*Event
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketHistory))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketHistory)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var e Event
+			if err := proto.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.match(&e) {
+				events = append(events, &e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.AsTime().Before(events[j].Timestamp.AsTime())
+	})
+	return events, nil
+}
+
+// sameObservation は前回の観測から状態・タイトル・開始予定日時が変化していないかを返す
+func sameObservation(prev, e *Event) bool {
+	return prev != nil && prev.State == e.State && prev.Title == e.Title && proto.Equal(prev.ScheduledStart, e.ScheduledStart)
+}
+
+func lastObservation(b *bolt.Bucket, spaceID string) (*Event, error) {
+	cursor := b.Cursor()
+	prefix := historyPrefix(spaceID)
+
+	// プレフィックスの末尾から逆順に探す
+	end := append(append([]byte{}, prefix...), 0xff)
+	k, v := cursor.Seek(end)
+	if k == nil {
+		k, v = cursor.Last()
+	} else {
+		k, v = cursor.Prev()
+	}
+
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Prev() {
+		var e Event
+		if err := proto.Unmarshal(v, &e); err != nil {
+			return nil, err
+		}
+		if e.Type == EventType_EVENT_OBSERVED {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+// appendEvent は履歴を追加する
+// 作成者が未設定の場合はスペースの記録から補完する
+func appendEvent(tx *bolt.Tx, e *Event) error {
+	b := tx.Bucket([]byte(bucketHistory))
+	if b == nil {
+		return errors.New("bucket not found: " + bucketHistory)
+	}
+
+	if e.Timestamp == nil {
+		e.Timestamp = timestamppb.Now()
+	}
+
+	if e.CreatorId == "" {
+		if data := tx.Bucket([]byte(bucketSpace)).Get([]byte(e.SpaceId)); data != nil {
+			var s Space
+			if err := proto.Unmarshal(data, &s); err != nil {
+				return err
+			}
+			fillEventCreator(e, &s)
+		}
+	}
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(historyKey(e.SpaceId, e.Timestamp.AsTime(), seq), data)
+}
+
+func fillEventCreator(e *Event, s *Space) {
+	e.CreatorId = s.CreatorId
+	e.ScreenName = s.ScreenName
+	if e.Title == "" {
+		e.Title = s.Title
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	forEachStore(t, testHistory)
+}
+
+func testHistory(t *testing.T, c Store) {
+	observe := func(spaceID, creatorID, state, title string) {
+		err := c.RecordObservation(&Event{
+			SpaceId:   spaceID,
+			CreatorId: creatorID,
+			State:     state,
+			Title:     title,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	observe("space1", "user1", StateScheduled, "title")
+	observe("space1", "user1", StateScheduled, "title")
+	observe("space2", "user2", StateLive, "title")
+	observe("space1", "user1", StateScheduled, "new title")
+	observe("space1", "user1", StateScheduled, "new title")
+
+	now := time.Now()
+	if err := c.RegisterStart("space1", "user1", "user1", "new title", now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := c.GetHistory("space1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []EventType{EventType_EVENT_OBSERVED, EventType_EVENT_OBSERVED, EventType_EVENT_NOTIFIED}
+	if len(events) != len(types) {
+		t.Fatalf("GetHistory, actual: %d events, expected: %d", len(events), len(types))
+	}
+	for i, e := range events {
+		if e.Type != types[i] {
+			t.Errorf("GetHistory[%d], actual: %v, expected: %v", i, e.Type, types[i])
+		}
+	}
+
+	events, err = c.QueryHistory(HistoryQuery{CreatorID: "user2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].SpaceId != "space2" {
+		t.Errorf("QueryHistory by creator, actual: %v", events)
+	}
+
+	events, err = c.QueryHistory(HistoryQuery{From: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("QueryHistory by time, actual: %v", events)
+	}
+}
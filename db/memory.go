@@ -0,0 +1,587 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var ErrNotSupported = errors.New("not supported by memory store")
+
+// MemoryClient はメモリ上に保存する Store
+// 終了すると内容は失われるため、テストや状態を残さない実行に使用する
+type MemoryClient struct {
+	mu         sync.Mutex
+	spaces     map[string]*Space
+	deliveries map[string]*Delivery
+	events     map[string]*Event
+	watched    map[string]*WatchedSpace
+	seq        uint64
+}
+
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{
+		spaces:     make(map[string]*Space),
+		deliveries: make(map[string]*Delivery),
+		events:     make(map[string]*Event),
+		watched:    make(map[string]*WatchedSpace),
+	}
+}
+
+func (c *MemoryClient) Close() error {
+	return nil
+}
+
+func (c *MemoryClient) GetNotifiedStatus(spaceID string) (SpaceNotificationStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.spaces[spaceID]; ok {
+		return s.NotificationStatus, nil
+	}
+	return SpaceNotificationStatus_NONE, nil
+}
+
+func (c *MemoryClient) CheckNotified(spaceID string, status SpaceNotificationStatus) (bool, error) {
+	prevStatus, err := c.GetNotifiedStatus(spaceID)
+	if err != nil {
+		return false, err
+	}
+	return status <= prevStatus, nil
+}
+
+func (c *MemoryClient) RegisterSchedule(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error {
+	return c.register(newScheduleRecord(spaceID, creatorID, screenName, title, SpaceNotificationStatus_SCHEDULE, scheduledStart, createdAt))
+}
+
+func (c *MemoryClient) RegisterScheduleRemind(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error {
+	return c.register(newScheduleRecord(spaceID, creatorID, screenName, title, SpaceNotificationStatus_SCHEDULE_REMIND, scheduledStart, createdAt))
+}
+
+func (c *MemoryClient) RegisterStart(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error {
+	return c.register(newStartRecord(spaceID, creatorID, screenName, title, startedAt, createdAt))
+}
+
+func (c *MemoryClient) RegisterEnd(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error {
+	return c.register(newEndRecord(spaceID, creatorID, screenName, title, startedAt, createdAt))
+}
+
+// Claim は通知済みの確認と通知の確保を同じロックの中で行う
+func (c *MemoryClient) Claim(spaceID string, status SpaceNotificationStatus) (bool, error) {
+	claimed := false
+	c.modify(spaceID, func(record *Space) {
+		claimed = claimRecord(record, status)
+	})
+	return claimed, nil
+}
+
+func (c *MemoryClient) FailClaim(spaceID string, status SpaceNotificationStatus) error {
+	c.modify(spaceID, func(record *Space) {
+		failClaimRecord(record, status)
+	})
+	return nil
+}
+
+func (c *MemoryClient) RecoverClaims() ([]*Space, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var recovered []*Space
+	for _, id := range c.spaceIDs() {
+		s := c.spaces[id]
+		if s.Claim != nil && s.Claim.State == ClaimState_CLAIM_PENDING {
+			s.Claim.State = ClaimState_CLAIM_FAILED
+			recovered = append(recovered, cloneSpace(s))
+		}
+	}
+	return recovered, nil
+}
+
+func (c *MemoryClient) GetSpace(spaceID string) (*Space, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.spaces[spaceID]; ok {
+		return cloneSpace(s), nil
+	}
+	return nil, nil
+}
+
+// GetActiveSpaces は状態を記録済みで、終了・キャンセルを確認していないスペースの一覧を返す
+func (c *MemoryClient) GetActiveSpaces() ([]*Space, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var records []*Space
+	for _, id := range c.spaceIDs() {
+		if s := c.spaces[id]; IsActiveState(s.State) {
+			records = append(records, cloneSpace(s))
+		}
+	}
+	return records, nil
+}
+
+func (c *MemoryClient) SetState(spaceID, state string) error {
+	c.modify(spaceID, func(record *Space) {
+		setState(record, state)
+	})
+	return nil
+}
+
+func (c *MemoryClient) SetScheduledStart(spaceID string, scheduledStart time.Time) error {
+	c.modify(spaceID, func(record *Space) {
+		record.ScheduledStart = timestamppb.New(scheduledStart)
+	})
+	return nil
+}
+
+// DeleteSpace はスペースの記録と配送を削除し、未通知の状態に戻す
+func (c *MemoryClient) DeleteSpace(spaceID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.spaces, spaceID)
+	prefix := string(deliveryPrefix(spaceID))
+	for key := range c.deliveries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.deliveries, key)
+		}
+	}
+	return nil
+}
+
+// AddParticipantCount は配信中の参加者数を記録する
+func (c *MemoryClient) AddParticipantCount(spaceID string, t time.Time, count int64) error {
+	c.modify(spaceID, func(record *Space) {
+		addParticipantCount(record, t, count)
+	})
+	return nil
+}
+
+// ClaimMilestone は参加者数の節目を通知済みとして記録し、未通知だったかを返す
+func (c *MemoryClient) ClaimMilestone(spaceID string, threshold int64) (bool, error) {
+	claimed := false
+	c.modify(spaceID, func(record *Space) {
+		claimed = claimMilestone(record, threshold)
+	})
+	return claimed, nil
+}
+
+// ClaimJoined はスペースに参加したユーザーを通知済みとして記録し、未通知だったかを返す
+func (c *MemoryClient) ClaimJoined(spaceID, userID string) (bool, error) {
+	claimed := false
+	c.modify(spaceID, func(record *Space) {
+		claimed = claimJoined(record, userID)
+	})
+	return claimed, nil
+}
+
+// AddWatchedSpace は手動で監視するスペースを登録し、新たに登録したかを返す
+func (c *MemoryClient) AddWatchedSpace(spaceID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.watched[spaceID]; ok {
+		return false, nil
+	}
+	c.watched[spaceID] = newWatchedSpace(spaceID, time.Now())
+	return true, nil
+}
+
+// GetWatchedSpaces は手動で監視するスペースを登録順に返す
+func (c *MemoryClient) GetWatchedSpaces() ([]*WatchedSpace, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var watched []*WatchedSpace
+	for _, w := range c.watched {
+		watched = append(watched, proto.Clone(w).(*WatchedSpace))
+	}
+	sortWatchedSpaces(watched)
+	return watched, nil
+}
+
+func (c *MemoryClient) RemoveWatchedSpace(spaceID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.watched, spaceID)
+	return nil
+}
+
+func (c *MemoryClient) GetTweets(spaceID string) ([]*Tweet, error) {
+	record, err := c.GetSpace(spaceID)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return record.Tweets, nil
+}
+
+func (c *MemoryClient) AddTweet(spaceID string, status SpaceNotificationStatus, tweetID int64) error {
+	c.modify(spaceID, func(record *Space) {
+		record.Tweets = append(record.Tweets, &Tweet{
+			Id:                 tweetID,
+			NotificationStatus: status,
+		})
+	})
+	return nil
+}
+
+func (c *MemoryClient) ClearTweets(spaceID string) error {
+	c.modify(spaceID, func(record *Space) {
+		record.Tweets = nil
+	})
+	return nil
+}
+
+// EnqueueDeliveries は通知先ごとの配送を登録する
+// 登録済みの配送は変更せず、同じスペースの以前のステータスで未配送のものは破棄する
+// key は同じステータスで複数回通知するイベントを区別する
+func (c *MemoryClient) EnqueueDeliveries(spaceID string, status SpaceNotificationStatus, key string, sinks []string, space, user []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	prefix := string(deliveryPrefix(spaceID))
+	for key, d := range c.deliveries {
+		if strings.HasPrefix(key, prefix) {
+			supersedeDelivery(d, status)
+		}
+	}
+
+	for _, sink := range sinks {
+		k := string(deliveryKey(spaceID, status, key, sink))
+		if _, ok := c.deliveries[k]; ok {
+			continue
+		}
+		c.deliveries[k] = newDelivery(spaceID, status, key, sink, space, user, now)
+	}
+	return nil
+}
+
+// GetDueDeliveries は配送時刻を過ぎた未配送の一覧を返す
+func (c *MemoryClient) GetDueDeliveries(now time.Time) ([]*Delivery, error) {
+	return c.findDeliveries(func(d *Delivery) bool {
+		return isDue(d, now)
+	}), nil
+}
+
+func (c *MemoryClient) GetDeliveries(state DeliveryState) ([]*Delivery, error) {
+	return c.findDeliveries(func(d *Delivery) bool {
+		return d.State == state
+	}), nil
+}
+
+func (c *MemoryClient) MarkDelivered(d *Delivery) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERED, markDelivered)
+}
+
+// MarkRetry は配送の失敗を記録し、次の配送時刻を設定する
+func (c *MemoryClient) MarkRetry(d *Delivery, cause error, nextAttempt time.Time) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_FAILED, markRetry(cause, nextAttempt))
+}
+
+// MarkDead は配送を諦めてデッドレターにする
+func (c *MemoryClient) MarkDead(d *Delivery, cause error) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DEAD, markDead(cause))
+}
+
+// MarkDeferred は失敗として数えずに次の配送時刻を設定する
+func (c *MemoryClient) MarkDeferred(d *Delivery, nextAttempt time.Time) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DEFERRED, markDeferred(nextAttempt))
+}
+
+// MarkDropped は配送せずに破棄する
+func (c *MemoryClient) MarkDropped(d *Delivery, reason string) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DROPPED, markDropped(reason))
+}
+
+// RecordObservation は前回の観測から状態・タイトル・開始予定日時が変化していれば履歴に追加する
+func (c *MemoryClient) RecordObservation(e *Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.Type = EventType_EVENT_OBSERVED
+
+	var prev *Event
+	for _, id := range c.eventIDs(historyPrefix(e.SpaceId)) {
+		if ev := c.events[id]; ev.Type == EventType_EVENT_OBSERVED {
+			prev = ev
+		}
+	}
+	if sameObservation(prev, e) {
+		return nil
+	}
+
+	c.appendEvent(e)
+	return nil
+}
+
+// GetHistory はスペースの履歴を時刻順に返す
+func (c *MemoryClient) GetHistory(spaceID string) ([]*Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var events []*Event
+	for _, id := range c.eventIDs(historyPrefix(spaceID)) {
+		events = append(events, proto.Clone(c.events[id]).(*Event))
+	}
+	return events, nil
+}
+
+// QueryHistory は作成者・期間で絞り込んだ履歴を時刻順に返す
+func (c *MemoryClient) QueryHistory(q HistoryQuery) ([]*Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var events []*Event
+	for _, id := range c.eventIDs(nil) {
+		if e := c.events[id]; q.match(e) {
+			events = append(events, proto.Clone(e).(*Event))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.AsTime().Before(events[j].Timestamp.AsTime())
+	})
+	return events, nil
+}
+
+// DeleteClosedSpaces は before より前に終了・キャンセルされたスペースの記録と配送済みの outbox を削除する
+// 状態のない記録は最終更新日時が before より前のものを削除する
+func (c *MemoryClient) DeleteClosedSpaces(before time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+	for id, s := range c.spaces {
+		if !isExpirableState(s.State) {
+			continue
+		}
+		if t := lastActivity(s); t == nil || !t.AsTime().Before(before) {
+			continue
+		}
+
+		delete(c.spaces, id)
+		prefix := string(deliveryPrefix(id))
+		for key, d := range c.deliveries {
+			if strings.HasPrefix(key, prefix) && d.State != DeliveryState_DELIVERY_PENDING {
+				delete(c.deliveries, key)
+			}
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// DeleteHistory は before より前の履歴を削除する
+func (c *MemoryClient) DeleteHistory(before time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+	for id, e := range c.events {
+		if e.Timestamp.AsTime().Before(before) {
+			delete(c.events, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (c *MemoryClient) Backup(dst string) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (c *MemoryClient) ForEachSpace(f func(s *Space) error) error {
+	c.mu.Lock()
+	var records []*Space
+	for _, id := range c.spaceIDs() {
+		records = append(records, cloneSpace(c.spaces[id]))
+	}
+	c.mu.Unlock()
+
+	for _, s := range records {
+		if err := f(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachEvent は履歴を ID とともに列挙する
+func (c *MemoryClient) ForEachEvent(f func(id string, e *Event) error) error {
+	c.mu.Lock()
+	ids := c.eventIDs(nil)
+	events := make([]*Event, len(ids))
+	for i, id := range ids {
+		events[i] = proto.Clone(c.events[id]).(*Event)
+	}
+	c.mu.Unlock()
+
+	for i, id := range ids {
+		if err := f(id, events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeSpace はスペースの記録を ID で統合する
+// 通知ステータスが進んでいる方を優先し、通知ステータスを戻すことはしない
+func (c *MemoryClient) MergeSpace(s *Space) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var current *Space
+	if prev, ok := c.spaces[s.Id]; ok {
+		current = cloneSpace(prev)
+	}
+	c.spaces[s.Id] = mergeRecord(current, s)
+	return nil
+}
+
+// ImportEvent は ForEachEvent で列挙した履歴を取り込む
+// 同じ ID の履歴が存在する場合は何もしない
+func (c *MemoryClient) ImportEvent(id string, e *Event) error {
+	if _, err := hex.DecodeString(id); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.events[id]; !ok {
+		c.events[id] = proto.Clone(e).(*Event)
+	}
+	return nil
+}
+
+func (c *MemoryClient) modify(spaceID string, f func(record *Space)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, ok := c.spaces[spaceID]
+	if !ok {
+		record = &Space{
+			Id: spaceID,
+		}
+		c.spaces[spaceID] = record
+	}
+	f(record)
+}
+
+func (c *MemoryClient) register(record *Space) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inheritRecord(record, c.spaces[record.Id])
+	c.spaces[record.Id] = cloneSpace(record)
+	c.appendEvent(notifiedEvent(record))
+	return nil
+}
+
+// updateDelivery は配送の状態を更新し、同じロックの中で履歴に記録する
+func (c *MemoryClient) updateDelivery(d *Delivery, eventType EventType, f func(d *Delivery)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.deliveries[string(deliveryKey(d.SpaceId, d.NotificationStatus, d.Key, d.Sink))]
+	if !ok {
+		return errors.New("delivery not found: " + d.SpaceId + "/" + d.Sink)
+	}
+	f(current)
+
+	c.appendEvent(deliveryEvent(current, eventType))
+
+	proto.Reset(d)
+	proto.Merge(d, current)
+	return nil
+}
+
+func (c *MemoryClient) findDeliveries(match func(d *Delivery) bool) []*Delivery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.deliveries))
+	for key := range c.deliveries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var deliveries []*Delivery
+	for _, key := range keys {
+		if d := c.deliveries[key]; match(d) {
+			deliveries = append(deliveries, proto.Clone(d).(*Delivery))
+		}
+	}
+	return deliveries
+}
+
+// appendEvent は履歴を追加する
+// 作成者が未設定の場合はスペースの記録から補完する
+func (c *MemoryClient) appendEvent(e *Event) {
+	if e.Timestamp == nil {
+		e.Timestamp = timestamppb.Now()
+	}
+
+	if e.CreatorId == "" {
+		if s, ok := c.spaces[e.SpaceId]; ok {
+			fillEventCreator(e, s)
+		}
+	}
+
+	c.seq++
+	id := hex.EncodeToString(historyKey(e.SpaceId, e.Timestamp.AsTime(), c.seq))
+	c.events[id] = proto.Clone(e).(*Event)
+}
+
+func (c *MemoryClient) spaceIDs() []string {
+	ids := make([]string, 0, len(c.spaces))
+	for id := range c.spaces {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// eventIDs は prefix で始まるキーの履歴の ID を bolt と同じキーの順に返す
+func (c *MemoryClient) eventIDs(prefix []byte) []string {
+	var ids []string
+	for id := range c.events {
+		key, err := hex.DecodeString(id)
+		if err != nil || !bytes.HasPrefix(key, prefix) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func cloneSpace(s *Space) *Space {
+	return proto.Clone(s).(*Space)
+}
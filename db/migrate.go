@@ -0,0 +1,148 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	bucketMeta       = "meta"
+	keySchemaVersion = "schema_version"
+)
+
+var ErrUnknownSchemaVersion = errors.New("unknown schema version")
+
+type migration struct {
+	version int
+	name    string
+	migrate func(tx *bolt.Tx) error
+}
+
+// migrations はスキーマの変更履歴
+// 追加のみ行い、既存の要素は変更しないこと
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create space bucket",
+		migrate: createBuckets(bucketSpace),
+	},
+	{
+		version: 2,
+		name:    "create outbox and history buckets",
+		migrate: createBuckets(bucketOutbox, bucketHistory),
+	},
+	{
+		version: 3,
+		name:    "create watched bucket",
+		migrate: createBuckets(bucketWatched),
+	},
+}
+
+// SchemaVersion はこのバージョンが扱うスキーマのバージョンを返す
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func createBuckets(names ...string) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// migrate は未適用のマイグレーションを順に適用する
+// 既存のデータがある場合は適用前にバックアップを作成する
+func migrate(db *bolt.DB, path string) error {
+	var version int
+	var empty bool
+	err := db.View(func(tx *bolt.Tx) error {
+		version = readSchemaVersion(tx)
+		k, _ := tx.Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	latest := SchemaVersion()
+	if version > latest {
+		return fmt.Errorf("%w: %d (supported: %d)", ErrUnknownSchemaVersion, version, latest)
+	}
+	if version == latest {
+		return nil
+	}
+
+	if !empty {
+		backup := fmt.Sprintf("%s.v%d.%s.bak", path, version, time.Now().Format("20060102150405"))
+		err := db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backup, 0600)
+		})
+		if err != nil {
+			return fmt.Errorf("backup before migration failed: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.migrate(tx); err != nil {
+				return err
+			}
+			return writeSchemaVersion(tx, m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func readSchemaVersion(tx *bolt.Tx) int {
+	b := tx.Bucket([]byte(bucketMeta))
+	if b == nil {
+		return 0
+	}
+	data := b.Get([]byte(keySchemaVersion))
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(bucketMeta))
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(version))
+	return b.Put([]byte(keySchemaVersion), data)
+}
@@ -0,0 +1,227 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestMigrate は古いバージョンのスキーマの bolt を最新に更新できることを確認する
+func TestMigrate(t *testing.T) {
+	for version := 0; version < SchemaVersion(); version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			createBoltSchema(t, path, version)
+
+			c, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			err = c.db.View(func(tx *bolt.Tx) error {
+				if v := readSchemaVersion(tx); v != SchemaVersion() {
+					t.Errorf("schema version, actual: %d, expected: %d", v, SchemaVersion())
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMigratedStore(t, c, path)
+		})
+	}
+
+	// 新しいバージョンのスキーマは開かない
+	path := filepath.Join(t.TempDir(), "test.db")
+	createBoltSchema(t, path, SchemaVersion()+1)
+	if _, err := Open(path); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Errorf("Open newer schema, actual: %v", err)
+	}
+}
+
+// TestMigrateSQLite は古いバージョンのスキーマの SQLite を最新に更新できることを確認する
+func TestMigrateSQLite(t *testing.T) {
+	for version := 1; version < sqliteSchemaVersion(); version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			createSQLiteSchema(t, path, version)
+
+			c, err := OpenSQLite(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if v, err := readUserVersion(c.db); err != nil || v != sqliteSchemaVersion() {
+				t.Errorf("schema version, actual: %d, %v, expected: %d", v, err, sqliteSchemaVersion())
+			}
+
+			// 未配送の配送は主キーを変更した後も配送できる
+			due, err := c.GetDueDeliveries(time.Now())
+			if err != nil || len(due) != 1 || due[0].Key != "" {
+				t.Fatalf("GetDueDeliveries, actual: %v, %v", due, err)
+			}
+			if err := c.MarkDelivered(due[0]); err != nil {
+				t.Error(err)
+			}
+			checkMigratedStore(t, c, path)
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	createSQLiteSchema(t, path, sqliteSchemaVersion()+1)
+	if _, err := OpenSQLite(path); !errors.Is(err, ErrUnknownSchemaVersion) {
+		t.Errorf("OpenSQLite newer schema, actual: %v", err)
+	}
+}
+
+// legacyCreatedAt は状態を記録する前のバージョンで作成した記録の作成日時
+var legacyCreatedAt = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// createBoltSchema は指定したバージョンまでマイグレーションを適用し、配信中のスペースと状態のない古い記録を作成する
+// バージョン 0 はマイグレーション導入前の space バケットのみのデータ
+func createBoltSchema(t *testing.T, path string, version int) {
+	t.Helper()
+
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	data, err := proto.Marshal(&Space{Id: "space1", State: StateLive, NotificationStatus: SpaceNotificationStatus_START})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := proto.Marshal(&Space{Id: "legacy1", NotificationStatus: SpaceNotificationStatus_START, CreatedAt: timestamppb.New(legacyCreatedAt)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, m := range migrations {
+			if m.version > version {
+				break
+			}
+			if err := m.migrate(tx); err != nil {
+				return err
+			}
+		}
+		if version > 0 {
+			if err := writeSchemaVersion(tx, version); err != nil {
+				return err
+			}
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(bucketSpace))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("legacy1"), legacy); err != nil {
+			return err
+		}
+		return b.Put([]byte("space1"), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createSQLiteSchema は指定したバージョンまでマイグレーションを適用し、配信中のスペースと状態のない古い記録、未配送の配送を作成する
+func createSQLiteSchema(t *testing.T, path string, version int) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, m := range sqliteMigrations {
+		if m.version > version {
+			break
+		}
+		for _, stmt := range m.statements {
+			if _, err := db.Exec(stmt); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO space (id, state, notification_status) VALUES (?, ?, ?)`, "space1", StateLive, SpaceNotificationStatus_START); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO space (id, notification_status, created_at) VALUES (?, ?, ?)`, "legacy1", SpaceNotificationStatus_START, formatTime(legacyCreatedAt)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO outbox (space_id, notification_status, sink, state, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		"space1", SpaceNotificationStatus_START, "tweet", DeliveryState_DELIVERY_PENDING, formatTime(legacyCreatedAt), formatTime(legacyCreatedAt)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkMigratedStore は更新前の記録が残り、最新のスキーマの機能を使用できることを確認する
+func checkMigratedStore(t *testing.T, c Store, path string) {
+	t.Helper()
+
+	status, err := c.GetNotifiedStatus("space1")
+	if err != nil || status != SpaceNotificationStatus_START {
+		t.Errorf("GetNotifiedStatus, actual: %v, %v", status, err)
+	}
+
+	// 後から追加したテーブル・バケットを使用できる
+	if err := c.AddParticipantCount("space1", time.Now(), 10); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.ClaimMilestone("space1", 100); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.ClaimJoined("space1", "user1"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.AddWatchedSpace("space2"); err != nil {
+		t.Error(err)
+	}
+
+	// 状態のない古い記録も保持期間を過ぎたら削除する
+	if deleted, err := c.DeleteClosedSpaces(time.Now().Add(-24 * time.Hour)); err != nil || deleted != 1 {
+		t.Errorf("DeleteClosedSpaces, actual: %d, %v", deleted, err)
+	}
+	if record, err := c.GetSpace("legacy1"); err != nil || record != nil {
+		t.Errorf("GetSpace legacy, actual: %v, %v", record, err)
+	}
+	if record, err := c.GetSpace("space1"); err != nil || record == nil {
+		t.Errorf("GetSpace active, actual: %v, %v", record, err)
+	}
+
+	// 更新前のデータはバックアップする
+	backups, err := filepath.Glob(path + ".v*.bak")
+	if err != nil || len(backups) != 1 {
+		t.Errorf("backup, actual: %v, %v", backups, err)
+	}
+}
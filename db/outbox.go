@@ -0,0 +1,286 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// deliveryKey は同じスペースの配送が通知ステータス順に並ぶキーを返す
+// key のない配送は key を導入する前と同じキーになる
+func deliveryKey(spaceID string, status SpaceNotificationStatus, key, sink string) []byte {
+	if key == "" {
+		return []byte(fmt.Sprintf("%s/%02d/%s", spaceID, status, sink))
+	}
+	return []byte(fmt.Sprintf("%s/%02d/%s/%s", spaceID, status, key, sink))
+}
+
+func deliveryPrefix(spaceID string) []byte {
+	return []byte(spaceID + "/")
+}
+
+// deleteDeliveries はスペースの配送をすべて削除する
+// 削除したスペースを再び通知するときに、配送済みの記録で登録が省かれないようにする
+func deleteDeliveries(tx *bolt.Tx, spaceID string) error {
+	b := tx.Bucket([]byte(bucketOutbox))
+	if b == nil {
+		return errors.New("bucket not found: " + bucketOutbox)
+	}
+
+	var keys [][]byte
+	cursor := b.Cursor()
+	prefix := deliveryPrefix(spaceID)
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueDeliveries は通知先ごとの配送を登録する
+// 登録済みの配送は変更せず、同じスペースの以前のステータスで未配送のものは破棄する
+// key は同じステータスで複数回通知するイベントを区別する
+func (c *Client) EnqueueDeliveries(spaceID string, status SpaceNotificationStatus, key string, sinks []string, space, user []byte) error {
+	now := time.Now()
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketOutbox))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketOutbox)
+		}
+
+		cursor := b.Cursor()
+		prefix := deliveryPrefix(spaceID)
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var d Delivery
+			if err := proto.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if !supersedeDelivery(&d, status) {
+				continue
+			}
+			if err := putDelivery(b, &d); err != nil {
+				return err
+			}
+		}
+
+		for _, sink := range sinks {
+			if b.Get(deliveryKey(spaceID, status, key, sink)) != nil {
+				continue
+			}
+			if err := putDelivery(b, newDelivery(spaceID, status, key, sink, space, user, now)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetDueDeliveries は配送時刻を過ぎた未配送の一覧を返す
+func (c *Client) GetDueDeliveries(now time.Time) ([]*Delivery, error) {
+	var deliveries []*Delivery
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketOutbox))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketOutbox)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var d Delivery
+			if err := proto.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if isDue(&d, now) {
+				deliveries = append(deliveries, &d)
+			}
+			return nil
+		})
+	})
+	return deliveries, err
+}
+
+func (c *Client) GetDeliveries(state DeliveryState) ([]*Delivery, error) {
+	var deliveries []*Delivery
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketOutbox))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketOutbox)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var d Delivery
+			if err := proto.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if d.State == state {
+				deliveries = append(deliveries, &d)
+			}
+			return nil
+		})
+	})
+	return deliveries, err
+}
+
+func (c *Client) MarkDelivered(d *Delivery) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERED, markDelivered)
+}
+
+// MarkRetry は配送の失敗を記録し、次の配送時刻を設定する
+func (c *Client) MarkRetry(d *Delivery, cause error, nextAttempt time.Time) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_FAILED, markRetry(cause, nextAttempt))
+}
+
+// MarkDead は配送を諦めてデッドレターにする
+func (c *Client) MarkDead(d *Delivery, cause error) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DEAD, markDead(cause))
+}
+
+// MarkDeferred は失敗として数えずに次の配送時刻を設定する
+func (c *Client) MarkDeferred(d *Delivery, nextAttempt time.Time) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DEFERRED, markDeferred(nextAttempt))
+}
+
+// MarkDropped は配送せずに破棄する
+func (c *Client) MarkDropped(d *Delivery, reason string) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DROPPED, markDropped(reason))
+}
+
+// updateDelivery は配送の状態を更新し、同じトランザクションで履歴に記録する
+func (c *Client) updateDelivery(d *Delivery, eventType EventType, f func(d *Delivery)) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketOutbox))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketOutbox)
+		}
+
+		data := b.Get(deliveryKey(d.SpaceId, d.NotificationStatus, d.Key, d.Sink))
+		if data == nil {
+			return errors.New("delivery not found: " + d.SpaceId + "/" + d.Sink)
+		}
+
+		var current Delivery
+		if err := proto.Unmarshal(data, &current); err != nil {
+			return err
+		}
+		f(&current)
+
+		if err := putDelivery(b, &current); err != nil {
+			return err
+		}
+
+		if err := appendEvent(tx, deliveryEvent(&current, eventType)); err != nil {
+			return err
+		}
+
+		proto.Reset(d)
+		proto.Merge(d, &current)
+		return nil
+	})
+}
+
+func putDelivery(b *bolt.Bucket, d *Delivery) error {
+	data, err := proto.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return b.Put(deliveryKey(d.SpaceId, d.NotificationStatus, d.Key, d.Sink), data)
+}
+
+func newDelivery(spaceID string, status SpaceNotificationStatus, key, sink string, space, user []byte, now time.Time) *Delivery {
+	return &Delivery{
+		SpaceId:            spaceID,
+		NotificationStatus: status,
+		Key:                key,
+		Sink:               sink,
+		State:              DeliveryState_DELIVERY_PENDING,
+		NextAttemptAt:      timestamppb.New(now),
+		Space:              space,
+		User:               user,
+		CreatedAt:          timestamppb.New(now),
+	}
+}
+
+// supersedeDelivery は status より前のステータスで未配送の配送を破棄し、破棄したかを返す
+// 節目や参加の通知は開始・終了の順序と独立しているため、破棄も破棄させもしない
+func supersedeDelivery(d *Delivery, status SpaceNotificationStatus) bool {
+	if d.State != DeliveryState_DELIVERY_PENDING || d.NotificationStatus >= status || status > SpaceNotificationStatus_END {
+		return false
+	}
+	d.State = DeliveryState_DELIVERY_SUPERSEDED
+	return true
+}
+
+func isDue(d *Delivery, now time.Time) bool {
+	return d.State == DeliveryState_DELIVERY_PENDING && !d.NextAttemptAt.AsTime().After(now)
+}
+
+func markDelivered(d *Delivery) {
+	d.State = DeliveryState_DELIVERY_DELIVERED
+	d.Attempts++
+	d.LastError = ""
+	d.DeliveredAt = timestamppb.Now()
+}
+
+func markRetry(cause error, nextAttempt time.Time) func(d *Delivery) {
+	return func(d *Delivery) {
+		d.Attempts++
+		d.LastError = cause.Error()
+		d.NextAttemptAt = timestamppb.New(nextAttempt)
+	}
+}
+
+func markDead(cause error) func(d *Delivery) {
+	return func(d *Delivery) {
+		d.State = DeliveryState_DELIVERY_DEAD
+		d.Attempts++
+		d.LastError = cause.Error()
+	}
+}
+
+func markDeferred(nextAttempt time.Time) func(d *Delivery) {
+	return func(d *Delivery) {
+		d.NextAttemptAt = timestamppb.New(nextAttempt)
+	}
+}
+
+func markDropped(reason string) func(d *Delivery) {
+	return func(d *Delivery) {
+		d.State = DeliveryState_DELIVERY_DROPPED
+		d.LastError = reason
+	}
+}
+
+func deliveryEvent(d *Delivery, eventType EventType) *Event {
+	return &Event{
+		SpaceId:            d.SpaceId,
+		Type:               eventType,
+		NotificationStatus: d.NotificationStatus,
+		Sink:               d.Sink,
+		Error:              d.LastError,
+	}
+}
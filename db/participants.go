@@ -0,0 +1,122 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Peak は最大の参加者数を返す
+func (p *Participants) Peak() int64 {
+	var peak int64
+	for _, c := range p.GetCounts() {
+		if c > peak {
+			peak = c
+		}
+	}
+	return peak
+}
+
+// Average は取得した参加者数の平均を四捨五入して返す
+func (p *Participants) Average() int64 {
+	counts := p.GetCounts()
+	if len(counts) == 0 {
+		return 0
+	}
+	var sum int64
+	for _, c := range counts {
+		sum += c
+	}
+	n := int64(len(counts))
+	return (sum + n/2) / n
+}
+
+// addParticipantCount は参加者数を時系列の末尾に追加する
+func addParticipantCount(record *Space, t time.Time, count int64) {
+	p := record.Participants
+	if p == nil {
+		p = &Participants{
+			StartedAt: timestamppb.New(t),
+		}
+		record.Participants = p
+	}
+
+	offset := int64(t.Sub(p.StartedAt.AsTime()) / time.Second)
+	if n := len(p.Offsets); n > 0 && p.Offsets[n-1] >= offset {
+		return
+	}
+	p.Offsets = append(p.Offsets, offset)
+	p.Counts = append(p.Counts, count)
+}
+
+// claimMilestone は参加者数の節目を通知済みとして記録し、未通知だったかを返す
+func claimMilestone(record *Space, threshold int64) bool {
+	for _, m := range record.Milestones {
+		if m == threshold {
+			return false
+		}
+	}
+	record.Milestones = append(record.Milestones, threshold)
+	return true
+}
+
+func mergeMilestones(a, b []int64) []int64 {
+	milestones := append([]int64{}, a...)
+	for _, m := range b {
+		found := false
+		for _, n := range milestones {
+			if m == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			milestones = append(milestones, m)
+		}
+	}
+	return milestones
+}
+
+// claimJoined は参加したユーザーを通知済みとして記録し、未通知だったかを返す
+func claimJoined(record *Space, userID string) bool {
+	for _, id := range record.JoinedUserIds {
+		if id == userID {
+			return false
+		}
+	}
+	record.JoinedUserIds = append(record.JoinedUserIds, userID)
+	return true
+}
+
+func mergeJoined(a, b []string) []string {
+	joined := append([]string{}, a...)
+	for _, id := range b {
+		found := false
+		for _, j := range joined {
+			if id == j {
+				found = true
+				break
+			}
+		}
+		if !found {
+			joined = append(joined, id)
+		}
+	}
+	return joined
+}
@@ -28,6 +28,13 @@ const (
 	SpaceNotificationStatus_SCHEDULE        SpaceNotificationStatus = 1
 	SpaceNotificationStatus_SCHEDULE_REMIND SpaceNotificationStatus = 2
 	SpaceNotificationStatus_START           SpaceNotificationStatus = 3
+	SpaceNotificationStatus_END             SpaceNotificationStatus = 4
+	// 参加者数の節目の通知は開始・終了の順序とは独立している
+	SpaceNotificationStatus_MILESTONE SpaceNotificationStatus = 5
+	// 監視対象のユーザーが他のユーザーのスペースにホスト・スピーカーとして参加した通知
+	SpaceNotificationStatus_JOINED SpaceNotificationStatus = 6
+	// 定期レポートの配送 (スペースの記録を持たない)
+	SpaceNotificationStatus_REPORT SpaceNotificationStatus = 7
 )
 
 // Enum value maps for SpaceNotificationStatus.
@@ -37,12 +44,20 @@ var (
 		1: "SCHEDULE",
 		2: "SCHEDULE_REMIND",
 		3: "START",
+		4: "END",
+		5: "MILESTONE",
+		6: "JOINED",
+		7: "REPORT",
 	}
 	SpaceNotificationStatus_value = map[string]int32{
 		"NONE":            0,
 		"SCHEDULE":        1,
 		"SCHEDULE_REMIND": 2,
 		"START":           3,
+		"END":             4,
+		"MILESTONE":       5,
+		"JOINED":          6,
+		"REPORT":          7,
 	}
 )
 
@@ -73,38 +88,688 @@ func (SpaceNotificationStatus) EnumDescriptor() ([]byte, []int) {
 	return file_db_record_proto_rawDescGZIP(), []int{0}
 }
 
+type ClaimState int32
+
+const (
+	ClaimState_CLAIM_NONE      ClaimState = 0
+	ClaimState_CLAIM_PENDING   ClaimState = 1
+	ClaimState_CLAIM_COMMITTED ClaimState = 2
+	ClaimState_CLAIM_FAILED    ClaimState = 3
+)
+
+// Enum value maps for ClaimState.
+var (
+	ClaimState_name = map[int32]string{
+		0: "CLAIM_NONE",
+		1: "CLAIM_PENDING",
+		2: "CLAIM_COMMITTED",
+		3: "CLAIM_FAILED",
+	}
+	ClaimState_value = map[string]int32{
+		"CLAIM_NONE":      0,
+		"CLAIM_PENDING":   1,
+		"CLAIM_COMMITTED": 2,
+		"CLAIM_FAILED":    3,
+	}
+)
+
+func (x ClaimState) Enum() *ClaimState {
+	p := new(ClaimState)
+	*p = x
+	return p
+}
+
+func (x ClaimState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ClaimState) Descriptor() protoreflect.EnumDescriptor {
+	return file_db_record_proto_enumTypes[1].Descriptor()
+}
+
+func (ClaimState) Type() protoreflect.EnumType {
+	return &file_db_record_proto_enumTypes[1]
+}
+
+func (x ClaimState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ClaimState.Descriptor instead.
+func (ClaimState) EnumDescriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{1}
+}
+
+type DeliveryState int32
+
+const (
+	DeliveryState_DELIVERY_PENDING    DeliveryState = 0
+	DeliveryState_DELIVERY_DELIVERED  DeliveryState = 1
+	DeliveryState_DELIVERY_DEAD       DeliveryState = 2
+	DeliveryState_DELIVERY_SUPERSEDED DeliveryState = 3
+	DeliveryState_DELIVERY_DROPPED    DeliveryState = 4
+)
+
+// Enum value maps for DeliveryState.
+var (
+	DeliveryState_name = map[int32]string{
+		0: "DELIVERY_PENDING",
+		1: "DELIVERY_DELIVERED",
+		2: "DELIVERY_DEAD",
+		3: "DELIVERY_SUPERSEDED",
+		4: "DELIVERY_DROPPED",
+	}
+	DeliveryState_value = map[string]int32{
+		"DELIVERY_PENDING":    0,
+		"DELIVERY_DELIVERED":  1,
+		"DELIVERY_DEAD":       2,
+		"DELIVERY_SUPERSEDED": 3,
+		"DELIVERY_DROPPED":    4,
+	}
+)
+
+func (x DeliveryState) Enum() *DeliveryState {
+	p := new(DeliveryState)
+	*p = x
+	return p
+}
+
+func (x DeliveryState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (DeliveryState) Descriptor() protoreflect.EnumDescriptor {
+	return file_db_record_proto_enumTypes[2].Descriptor()
+}
+
+func (DeliveryState) Type() protoreflect.EnumType {
+	return &file_db_record_proto_enumTypes[2]
+}
+
+func (x DeliveryState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use DeliveryState.Descriptor instead.
+func (DeliveryState) EnumDescriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{2}
+}
+
+type EventType int32
+
+const (
+	EventType_EVENT_OBSERVED          EventType = 0
+	EventType_EVENT_NOTIFIED          EventType = 1
+	EventType_EVENT_DELIVERED         EventType = 2
+	EventType_EVENT_DELIVERY_FAILED   EventType = 3
+	EventType_EVENT_DELIVERY_DEAD     EventType = 4
+	EventType_EVENT_DELIVERY_DEFERRED EventType = 5
+	EventType_EVENT_DELIVERY_DROPPED  EventType = 6
+)
+
+// Enum value maps for EventType.
+var (
+	EventType_name = map[int32]string{
+		0: "EVENT_OBSERVED",
+		1: "EVENT_NOTIFIED",
+		2: "EVENT_DELIVERED",
+		3: "EVENT_DELIVERY_FAILED",
+		4: "EVENT_DELIVERY_DEAD",
+		5: "EVENT_DELIVERY_DEFERRED",
+		6: "EVENT_DELIVERY_DROPPED",
+	}
+	EventType_value = map[string]int32{
+		"EVENT_OBSERVED":          0,
+		"EVENT_NOTIFIED":          1,
+		"EVENT_DELIVERED":         2,
+		"EVENT_DELIVERY_FAILED":   3,
+		"EVENT_DELIVERY_DEAD":     4,
+		"EVENT_DELIVERY_DEFERRED": 5,
+		"EVENT_DELIVERY_DROPPED":  6,
+	}
+)
+
+func (x EventType) Enum() *EventType {
+	p := new(EventType)
+	*p = x
+	return p
+}
+
+func (x EventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_db_record_proto_enumTypes[3].Descriptor()
+}
+
+func (EventType) Type() protoreflect.EnumType {
+	return &file_db_record_proto_enumTypes[3]
+}
+
+func (x EventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EventType.Descriptor instead.
+func (EventType) EnumDescriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{3}
+}
+
 type Space struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id                 string                  `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Id                 string                  `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	CreatorId          string                  `protobuf:"bytes,2,opt,name=creator_id,json=creatorId,proto3" json:"creator_id,omitempty"`
+	ScreenName         string                  `protobuf:"bytes,3,opt,name=screen_name,json=screenName,proto3" json:"screen_name,omitempty"`
+	Title              string                  `protobuf:"bytes,4,opt,name=title,proto3" json:"title,omitempty"`
+	NotificationStatus SpaceNotificationStatus `protobuf:"varint,6,opt,name=notification_status,json=notificationStatus,proto3,enum=db.SpaceNotificationStatus" json:"notification_status,omitempty"`
+	ScheduledStart     *timestamppb.Timestamp  `protobuf:"bytes,7,opt,name=scheduled_start,json=scheduledStart,proto3" json:"scheduled_start,omitempty"`
+	StartedAt          *timestamppb.Timestamp  `protobuf:"bytes,8,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	CreatedAt          *timestamppb.Timestamp  `protobuf:"bytes,9,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Tweets             []*Tweet                `protobuf:"bytes,10,rep,name=tweets,proto3" json:"tweets,omitempty"`
+	State              string                  `protobuf:"bytes,11,opt,name=state,proto3" json:"state,omitempty"`
+	Claim              *Claim                  `protobuf:"bytes,12,opt,name=claim,proto3" json:"claim,omitempty"`
+	ClosedAt           *timestamppb.Timestamp  `protobuf:"bytes,13,opt,name=closed_at,json=closedAt,proto3" json:"closed_at,omitempty"`
+	Participants       *Participants           `protobuf:"bytes,14,opt,name=participants,proto3" json:"participants,omitempty"`
+	Milestones         []int64                 `protobuf:"varint,15,rep,packed,name=milestones,proto3" json:"milestones,omitempty"`
+	JoinedUserIds      []string                `protobuf:"bytes,16,rep,name=joined_user_ids,json=joinedUserIds,proto3" json:"joined_user_ids,omitempty"`
+}
+
+func (x *Space) Reset() {
+	*x = Space{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_db_record_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Space) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Space) ProtoMessage() {}
+
+func (x *Space) ProtoReflect() protoreflect.Message {
+	mi := &file_db_record_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Space.ProtoReflect.Descriptor instead.
+func (*Space) Descriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Space) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Space) GetCreatorId() string {
+	if x != nil {
+		return x.CreatorId
+	}
+	return ""
+}
+
+func (x *Space) GetScreenName() string {
+	if x != nil {
+		return x.ScreenName
+	}
+	return ""
+}
+
+func (x *Space) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Space) GetNotificationStatus() SpaceNotificationStatus {
+	if x != nil {
+		return x.NotificationStatus
+	}
+	return SpaceNotificationStatus_NONE
+}
+
+func (x *Space) GetScheduledStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ScheduledStart
+	}
+	return nil
+}
+
+func (x *Space) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *Space) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Space) GetTweets() []*Tweet {
+	if x != nil {
+		return x.Tweets
+	}
+	return nil
+}
+
+func (x *Space) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Space) GetClaim() *Claim {
+	if x != nil {
+		return x.Claim
+	}
+	return nil
+}
+
+func (x *Space) GetClosedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ClosedAt
+	}
+	return nil
+}
+
+func (x *Space) GetParticipants() *Participants {
+	if x != nil {
+		return x.Participants
+	}
+	return nil
+}
+
+func (x *Space) GetMilestones() []int64 {
+	if x != nil {
+		return x.Milestones
+	}
+	return nil
+}
+
+func (x *Space) GetJoinedUserIds() []string {
+	if x != nil {
+		return x.JoinedUserIds
+	}
+	return nil
+}
+
+// Participants は配信中に取得した参加者数の時系列
+// offsets は started_at からの経過秒数で、counts と同じ順に並ぶ
+type Participants struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	StartedAt *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	Offsets   []int64                `protobuf:"varint,2,rep,packed,name=offsets,proto3" json:"offsets,omitempty"`
+	Counts    []int64                `protobuf:"varint,3,rep,packed,name=counts,proto3" json:"counts,omitempty"`
+}
+
+func (x *Participants) Reset() {
+	*x = Participants{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_db_record_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Participants) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Participants) ProtoMessage() {}
+
+func (x *Participants) ProtoReflect() protoreflect.Message {
+	mi := &file_db_record_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Participants.ProtoReflect.Descriptor instead.
+func (*Participants) Descriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Participants) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *Participants) GetOffsets() []int64 {
+	if x != nil {
+		return x.Offsets
+	}
+	return nil
+}
+
+func (x *Participants) GetCounts() []int64 {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+type Claim struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	NotificationStatus SpaceNotificationStatus `protobuf:"varint,1,opt,name=notification_status,json=notificationStatus,proto3,enum=db.SpaceNotificationStatus" json:"notification_status,omitempty"`
+	State              ClaimState              `protobuf:"varint,2,opt,name=state,proto3,enum=db.ClaimState" json:"state,omitempty"`
+	ClaimedAt          *timestamppb.Timestamp  `protobuf:"bytes,3,opt,name=claimed_at,json=claimedAt,proto3" json:"claimed_at,omitempty"`
+}
+
+func (x *Claim) Reset() {
+	*x = Claim{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_db_record_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Claim) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Claim) ProtoMessage() {}
+
+func (x *Claim) ProtoReflect() protoreflect.Message {
+	mi := &file_db_record_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Claim.ProtoReflect.Descriptor instead.
+func (*Claim) Descriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Claim) GetNotificationStatus() SpaceNotificationStatus {
+	if x != nil {
+		return x.NotificationStatus
+	}
+	return SpaceNotificationStatus_NONE
+}
+
+func (x *Claim) GetState() ClaimState {
+	if x != nil {
+		return x.State
+	}
+	return ClaimState_CLAIM_NONE
+}
+
+func (x *Claim) GetClaimedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ClaimedAt
+	}
+	return nil
+}
+
+type Tweet struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id                 int64                   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	NotificationStatus SpaceNotificationStatus `protobuf:"varint,2,opt,name=notification_status,json=notificationStatus,proto3,enum=db.SpaceNotificationStatus" json:"notification_status,omitempty"`
+}
+
+func (x *Tweet) Reset() {
+	*x = Tweet{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_db_record_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Tweet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tweet) ProtoMessage() {}
+
+func (x *Tweet) ProtoReflect() protoreflect.Message {
+	mi := &file_db_record_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tweet.ProtoReflect.Descriptor instead.
+func (*Tweet) Descriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Tweet) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Tweet) GetNotificationStatus() SpaceNotificationStatus {
+	if x != nil {
+		return x.NotificationStatus
+	}
+	return SpaceNotificationStatus_NONE
+}
+
+type Delivery struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SpaceId            string                  `protobuf:"bytes,1,opt,name=space_id,json=spaceId,proto3" json:"space_id,omitempty"`
+	NotificationStatus SpaceNotificationStatus `protobuf:"varint,2,opt,name=notification_status,json=notificationStatus,proto3,enum=db.SpaceNotificationStatus" json:"notification_status,omitempty"`
+	Sink               string                  `protobuf:"bytes,3,opt,name=sink,proto3" json:"sink,omitempty"`
+	State              DeliveryState           `protobuf:"varint,4,opt,name=state,proto3,enum=db.DeliveryState" json:"state,omitempty"`
+	Attempts           int32                   `protobuf:"varint,5,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	NextAttemptAt      *timestamppb.Timestamp  `protobuf:"bytes,6,opt,name=next_attempt_at,json=nextAttemptAt,proto3" json:"next_attempt_at,omitempty"`
+	LastError          string                  `protobuf:"bytes,7,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	Space              []byte                  `protobuf:"bytes,8,opt,name=space,proto3" json:"space,omitempty"`
+	User               []byte                  `protobuf:"bytes,9,opt,name=user,proto3" json:"user,omitempty"`
+	CreatedAt          *timestamppb.Timestamp  `protobuf:"bytes,10,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	DeliveredAt        *timestamppb.Timestamp  `protobuf:"bytes,11,opt,name=delivered_at,json=deliveredAt,proto3" json:"delivered_at,omitempty"`
+	// key は同じスペース・ステータスで複数回通知するイベントを区別する (節目の参加者数、参加したユーザー ID)
+	Key string `protobuf:"bytes,12,opt,name=key,proto3" json:"key,omitempty"`
+}
+
+func (x *Delivery) Reset() {
+	*x = Delivery{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_db_record_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Delivery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Delivery) ProtoMessage() {}
+
+func (x *Delivery) ProtoReflect() protoreflect.Message {
+	mi := &file_db_record_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Delivery.ProtoReflect.Descriptor instead.
+func (*Delivery) Descriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Delivery) GetSpaceId() string {
+	if x != nil {
+		return x.SpaceId
+	}
+	return ""
+}
+
+func (x *Delivery) GetNotificationStatus() SpaceNotificationStatus {
+	if x != nil {
+		return x.NotificationStatus
+	}
+	return SpaceNotificationStatus_NONE
+}
+
+func (x *Delivery) GetSink() string {
+	if x != nil {
+		return x.Sink
+	}
+	return ""
+}
+
+func (x *Delivery) GetState() DeliveryState {
+	if x != nil {
+		return x.State
+	}
+	return DeliveryState_DELIVERY_PENDING
+}
+
+func (x *Delivery) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *Delivery) GetNextAttemptAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextAttemptAt
+	}
+	return nil
+}
+
+func (x *Delivery) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+func (x *Delivery) GetSpace() []byte {
+	if x != nil {
+		return x.Space
+	}
+	return nil
+}
+
+func (x *Delivery) GetUser() []byte {
+	if x != nil {
+		return x.User
+	}
+	return nil
+}
+
+func (x *Delivery) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Delivery) GetDeliveredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DeliveredAt
+	}
+	return nil
+}
+
+func (x *Delivery) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type Event struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SpaceId            string                  `protobuf:"bytes,1,opt,name=space_id,json=spaceId,proto3" json:"space_id,omitempty"`
 	CreatorId          string                  `protobuf:"bytes,2,opt,name=creator_id,json=creatorId,proto3" json:"creator_id,omitempty"`
 	ScreenName         string                  `protobuf:"bytes,3,opt,name=screen_name,json=screenName,proto3" json:"screen_name,omitempty"`
-	Title              string                  `protobuf:"bytes,4,opt,name=title,proto3" json:"title,omitempty"`
-	NotificationStatus SpaceNotificationStatus `protobuf:"varint,6,opt,name=notification_status,json=notificationStatus,proto3,enum=db.SpaceNotificationStatus" json:"notification_status,omitempty"`
-	ScheduledStart     *timestamppb.Timestamp  `protobuf:"bytes,7,opt,name=scheduled_start,json=scheduledStart,proto3" json:"scheduled_start,omitempty"`
-	StartedAt          *timestamppb.Timestamp  `protobuf:"bytes,8,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
-	CreatedAt          *timestamppb.Timestamp  `protobuf:"bytes,9,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Type               EventType               `protobuf:"varint,4,opt,name=type,proto3,enum=db.EventType" json:"type,omitempty"`
+	Timestamp          *timestamppb.Timestamp  `protobuf:"bytes,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	State              string                  `protobuf:"bytes,6,opt,name=state,proto3" json:"state,omitempty"`
+	Title              string                  `protobuf:"bytes,7,opt,name=title,proto3" json:"title,omitempty"`
+	NotificationStatus SpaceNotificationStatus `protobuf:"varint,8,opt,name=notification_status,json=notificationStatus,proto3,enum=db.SpaceNotificationStatus" json:"notification_status,omitempty"`
+	Sink               string                  `protobuf:"bytes,9,opt,name=sink,proto3" json:"sink,omitempty"`
+	ScheduledStart     *timestamppb.Timestamp  `protobuf:"bytes,10,opt,name=scheduled_start,json=scheduledStart,proto3" json:"scheduled_start,omitempty"`
+	StartedAt          *timestamppb.Timestamp  `protobuf:"bytes,11,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	Error              string                  `protobuf:"bytes,12,opt,name=error,proto3" json:"error,omitempty"`
 }
 
-func (x *Space) Reset() {
-	*x = Space{}
+func (x *Event) Reset() {
+	*x = Event{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_db_record_proto_msgTypes[0]
+		mi := &file_db_record_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *Space) String() string {
+func (x *Event) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Space) ProtoMessage() {}
+func (*Event) ProtoMessage() {}
 
-func (x *Space) ProtoReflect() protoreflect.Message {
-	mi := &file_db_record_proto_msgTypes[0]
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_db_record_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -115,63 +780,147 @@ func (x *Space) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Space.ProtoReflect.Descriptor instead.
-func (*Space) Descriptor() ([]byte, []int) {
-	return file_db_record_proto_rawDescGZIP(), []int{0}
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *Space) GetId() string {
+func (x *Event) GetSpaceId() string {
 	if x != nil {
-		return x.Id
+		return x.SpaceId
 	}
 	return ""
 }
 
-func (x *Space) GetCreatorId() string {
+func (x *Event) GetCreatorId() string {
 	if x != nil {
 		return x.CreatorId
 	}
 	return ""
 }
 
-func (x *Space) GetScreenName() string {
+func (x *Event) GetScreenName() string {
 	if x != nil {
 		return x.ScreenName
 	}
 	return ""
 }
 
-func (x *Space) GetTitle() string {
+func (x *Event) GetType() EventType {
+	if x != nil {
+		return x.Type
+	}
+	return EventType_EVENT_OBSERVED
+}
+
+func (x *Event) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Event) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Event) GetTitle() string {
 	if x != nil {
 		return x.Title
 	}
 	return ""
 }
 
-func (x *Space) GetNotificationStatus() SpaceNotificationStatus {
+func (x *Event) GetNotificationStatus() SpaceNotificationStatus {
 	if x != nil {
 		return x.NotificationStatus
 	}
 	return SpaceNotificationStatus_NONE
 }
 
-func (x *Space) GetScheduledStart() *timestamppb.Timestamp {
+func (x *Event) GetSink() string {
+	if x != nil {
+		return x.Sink
+	}
+	return ""
+}
+
+func (x *Event) GetScheduledStart() *timestamppb.Timestamp {
 	if x != nil {
 		return x.ScheduledStart
 	}
 	return nil
 }
 
-func (x *Space) GetStartedAt() *timestamppb.Timestamp {
+func (x *Event) GetStartedAt() *timestamppb.Timestamp {
 	if x != nil {
 		return x.StartedAt
 	}
 	return nil
 }
 
-func (x *Space) GetCreatedAt() *timestamppb.Timestamp {
+func (x *Event) GetError() string {
 	if x != nil {
-		return x.CreatedAt
+		return x.Error
+	}
+	return ""
+}
+
+// WatchedSpace は作成者をフォローしていないが手動で監視するスペース
+type WatchedSpace struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SpaceId string                 `protobuf:"bytes,1,opt,name=space_id,json=spaceId,proto3" json:"space_id,omitempty"`
+	AddedAt *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=added_at,json=addedAt,proto3" json:"added_at,omitempty"`
+}
+
+func (x *WatchedSpace) Reset() {
+	*x = WatchedSpace{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_db_record_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchedSpace) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchedSpace) ProtoMessage() {}
+
+func (x *WatchedSpace) ProtoReflect() protoreflect.Message {
+	mi := &file_db_record_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchedSpace.ProtoReflect.Descriptor instead.
+func (*WatchedSpace) Descriptor() ([]byte, []int) {
+	return file_db_record_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *WatchedSpace) GetSpaceId() string {
+	if x != nil {
+		return x.SpaceId
+	}
+	return ""
+}
+
+func (x *WatchedSpace) GetAddedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AddedAt
 	}
 	return nil
 }
@@ -182,7 +931,7 @@ var file_db_record_proto_rawDesc = []byte{
 	0x0a, 0x0f, 0x64, 0x62, 0x2f, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74,
 	0x6f, 0x12, 0x02, 0x64, 0x62, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72,
 	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xf6, 0x02, 0x0a, 0x05, 0x53, 0x70, 0x61, 0x63, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x87, 0x05, 0x0a, 0x05, 0x53, 0x70, 0x61, 0x63, 0x65,
 	0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64,
 	0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x61, 0x74, 0x6f, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x02,
 	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x6f, 0x72, 0x49, 0x64, 0x12,
@@ -205,13 +954,151 @@ var file_db_record_proto_rawDesc = []byte{
 	0x65, 0x64, 0x41, 0x74, 0x12, 0x39, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f,
 	0x61, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
 	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
-	0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x2a,
-	0x51, 0x0a, 0x17, 0x53, 0x70, 0x61, 0x63, 0x65, 0x4e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61,
-	0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x4f,
-	0x4e, 0x45, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x53, 0x43, 0x48, 0x45, 0x44, 0x55, 0x4c, 0x45,
-	0x10, 0x01, 0x12, 0x13, 0x0a, 0x0f, 0x53, 0x43, 0x48, 0x45, 0x44, 0x55, 0x4c, 0x45, 0x5f, 0x52,
-	0x45, 0x4d, 0x49, 0x4e, 0x44, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x53, 0x54, 0x41, 0x52, 0x54,
-	0x10, 0x03, 0x42, 0x23, 0x5a, 0x21, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x12,
+	0x21, 0x0a, 0x06, 0x74, 0x77, 0x65, 0x65, 0x74, 0x73, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x09, 0x2e, 0x64, 0x62, 0x2e, 0x54, 0x77, 0x65, 0x65, 0x74, 0x52, 0x06, 0x74, 0x77, 0x65, 0x65,
+	0x74, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x1f, 0x0a, 0x05, 0x63, 0x6c, 0x61, 0x69,
+	0x6d, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x64, 0x62, 0x2e, 0x43, 0x6c, 0x61,
+	0x69, 0x6d, 0x52, 0x05, 0x63, 0x6c, 0x61, 0x69, 0x6d, 0x12, 0x37, 0x0a, 0x09, 0x63, 0x6c, 0x6f,
+	0x73, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x08, 0x63, 0x6c, 0x6f, 0x73, 0x65, 0x64,
+	0x41, 0x74, 0x12, 0x34, 0x0a, 0x0c, 0x70, 0x61, 0x72, 0x74, 0x69, 0x63, 0x69, 0x70, 0x61, 0x6e,
+	0x74, 0x73, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x64, 0x62, 0x2e, 0x50, 0x61,
+	0x72, 0x74, 0x69, 0x63, 0x69, 0x70, 0x61, 0x6e, 0x74, 0x73, 0x52, 0x0c, 0x70, 0x61, 0x72, 0x74,
+	0x69, 0x63, 0x69, 0x70, 0x61, 0x6e, 0x74, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x6d, 0x69, 0x6c, 0x65,
+	0x73, 0x74, 0x6f, 0x6e, 0x65, 0x73, 0x18, 0x0f, 0x20, 0x03, 0x28, 0x03, 0x52, 0x0a, 0x6d, 0x69,
+	0x6c, 0x65, 0x73, 0x74, 0x6f, 0x6e, 0x65, 0x73, 0x12, 0x26, 0x0a, 0x0f, 0x6a, 0x6f, 0x69, 0x6e,
+	0x65, 0x64, 0x5f, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x73, 0x18, 0x10, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x0d, 0x6a, 0x6f, 0x69, 0x6e, 0x65, 0x64, 0x55, 0x73, 0x65, 0x72, 0x49, 0x64, 0x73,
+	0x22, 0x7b, 0x0a, 0x0c, 0x50, 0x61, 0x72, 0x74, 0x69, 0x63, 0x69, 0x70, 0x61, 0x6e, 0x74, 0x73,
+	0x12, 0x39, 0x0a, 0x0a, 0x73, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x09, 0x73, 0x74, 0x61, 0x72, 0x74, 0x65, 0x64, 0x41, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x6f,
+	0x66, 0x66, 0x73, 0x65, 0x74, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x03, 0x52, 0x07, 0x6f, 0x66,
+	0x66, 0x73, 0x65, 0x74, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73, 0x18,
+	0x03, 0x20, 0x03, 0x28, 0x03, 0x52, 0x06, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x73, 0x22, 0xb6, 0x01,
+	0x0a, 0x05, 0x43, 0x6c, 0x61, 0x69, 0x6d, 0x12, 0x4c, 0x0a, 0x13, 0x6e, 0x6f, 0x74, 0x69, 0x66,
+	0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x1b, 0x2e, 0x64, 0x62, 0x2e, 0x53, 0x70, 0x61, 0x63, 0x65, 0x4e,
+	0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x52, 0x12, 0x6e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x24, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x0e, 0x2e, 0x64, 0x62, 0x2e, 0x43, 0x6c, 0x61, 0x69, 0x6d, 0x53,
+	0x74, 0x61, 0x74, 0x65, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x39, 0x0a, 0x0a, 0x63,
+	0x6c, 0x61, 0x69, 0x6d, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x63, 0x6c, 0x61,
+	0x69, 0x6d, 0x65, 0x64, 0x41, 0x74, 0x22, 0x65, 0x0a, 0x05, 0x54, 0x77, 0x65, 0x65, 0x74, 0x12,
+	0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x69, 0x64, 0x12,
+	0x4c, 0x0a, 0x13, 0x6e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f,
+	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1b, 0x2e, 0x64,
+	0x62, 0x2e, 0x53, 0x70, 0x61, 0x63, 0x65, 0x4e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x12, 0x6e, 0x6f, 0x74, 0x69, 0x66,
+	0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0xe5, 0x03,
+	0x0a, 0x08, 0x44, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x79, 0x12, 0x19, 0x0a, 0x08, 0x73, 0x70,
+	0x61, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x73, 0x70,
+	0x61, 0x63, 0x65, 0x49, 0x64, 0x12, 0x4c, 0x0a, 0x13, 0x6e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x1b, 0x2e, 0x64, 0x62, 0x2e, 0x53, 0x70, 0x61, 0x63, 0x65, 0x4e, 0x6f, 0x74,
+	0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52,
+	0x12, 0x6e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x6e, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x73, 0x69, 0x6e, 0x6b, 0x12, 0x27, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x11, 0x2e, 0x64, 0x62, 0x2e, 0x44, 0x65, 0x6c, 0x69,
+	0x76, 0x65, 0x72, 0x79, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65,
+	0x12, 0x1a, 0x0a, 0x08, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x08, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x73, 0x12, 0x42, 0x0a, 0x0f,
+	0x6e, 0x65, 0x78, 0x74, 0x5f, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x5f, 0x61, 0x74, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x52, 0x0d, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x74, 0x65, 0x6d, 0x70, 0x74, 0x41, 0x74,
+	0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x07,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6c, 0x61, 0x73, 0x74, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12,
+	0x14, 0x0a, 0x05, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05,
+	0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x73, 0x65, 0x72, 0x18, 0x09, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x04, 0x75, 0x73, 0x65, 0x72, 0x12, 0x39, 0x0a, 0x0a, 0x63, 0x72, 0x65,
+	0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74,
+	0x65, 0x64, 0x41, 0x74, 0x12, 0x3d, 0x0a, 0x0c, 0x64, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65,
+	0x64, 0x5f, 0x61, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0b, 0x64, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x65,
+	0x64, 0x41, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0xe3, 0x03, 0x0a, 0x05, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12,
+	0x19, 0x0a, 0x08, 0x73, 0x70, 0x61, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x73, 0x70, 0x61, 0x63, 0x65, 0x49, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x72,
+	0x65, 0x61, 0x74, 0x6f, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x63, 0x72, 0x65, 0x61, 0x74, 0x6f, 0x72, 0x49, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x63, 0x72,
+	0x65, 0x65, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a,
+	0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x21, 0x0a, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0d, 0x2e, 0x64, 0x62, 0x2e, 0x45, 0x76,
+	0x65, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x38, 0x0a,
+	0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x14, 0x0a,
+	0x05, 0x74, 0x69, 0x74, 0x6c, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x69,
+	0x74, 0x6c, 0x65, 0x12, 0x4c, 0x0a, 0x13, 0x6e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x1b, 0x2e, 0x64, 0x62, 0x2e, 0x53, 0x70, 0x61, 0x63, 0x65, 0x4e, 0x6f, 0x74, 0x69, 0x66,
+	0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x12, 0x6e,
+	0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x6e, 0x6b, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x73, 0x69, 0x6e, 0x6b, 0x12, 0x43, 0x0a, 0x0f, 0x73, 0x63, 0x68, 0x65, 0x64, 0x75, 0x6c,
+	0x65, 0x64, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0e, 0x73, 0x63, 0x68, 0x65,
+	0x64, 0x75, 0x6c, 0x65, 0x64, 0x53, 0x74, 0x61, 0x72, 0x74, 0x12, 0x39, 0x0a, 0x0a, 0x73, 0x74,
+	0x61, 0x72, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x73, 0x74, 0x61, 0x72,
+	0x74, 0x65, 0x64, 0x41, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x0c,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x60, 0x0a, 0x0c, 0x57,
+	0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x53, 0x70, 0x61, 0x63, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x73,
+	0x70, 0x61, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x73,
+	0x70, 0x61, 0x63, 0x65, 0x49, 0x64, 0x12, 0x35, 0x0a, 0x08, 0x61, 0x64, 0x64, 0x65, 0x64, 0x5f,
+	0x61, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x07, 0x61, 0x64, 0x64, 0x65, 0x64, 0x41, 0x74, 0x2a, 0x81, 0x01,
+	0x0a, 0x17, 0x53, 0x70, 0x61, 0x63, 0x65, 0x4e, 0x6f, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x4f, 0x4e,
+	0x45, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x53, 0x43, 0x48, 0x45, 0x44, 0x55, 0x4c, 0x45, 0x10,
+	0x01, 0x12, 0x13, 0x0a, 0x0f, 0x53, 0x43, 0x48, 0x45, 0x44, 0x55, 0x4c, 0x45, 0x5f, 0x52, 0x45,
+	0x4d, 0x49, 0x4e, 0x44, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x53, 0x54, 0x41, 0x52, 0x54, 0x10,
+	0x03, 0x12, 0x07, 0x0a, 0x03, 0x45, 0x4e, 0x44, 0x10, 0x04, 0x12, 0x0d, 0x0a, 0x09, 0x4d, 0x49,
+	0x4c, 0x45, 0x53, 0x54, 0x4f, 0x4e, 0x45, 0x10, 0x05, 0x12, 0x0a, 0x0a, 0x06, 0x4a, 0x4f, 0x49,
+	0x4e, 0x45, 0x44, 0x10, 0x06, 0x12, 0x0a, 0x0a, 0x06, 0x52, 0x45, 0x50, 0x4f, 0x52, 0x54, 0x10,
+	0x07, 0x2a, 0x56, 0x0a, 0x0a, 0x43, 0x6c, 0x61, 0x69, 0x6d, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12,
+	0x0e, 0x0a, 0x0a, 0x43, 0x4c, 0x41, 0x49, 0x4d, 0x5f, 0x4e, 0x4f, 0x4e, 0x45, 0x10, 0x00, 0x12,
+	0x11, 0x0a, 0x0d, 0x43, 0x4c, 0x41, 0x49, 0x4d, 0x5f, 0x50, 0x45, 0x4e, 0x44, 0x49, 0x4e, 0x47,
+	0x10, 0x01, 0x12, 0x13, 0x0a, 0x0f, 0x43, 0x4c, 0x41, 0x49, 0x4d, 0x5f, 0x43, 0x4f, 0x4d, 0x4d,
+	0x49, 0x54, 0x54, 0x45, 0x44, 0x10, 0x02, 0x12, 0x10, 0x0a, 0x0c, 0x43, 0x4c, 0x41, 0x49, 0x4d,
+	0x5f, 0x46, 0x41, 0x49, 0x4c, 0x45, 0x44, 0x10, 0x03, 0x2a, 0x7f, 0x0a, 0x0d, 0x44, 0x65, 0x6c,
+	0x69, 0x76, 0x65, 0x72, 0x79, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x14, 0x0a, 0x10, 0x44, 0x45,
+	0x4c, 0x49, 0x56, 0x45, 0x52, 0x59, 0x5f, 0x50, 0x45, 0x4e, 0x44, 0x49, 0x4e, 0x47, 0x10, 0x00,
+	0x12, 0x16, 0x0a, 0x12, 0x44, 0x45, 0x4c, 0x49, 0x56, 0x45, 0x52, 0x59, 0x5f, 0x44, 0x45, 0x4c,
+	0x49, 0x56, 0x45, 0x52, 0x45, 0x44, 0x10, 0x01, 0x12, 0x11, 0x0a, 0x0d, 0x44, 0x45, 0x4c, 0x49,
+	0x56, 0x45, 0x52, 0x59, 0x5f, 0x44, 0x45, 0x41, 0x44, 0x10, 0x02, 0x12, 0x17, 0x0a, 0x13, 0x44,
+	0x45, 0x4c, 0x49, 0x56, 0x45, 0x52, 0x59, 0x5f, 0x53, 0x55, 0x50, 0x45, 0x52, 0x53, 0x45, 0x44,
+	0x45, 0x44, 0x10, 0x03, 0x12, 0x14, 0x0a, 0x10, 0x44, 0x45, 0x4c, 0x49, 0x56, 0x45, 0x52, 0x59,
+	0x5f, 0x44, 0x52, 0x4f, 0x50, 0x50, 0x45, 0x44, 0x10, 0x04, 0x2a, 0xb5, 0x01, 0x0a, 0x09, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x0e, 0x45, 0x56, 0x45, 0x4e,
+	0x54, 0x5f, 0x4f, 0x42, 0x53, 0x45, 0x52, 0x56, 0x45, 0x44, 0x10, 0x00, 0x12, 0x12, 0x0a, 0x0e,
+	0x45, 0x56, 0x45, 0x4e, 0x54, 0x5f, 0x4e, 0x4f, 0x54, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x01,
+	0x12, 0x13, 0x0a, 0x0f, 0x45, 0x56, 0x45, 0x4e, 0x54, 0x5f, 0x44, 0x45, 0x4c, 0x49, 0x56, 0x45,
+	0x52, 0x45, 0x44, 0x10, 0x02, 0x12, 0x19, 0x0a, 0x15, 0x45, 0x56, 0x45, 0x4e, 0x54, 0x5f, 0x44,
+	0x45, 0x4c, 0x49, 0x56, 0x45, 0x52, 0x59, 0x5f, 0x46, 0x41, 0x49, 0x4c, 0x45, 0x44, 0x10, 0x03,
+	0x12, 0x17, 0x0a, 0x13, 0x45, 0x56, 0x45, 0x4e, 0x54, 0x5f, 0x44, 0x45, 0x4c, 0x49, 0x56, 0x45,
+	0x52, 0x59, 0x5f, 0x44, 0x45, 0x41, 0x44, 0x10, 0x04, 0x12, 0x1b, 0x0a, 0x17, 0x45, 0x56, 0x45,
+	0x4e, 0x54, 0x5f, 0x44, 0x45, 0x4c, 0x49, 0x56, 0x45, 0x52, 0x59, 0x5f, 0x44, 0x45, 0x46, 0x45,
+	0x52, 0x52, 0x45, 0x44, 0x10, 0x05, 0x12, 0x1a, 0x0a, 0x16, 0x45, 0x56, 0x45, 0x4e, 0x54, 0x5f,
+	0x44, 0x45, 0x4c, 0x49, 0x56, 0x45, 0x52, 0x59, 0x5f, 0x44, 0x52, 0x4f, 0x50, 0x50, 0x45, 0x44,
+	0x10, 0x06, 0x42, 0x23, 0x5a, 0x21, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
 	0x2f, 0x71, 0x69, 0x74, 0x6f, 0x69, 0x2f, 0x73, 0x70, 0x61, 0x63, 0x65, 0x2d, 0x77, 0x61, 0x74,
 	0x63, 0x68, 0x65, 0x72, 0x2f, 0x64, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
@@ -228,23 +1115,52 @@ func file_db_record_proto_rawDescGZIP() []byte {
 	return file_db_record_proto_rawDescData
 }
 
-var file_db_record_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_db_record_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_db_record_proto_enumTypes = make([]protoimpl.EnumInfo, 4)
+var file_db_record_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_db_record_proto_goTypes = []interface{}{
 	(SpaceNotificationStatus)(0),  // 0: db.SpaceNotificationStatus
-	(*Space)(nil),                 // 1: db.Space
-	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
+	(ClaimState)(0),               // 1: db.ClaimState
+	(DeliveryState)(0),            // 2: db.DeliveryState
+	(EventType)(0),                // 3: db.EventType
+	(*Space)(nil),                 // 4: db.Space
+	(*Participants)(nil),          // 5: db.Participants
+	(*Claim)(nil),                 // 6: db.Claim
+	(*Tweet)(nil),                 // 7: db.Tweet
+	(*Delivery)(nil),              // 8: db.Delivery
+	(*Event)(nil),                 // 9: db.Event
+	(*WatchedSpace)(nil),          // 10: db.WatchedSpace
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
 }
 var file_db_record_proto_depIdxs = []int32{
-	0, // 0: db.Space.notification_status:type_name -> db.SpaceNotificationStatus
-	2, // 1: db.Space.scheduled_start:type_name -> google.protobuf.Timestamp
-	2, // 2: db.Space.started_at:type_name -> google.protobuf.Timestamp
-	2, // 3: db.Space.created_at:type_name -> google.protobuf.Timestamp
-	4, // [4:4] is the sub-list for method output_type
-	4, // [4:4] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	0,  // 0: db.Space.notification_status:type_name -> db.SpaceNotificationStatus
+	11, // 1: db.Space.scheduled_start:type_name -> google.protobuf.Timestamp
+	11, // 2: db.Space.started_at:type_name -> google.protobuf.Timestamp
+	11, // 3: db.Space.created_at:type_name -> google.protobuf.Timestamp
+	7,  // 4: db.Space.tweets:type_name -> db.Tweet
+	6,  // 5: db.Space.claim:type_name -> db.Claim
+	11, // 6: db.Space.closed_at:type_name -> google.protobuf.Timestamp
+	5,  // 7: db.Space.participants:type_name -> db.Participants
+	11, // 8: db.Participants.started_at:type_name -> google.protobuf.Timestamp
+	0,  // 9: db.Claim.notification_status:type_name -> db.SpaceNotificationStatus
+	1,  // 10: db.Claim.state:type_name -> db.ClaimState
+	11, // 11: db.Claim.claimed_at:type_name -> google.protobuf.Timestamp
+	0,  // 12: db.Tweet.notification_status:type_name -> db.SpaceNotificationStatus
+	0,  // 13: db.Delivery.notification_status:type_name -> db.SpaceNotificationStatus
+	2,  // 14: db.Delivery.state:type_name -> db.DeliveryState
+	11, // 15: db.Delivery.next_attempt_at:type_name -> google.protobuf.Timestamp
+	11, // 16: db.Delivery.created_at:type_name -> google.protobuf.Timestamp
+	11, // 17: db.Delivery.delivered_at:type_name -> google.protobuf.Timestamp
+	3,  // 18: db.Event.type:type_name -> db.EventType
+	11, // 19: db.Event.timestamp:type_name -> google.protobuf.Timestamp
+	0,  // 20: db.Event.notification_status:type_name -> db.SpaceNotificationStatus
+	11, // 21: db.Event.scheduled_start:type_name -> google.protobuf.Timestamp
+	11, // 22: db.Event.started_at:type_name -> google.protobuf.Timestamp
+	11, // 23: db.WatchedSpace.added_at:type_name -> google.protobuf.Timestamp
+	24, // [24:24] is the sub-list for method output_type
+	24, // [24:24] is the sub-list for method input_type
+	24, // [24:24] is the sub-list for extension type_name
+	24, // [24:24] is the sub-list for extension extendee
+	0,  // [0:24] is the sub-list for field type_name
 }
 
 func init() { file_db_record_proto_init() }
@@ -265,14 +1181,86 @@ func file_db_record_proto_init() {
 				return nil
 			}
 		}
+		file_db_record_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Participants); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_db_record_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Claim); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_db_record_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Tweet); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_db_record_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Delivery); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_db_record_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Event); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_db_record_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchedSpace); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_db_record_proto_rawDesc,
-			NumEnums:      1,
-			NumMessages:   1,
+			NumEnums:      4,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
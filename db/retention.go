@@ -0,0 +1,197 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	compactTxMaxSize = 64 * 1024
+)
+
+// isExpirableState は保持期間を過ぎたら削除する記録の状態か返す
+// 状態を記録する前のバージョンで作成された記録は終了を確認できないため、最終更新日時のみで判断する
+// 通知の確保だけで作成された記録は日時がないため削除しない
+func isExpirableState(state string) bool {
+	return state == "" || IsClosedState(state)
+}
+
+// DeleteClosedSpaces は before より前に終了・キャンセルされたスペースの記録と配送済みの outbox を削除する
+// 状態のない記録は最終更新日時が before より前のものを削除する
+func (c *Client) DeleteClosedSpaces(before time.Time) (int, error) {
+	deleted := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketSpace))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketSpace)
+		}
+		outbox := tx.Bucket([]byte(bucketOutbox))
+		if outbox == nil {
+			return errors.New("bucket not found: " + bucketOutbox)
+		}
+
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var s Space
+			if err := proto.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if !isExpirableState(s.State) {
+				return nil
+			}
+			if t := lastActivity(&s); t != nil && t.AsTime().Before(before) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+			if err := deleteFinishedDeliveries(outbox, string(key)); err != nil {
+				return err
+			}
+		}
+		deleted = len(keys)
+		return nil
+	})
+	return deleted, err
+}
+
+// DeleteHistory は before より前の履歴を削除する
+func (c *Client) DeleteHistory(before time.Time) (int, error) {
+	deleted := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketHistory))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketHistory)
+		}
+
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if len(k) < 16 {
+				return nil
+			}
+			// キーの末尾 16 バイトは時刻と連番
+			t := time.Unix(0, int64(binary.BigEndian.Uint64(k[len(k)-16:])))
+			if t.Before(before) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		deleted = len(keys)
+		return nil
+	})
+	return deleted, err
+}
+
+func lastActivity(s *Space) *timestamppb.Timestamp {
+	for _, t := range []*timestamppb.Timestamp{s.ClosedAt, s.StartedAt, s.ScheduledStart, s.CreatedAt} {
+		if t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+func deleteFinishedDeliveries(b *bolt.Bucket, spaceID string) error {
+	var keys [][]byte
+	cursor := b.Cursor()
+	prefix := deliveryPrefix(spaceID)
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		var d Delivery
+		if err := proto.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if d.State != DeliveryState_DELIVERY_PENDING {
+			keys = append(keys, append([]byte{}, k...))
+		}
+	}
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact はデータベースファイルを再構築して未使用の領域を解放する
+// 起動中のプロセスがある場合はロックを取得できずエラーになる
+func Compact(path string) (before, after int64, err error) {
+	tmp := path + ".compact"
+	if err := compactTo(path, tmp); err != nil {
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+
+	srcInfo, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	dstInfo, err := os.Stat(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, 0, err
+	}
+
+	return srcInfo.Size(), dstInfo.Size(), nil
+}
+
+func compactTo(path, tmp string) error {
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := bolt.Compact(dst, src, compactTxMaxSize); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
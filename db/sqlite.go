@@ -0,0 +1,655 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	// pure Go の SQLite ドライバ
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteTimeFormat  = "2006-01-02T15:04:05.000000000Z"
+	sqliteBusyTimeout = 5000
+)
+
+const spaceColumns = `id, creator_id, screen_name, title, notification_status, state,
+	scheduled_start, started_at, created_at, closed_at, claim_status, claim_state, claimed_at`
+
+// SQLiteClient は SQLite に保存する Store
+// WAL モードで開くため、起動中でも別のプロセスから SQL で参照できる
+type SQLiteClient struct {
+	db   *sql.DB
+	path string
+}
+
+type sqliteQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type sqliteScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func OpenSQLite(path string) (*SQLiteClient, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// プロセス内の書き込みは 1 つの接続で直列化する
+	db.SetMaxOpenConns(1)
+
+	pragmas := []string{
+		`PRAGMA busy_timeout = ` + strconv.Itoa(sqliteBusyTimeout),
+		`PRAGMA journal_mode = WAL`,
+	}
+	for _, pragma := range pragmas {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if err := migrateSQLite(db, path); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteClient{
+		db:   db,
+		path: path,
+	}, nil
+}
+
+func (c *SQLiteClient) Close() error {
+	return c.db.Close()
+}
+
+func (c *SQLiteClient) GetNotifiedStatus(spaceID string) (SpaceNotificationStatus, error) {
+	var status int32
+	err := c.db.QueryRow(`SELECT notification_status FROM space WHERE id = ?`, spaceID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SpaceNotificationStatus_NONE, nil
+	}
+	if err != nil {
+		return SpaceNotificationStatus_NONE, err
+	}
+	return SpaceNotificationStatus(status), nil
+}
+
+func (c *SQLiteClient) CheckNotified(spaceID string, status SpaceNotificationStatus) (bool, error) {
+	prevStatus, err := c.GetNotifiedStatus(spaceID)
+	if err != nil {
+		return false, err
+	}
+	return status <= prevStatus, nil
+}
+
+func (c *SQLiteClient) RegisterSchedule(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error {
+	return c.register(newScheduleRecord(spaceID, creatorID, screenName, title, SpaceNotificationStatus_SCHEDULE, scheduledStart, createdAt))
+}
+
+func (c *SQLiteClient) RegisterScheduleRemind(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error {
+	return c.register(newScheduleRecord(spaceID, creatorID, screenName, title, SpaceNotificationStatus_SCHEDULE_REMIND, scheduledStart, createdAt))
+}
+
+func (c *SQLiteClient) RegisterStart(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error {
+	return c.register(newStartRecord(spaceID, creatorID, screenName, title, startedAt, createdAt))
+}
+
+func (c *SQLiteClient) RegisterEnd(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error {
+	return c.register(newEndRecord(spaceID, creatorID, screenName, title, startedAt, createdAt))
+}
+
+// Claim は通知済みの確認と通知の確保を 1 つのトランザクションで行う
+func (c *SQLiteClient) Claim(spaceID string, status SpaceNotificationStatus) (bool, error) {
+	claimed := false
+	err := c.modify(spaceID, func(record *Space) error {
+		claimed = claimRecord(record, status)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return claimed, nil
+}
+
+func (c *SQLiteClient) FailClaim(spaceID string, status SpaceNotificationStatus) error {
+	return c.modify(spaceID, func(record *Space) error {
+		failClaimRecord(record, status)
+		return nil
+	})
+}
+
+func (c *SQLiteClient) RecoverClaims() ([]*Space, error) {
+	var recovered []*Space
+	err := c.update(func(tx *sql.Tx) error {
+		records, err := loadSpaces(tx, `claim_state = ?`, int32(ClaimState_CLAIM_PENDING))
+		if err != nil {
+			return err
+		}
+
+		for _, s := range records {
+			s.Claim.State = ClaimState_CLAIM_FAILED
+			if err := putSpace(tx, s); err != nil {
+				return err
+			}
+			recovered = append(recovered, s)
+		}
+		return nil
+	})
+	return recovered, err
+}
+
+func (c *SQLiteClient) GetSpace(spaceID string) (*Space, error) {
+	return getSpace(c.db, spaceID)
+}
+
+// GetActiveSpaces は状態を記録済みで、終了・キャンセルを確認していないスペースの一覧を返す
+func (c *SQLiteClient) GetActiveSpaces() ([]*Space, error) {
+	return loadSpaces(c.db, `state NOT IN (?, ?, '')`, StateEnded, StateCanceled)
+}
+
+func (c *SQLiteClient) GetTweets(spaceID string) ([]*Tweet, error) {
+	record, err := c.GetSpace(spaceID)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return record.Tweets, nil
+}
+
+func (c *SQLiteClient) AddTweet(spaceID string, status SpaceNotificationStatus, tweetID int64) error {
+	return c.modify(spaceID, func(record *Space) error {
+		record.Tweets = append(record.Tweets, &Tweet{
+			Id:                 tweetID,
+			NotificationStatus: status,
+		})
+		return nil
+	})
+}
+
+func (c *SQLiteClient) ClearTweets(spaceID string) error {
+	return c.modify(spaceID, func(record *Space) error {
+		record.Tweets = nil
+		return nil
+	})
+}
+
+func (c *SQLiteClient) SetState(spaceID, state string) error {
+	return c.modify(spaceID, func(record *Space) error {
+		setState(record, state)
+		return nil
+	})
+}
+
+func (c *SQLiteClient) SetScheduledStart(spaceID string, scheduledStart time.Time) error {
+	return c.modify(spaceID, func(record *Space) error {
+		record.ScheduledStart = timestamppb.New(scheduledStart)
+		return nil
+	})
+}
+
+// DeleteSpace はスペースの記録と配送を削除し、未通知の状態に戻す
+func (c *SQLiteClient) DeleteSpace(spaceID string) error {
+	return c.update(func(tx *sql.Tx) error {
+		for _, table := range []string{"tweet", "participant", "milestone", "joined", "outbox"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE space_id = ?`, spaceID); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`DELETE FROM space WHERE id = ?`, spaceID)
+		return err
+	})
+}
+
+// ClaimMilestone は参加者数の節目を通知済みとして記録し、未通知だったかを返す
+func (c *SQLiteClient) ClaimMilestone(spaceID string, threshold int64) (bool, error) {
+	return c.claimRow(spaceID, `INSERT OR IGNORE INTO milestone (space_id, threshold) VALUES (?, ?)`, threshold)
+}
+
+// ClaimJoined はスペースに参加したユーザーを通知済みとして記録し、未通知だったかを返す
+func (c *SQLiteClient) ClaimJoined(spaceID, userID string) (bool, error) {
+	return c.claimRow(spaceID, `INSERT OR IGNORE INTO joined (space_id, user_id) VALUES (?, ?)`, userID)
+}
+
+// claimRow はスペースに 1 度だけ記録する行を追加し、追加できたかを返す
+func (c *SQLiteClient) claimRow(spaceID, query string, value interface{}) (bool, error) {
+	claimed := false
+	err := c.update(func(tx *sql.Tx) error {
+		record, err := getSpace(tx, spaceID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			if err := putSpace(tx, &Space{Id: spaceID}); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.Exec(query, spaceID, value)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		claimed = n > 0
+		return nil
+	})
+	return claimed, err
+}
+
+// AddParticipantCount は配信中の参加者数を 1 行として記録する
+func (c *SQLiteClient) AddParticipantCount(spaceID string, t time.Time, count int64) error {
+	return c.update(func(tx *sql.Tx) error {
+		record, err := getSpace(tx, spaceID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			if err := putSpace(tx, &Space{Id: spaceID}); err != nil {
+				return err
+			}
+		}
+
+		// 同じ秒の取得は 1 件にまとめる
+		_, err = tx.Exec(`INSERT OR IGNORE INTO participant (space_id, timestamp, count) VALUES (?, ?, ?)`,
+			spaceID, formatTime(t.Truncate(time.Second)), count)
+		return err
+	})
+}
+
+func (c *SQLiteClient) ForEachSpace(f func(s *Space) error) error {
+	records, err := loadSpaces(c.db, `1 = 1`)
+	if err != nil {
+		return err
+	}
+	for _, s := range records {
+		if err := f(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergeSpace はスペースの記録を ID で統合する
+// 通知ステータスが進んでいる方を優先し、通知ステータスを戻すことはしない
+func (c *SQLiteClient) MergeSpace(s *Space) error {
+	return c.update(func(tx *sql.Tx) error {
+		current, err := getSpace(tx, s.Id)
+		if err != nil {
+			return err
+		}
+		merged := mergeRecord(current, s)
+		if err := putSpace(tx, merged); err != nil {
+			return err
+		}
+		if err := putParticipants(tx, merged); err != nil {
+			return err
+		}
+		if err := putMilestones(tx, merged); err != nil {
+			return err
+		}
+		return putJoined(tx, merged)
+	})
+}
+
+func (c *SQLiteClient) update(f func(tx *sql.Tx) error) error {
+	return sqliteUpdate(c.db, f)
+}
+
+func (c *SQLiteClient) modify(spaceID string, f func(record *Space) error) error {
+	return c.update(func(tx *sql.Tx) error {
+		record, err := getSpace(tx, spaceID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			record = &Space{
+				Id: spaceID,
+			}
+		}
+
+		if err := f(record); err != nil {
+			return err
+		}
+		return putSpace(tx, record)
+	})
+}
+
+func (c *SQLiteClient) register(record *Space) error {
+	return c.update(func(tx *sql.Tx) error {
+		prev, err := getSpace(tx, record.Id)
+		if err != nil {
+			return err
+		}
+		inheritRecord(record, prev)
+
+		if err := putSpace(tx, record); err != nil {
+			return err
+		}
+		return appendEventSQLite(tx, notifiedEvent(record))
+	})
+}
+
+func sqliteUpdate(db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func getSpace(q sqliteQueryer, spaceID string) (*Space, error) {
+	records, err := loadSpaces(q, `id = ?`, spaceID)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// loadSpaces は条件に一致するスペースを投稿済みツイートとともに ID 順に返す
+func loadSpaces(q sqliteQueryer, where string, args ...interface{}) ([]*Space, error) {
+	rows, err := q.Query(`SELECT `+spaceColumns+` FROM space WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Space
+	index := make(map[string]*Space)
+	for rows.Next() {
+		s, err := scanSpace(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		records = append(records, s)
+		index[s.Id] = s
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	rows, err = q.Query(`SELECT space_id, tweet_id, notification_status FROM tweet
+		WHERE space_id IN (SELECT id FROM space WHERE `+where+`) ORDER BY rowid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var spaceID string
+		var tweetID int64
+		var status int32
+		if err := rows.Scan(&spaceID, &tweetID, &status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if s, ok := index[spaceID]; ok {
+			s.Tweets = append(s.Tweets, &Tweet{
+				Id:                 tweetID,
+				NotificationStatus: SpaceNotificationStatus(status),
+			})
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`SELECT space_id, timestamp, count FROM participant
+		WHERE space_id IN (SELECT id FROM space WHERE `+where+`) ORDER BY space_id, timestamp`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var spaceID string
+		var timestamp sql.NullString
+		var count int64
+		if err := rows.Scan(&spaceID, &timestamp, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t, err := parseSQLiteTime(timestamp)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if s, ok := index[spaceID]; ok && t != nil {
+			addParticipantCount(s, t.AsTime(), count)
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`SELECT space_id, threshold FROM milestone
+		WHERE space_id IN (SELECT id FROM space WHERE `+where+`) ORDER BY space_id, rowid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var spaceID string
+		var threshold int64
+		if err := rows.Scan(&spaceID, &threshold); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if s, ok := index[spaceID]; ok {
+			s.Milestones = append(s.Milestones, threshold)
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`SELECT space_id, user_id FROM joined
+		WHERE space_id IN (SELECT id FROM space WHERE `+where+`) ORDER BY space_id, rowid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var spaceID, userID string
+		if err := rows.Scan(&spaceID, &userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if s, ok := index[spaceID]; ok {
+			s.JoinedUserIds = append(s.JoinedUserIds, userID)
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func scanSpace(row sqliteScanner) (*Space, error) {
+	var s Space
+	var status int32
+	var scheduledStart, startedAt, createdAt, closedAt, claimedAt sql.NullString
+	var claimStatus, claimState sql.NullInt32
+	err := row.Scan(&s.Id, &s.CreatorId, &s.ScreenName, &s.Title, &status, &s.State,
+		&scheduledStart, &startedAt, &createdAt, &closedAt, &claimStatus, &claimState, &claimedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.NotificationStatus = SpaceNotificationStatus(status)
+	for _, t := range []struct {
+		src sql.NullString
+		dst **timestamppb.Timestamp
+	}{
+		{scheduledStart, &s.ScheduledStart},
+		{startedAt, &s.StartedAt},
+		{createdAt, &s.CreatedAt},
+		{closedAt, &s.ClosedAt},
+	} {
+		if *t.dst, err = parseSQLiteTime(t.src); err != nil {
+			return nil, err
+		}
+	}
+
+	if claimState.Valid {
+		s.Claim = &Claim{
+			NotificationStatus: SpaceNotificationStatus(claimStatus.Int32),
+			State:              ClaimState(claimState.Int32),
+		}
+		if s.Claim.ClaimedAt, err = parseSQLiteTime(claimedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// putSpace はスペースの記録と投稿済みツイートを置き換える
+func putSpace(tx *sql.Tx, s *Space) error {
+	var claimStatus, claimState, claimedAt interface{}
+	if s.Claim != nil {
+		claimStatus = int32(s.Claim.NotificationStatus)
+		claimState = int32(s.Claim.State)
+		claimedAt = formatSQLiteTime(s.Claim.ClaimedAt)
+	}
+
+	_, err := tx.Exec(`INSERT OR REPLACE INTO space (`+spaceColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.Id, s.CreatorId, s.ScreenName, s.Title, int32(s.NotificationStatus), s.State,
+		formatSQLiteTime(s.ScheduledStart), formatSQLiteTime(s.StartedAt), formatSQLiteTime(s.CreatedAt), formatSQLiteTime(s.ClosedAt),
+		claimStatus, claimState, claimedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM tweet WHERE space_id = ?`, s.Id); err != nil {
+		return err
+	}
+	for _, t := range s.Tweets {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO tweet (space_id, tweet_id, notification_status) VALUES (?, ?, ?)`,
+			s.Id, t.Id, int32(t.NotificationStatus))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putParticipants は参加者数の時系列を置き換える
+// 参加者数は AddParticipantCount で 1 行ずつ追加するため、putSpace では書き込まない
+func putParticipants(tx *sql.Tx, s *Space) error {
+	if _, err := tx.Exec(`DELETE FROM participant WHERE space_id = ?`, s.Id); err != nil {
+		return err
+	}
+
+	p := s.Participants
+	if p == nil {
+		return nil
+	}
+	start := p.StartedAt.AsTime()
+	for i, offset := range p.Offsets {
+		if i >= len(p.Counts) {
+			break
+		}
+		t := start.Add(time.Duration(offset) * time.Second)
+		_, err := tx.Exec(`INSERT OR IGNORE INTO participant (space_id, timestamp, count) VALUES (?, ?, ?)`,
+			s.Id, formatTime(t), p.Counts[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putMilestones は通知済みの参加者数の節目を置き換える
+func putMilestones(tx *sql.Tx, s *Space) error {
+	if _, err := tx.Exec(`DELETE FROM milestone WHERE space_id = ?`, s.Id); err != nil {
+		return err
+	}
+	for _, threshold := range s.Milestones {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO milestone (space_id, threshold) VALUES (?, ?)`, s.Id, threshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putJoined は参加を通知済みのユーザーを置き換える
+func putJoined(tx *sql.Tx, s *Space) error {
+	if _, err := tx.Exec(`DELETE FROM joined WHERE space_id = ?`, s.Id); err != nil {
+		return err
+	}
+	for _, userID := range s.JoinedUserIds {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO joined (space_id, user_id) VALUES (?, ?)`, s.Id, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func closeRows(rows *sql.Rows) error {
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	return rows.Close()
+}
+
+// formatSQLiteTime は時刻を UTC の固定長の文字列にして、文字列の比較で前後を判定できるようにする
+func formatSQLiteTime(t *timestamppb.Timestamp) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(t.AsTime())
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+func parseSQLiteTime(s sql.NullString) (*timestamppb.Timestamp, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.String)
+	if err != nil {
+		return nil, err
+	}
+	return timestamppb.New(t), nil
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,192 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const eventColumns = `space_id, creator_id, screen_name, type, timestamp, state, title,
+	notification_status, sink, scheduled_start, started_at, error`
+
+// RecordObservation は前回の観測から状態・タイトル・開始予定日時が変化していれば履歴に追加する
+func (c *SQLiteClient) RecordObservation(e *Event) error {
+	e.Type = EventType_EVENT_OBSERVED
+	return c.update(func(tx *sql.Tx) error {
+		events, err := loadEvents(tx, `space_id = ? AND type = ? ORDER BY timestamp DESC, rowid DESC LIMIT 1`,
+			e.SpaceId, int32(EventType_EVENT_OBSERVED))
+		if err != nil {
+			return err
+		}
+		if len(events) > 0 && sameObservation(events[0], e) {
+			return nil
+		}
+
+		return appendEventSQLite(tx, e)
+	})
+}
+
+// GetHistory はスペースの履歴を時刻順に返す
+func (c *SQLiteClient) GetHistory(spaceID string) ([]*Event, error) {
+	return loadEvents(c.db, `space_id = ? ORDER BY timestamp, rowid`, spaceID)
+}
+
+// QueryHistory は作成者・期間で絞り込んだ履歴を時刻順に返す
+func (c *SQLiteClient) QueryHistory(q HistoryQuery) ([]*Event, error) {
+	conds := []string{`1 = 1`}
+	var args []interface{}
+	if q.CreatorID != "" {
+		conds = append(conds, `creator_id = ?`)
+		args = append(args, q.CreatorID)
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, `timestamp >= ?`)
+		args = append(args, formatTime(q.From))
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, `timestamp < ?`)
+		args = append(args, formatTime(q.To))
+	}
+	return loadEvents(c.db, strings.Join(conds, ` AND `)+` ORDER BY timestamp, rowid`, args...)
+}
+
+// ForEachEvent は履歴を ID とともに列挙する
+// ID は bolt と同じ形式のため、どちらのストレージにも取り込める
+func (c *SQLiteClient) ForEachEvent(f func(id string, e *Event) error) error {
+	rows, err := c.db.Query(`SELECT id FROM history ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := closeRows(rows); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		events, err := loadEvents(c.db, `id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			continue
+		}
+		if err := f(id, events[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportEvent は ForEachEvent で列挙した履歴を取り込む
+// 同じ ID の履歴が存在する場合は何もしない
+func (c *SQLiteClient) ImportEvent(id string, e *Event) error {
+	if _, err := hex.DecodeString(id); err != nil {
+		return err
+	}
+	return c.update(func(tx *sql.Tx) error {
+		return putEvent(tx, `INSERT OR IGNORE`, id, e)
+	})
+}
+
+func loadEvents(q sqliteQueryer, where string, args ...interface{}) ([]*Event, error) {
+	rows, err := q.Query(`SELECT `+eventColumns+` FROM history WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	for rows.Next() {
+		var e Event
+		var eventType, status int32
+		var timestamp, scheduledStart, startedAt sql.NullString
+		err := rows.Scan(&e.SpaceId, &e.CreatorId, &e.ScreenName, &eventType, &timestamp, &e.State, &e.Title,
+			&status, &e.Sink, &scheduledStart, &startedAt, &e.Error)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e.Type = EventType(eventType)
+		e.NotificationStatus = SpaceNotificationStatus(status)
+		for _, t := range []struct {
+			src sql.NullString
+			dst **timestamppb.Timestamp
+		}{
+			{timestamp, &e.Timestamp},
+			{scheduledStart, &e.ScheduledStart},
+			{startedAt, &e.StartedAt},
+		} {
+			if *t.dst, err = parseSQLiteTime(t.src); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		events = append(events, &e)
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// appendEventSQLite は履歴を追加する
+// ID は bolt の履歴のキーと同じ形式で、連番には行 ID を使用する
+func appendEventSQLite(tx *sql.Tx, e *Event) error {
+	if e.Timestamp == nil {
+		e.Timestamp = timestamppb.Now()
+	}
+
+	if e.CreatorId == "" {
+		s, err := getSpace(tx, e.SpaceId)
+		if err != nil {
+			return err
+		}
+		if s != nil {
+			fillEventCreator(e, s)
+		}
+	}
+
+	var seq uint64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(rowid), 0) + 1 FROM history`).Scan(&seq); err != nil {
+		return err
+	}
+
+	id := hex.EncodeToString(historyKey(e.SpaceId, e.Timestamp.AsTime(), seq))
+	return putEvent(tx, `INSERT`, id, e)
+}
+
+func putEvent(tx *sql.Tx, insert, id string, e *Event) error {
+	if e.Timestamp == nil {
+		return errors.New("event timestamp is not set: " + id)
+	}
+	_, err := tx.Exec(insert+` INTO history (id, `+eventColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, e.SpaceId, e.CreatorId, e.ScreenName, int32(e.Type), formatSQLiteTime(e.Timestamp), e.State, e.Title,
+		int32(e.NotificationStatus), e.Sink, formatSQLiteTime(e.ScheduledStart), formatSQLiteTime(e.StartedAt), e.Error)
+	return err
+}
@@ -0,0 +1,178 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// closedBefore は before より前に終了・キャンセルされたスペースの条件
+// 最終更新日時は lastActivity と同じ順に決め、状態のない記録は isExpirableState と同じく対象にする
+const closedBefore = `COALESCE(state, '') IN (?, ?, '') AND COALESCE(closed_at, started_at, scheduled_start, created_at) < ?`
+
+// DeleteClosedSpaces は before より前に終了・キャンセルされたスペースの記録と配送済みの outbox を削除する
+// 状態のない記録は最終更新日時が before より前のものを削除する
+func (c *SQLiteClient) DeleteClosedSpaces(before time.Time) (int, error) {
+	deleted := 0
+	err := c.update(func(tx *sql.Tx) error {
+		args := []interface{}{StateEnded, StateCanceled, formatTime(before)}
+
+		_, err := tx.Exec(`DELETE FROM outbox WHERE state != ? AND space_id IN (SELECT id FROM space WHERE `+closedBefore+`)`,
+			append([]interface{}{int32(DeliveryState_DELIVERY_PENDING)}, args...)...)
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"tweet", "participant", "milestone", "joined"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE space_id IN (SELECT id FROM space WHERE `+closedBefore+`)`, args...); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.Exec(`DELETE FROM space WHERE `+closedBefore, args...)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		deleted = int(n)
+		return nil
+	})
+	return deleted, err
+}
+
+// DeleteHistory は before より前の履歴を削除する
+func (c *SQLiteClient) DeleteHistory(before time.Time) (int, error) {
+	result, err := c.db.Exec(`DELETE FROM history WHERE timestamp < ?`, formatTime(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// Backup は VACUUM INTO で一貫したスナップショットを dst に書き出し、
+// 書き出したファイルを開き直して検証する
+func (c *SQLiteClient) Backup(dst string) (int64, error) {
+	tmp := dst + ".tmp"
+	os.Remove(tmp)
+
+	if _, err := c.db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := VerifySQLite(tmp); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	size, err := fileSize(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// VerifySQLite はデータベースファイルを開き、スキーマと全データの整合性を確認する
+func VerifySQLite(path string) error {
+	// 存在しないファイルを新しく作らないように確認しておく
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := readUserVersion(db)
+	if err != nil {
+		return err
+	}
+	if version != sqliteSchemaVersion() {
+		return fmt.Errorf("%w: %d", ErrUnknownSchemaVersion, version)
+	}
+
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := closeRows(rows); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return errors.New("integrity check failed: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// CompactSQLite はデータベースファイルを再構築して未使用の領域を解放する
+func CompactSQLite(path string) (before, after int64, err error) {
+	before, err = fileSize(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	statements := []string{
+		`PRAGMA busy_timeout = 5000`,
+		`VACUUM`,
+		`PRAGMA wal_checkpoint(TRUNCATE)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	after, err = fileSize(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
@@ -0,0 +1,227 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type sqliteMigration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// sqliteMigrations は SQLite のスキーマの変更履歴
+// 追加のみ行い、既存の要素は変更しないこと
+// bolt の migrations にバケットを追加した場合は対応するテーブルをこちらにも追加する
+var sqliteMigrations = []sqliteMigration{
+	{
+		version: 1,
+		name:    "create space, tweet, outbox and history tables",
+		statements: []string{
+			`CREATE TABLE space (
+				id                  TEXT PRIMARY KEY,
+				creator_id          TEXT NOT NULL DEFAULT '',
+				screen_name         TEXT NOT NULL DEFAULT '',
+				title               TEXT NOT NULL DEFAULT '',
+				notification_status INTEGER NOT NULL DEFAULT 0,
+				state               TEXT NOT NULL DEFAULT '',
+				scheduled_start     TEXT,
+				started_at          TEXT,
+				created_at          TEXT,
+				closed_at           TEXT,
+				claim_status        INTEGER,
+				claim_state         INTEGER,
+				claimed_at          TEXT
+			)`,
+			`CREATE INDEX space_creator_id ON space (creator_id)`,
+			`CREATE TABLE tweet (
+				space_id            TEXT NOT NULL,
+				tweet_id            INTEGER NOT NULL,
+				notification_status INTEGER NOT NULL,
+				PRIMARY KEY (space_id, tweet_id)
+			)`,
+			`CREATE TABLE outbox (
+				space_id            TEXT NOT NULL,
+				notification_status INTEGER NOT NULL,
+				sink                TEXT NOT NULL,
+				state               INTEGER NOT NULL,
+				attempts            INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at     TEXT,
+				last_error          TEXT NOT NULL DEFAULT '',
+				space_snapshot      BLOB,
+				user_snapshot       BLOB,
+				created_at          TEXT,
+				delivered_at        TEXT,
+				PRIMARY KEY (space_id, notification_status, sink)
+			)`,
+			`CREATE INDEX outbox_state ON outbox (state, next_attempt_at)`,
+			`CREATE TABLE history (
+				id                  TEXT PRIMARY KEY,
+				space_id            TEXT NOT NULL,
+				creator_id          TEXT NOT NULL DEFAULT '',
+				screen_name         TEXT NOT NULL DEFAULT '',
+				type                INTEGER NOT NULL,
+				timestamp           TEXT NOT NULL,
+				state               TEXT NOT NULL DEFAULT '',
+				title               TEXT NOT NULL DEFAULT '',
+				notification_status INTEGER NOT NULL DEFAULT 0,
+				sink                TEXT NOT NULL DEFAULT '',
+				scheduled_start     TEXT,
+				started_at          TEXT,
+				error               TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX history_space_id ON history (space_id, timestamp)`,
+			`CREATE INDEX history_creator_id ON history (creator_id, timestamp)`,
+			`CREATE INDEX history_timestamp ON history (timestamp)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "create participant table",
+		statements: []string{
+			`CREATE TABLE participant (
+				space_id            TEXT NOT NULL,
+				timestamp           TEXT NOT NULL,
+				count               INTEGER NOT NULL,
+				PRIMARY KEY (space_id, timestamp)
+			)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "create milestone table",
+		statements: []string{
+			`CREATE TABLE milestone (
+				space_id            TEXT NOT NULL,
+				threshold           INTEGER NOT NULL,
+				PRIMARY KEY (space_id, threshold)
+			)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "create joined table",
+		statements: []string{
+			`CREATE TABLE joined (
+				space_id            TEXT NOT NULL,
+				user_id             TEXT NOT NULL,
+				PRIMARY KEY (space_id, user_id)
+			)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "create watched table",
+		statements: []string{
+			`CREATE TABLE watched (
+				space_id            TEXT PRIMARY KEY,
+				added_at            TEXT
+			)`,
+		},
+	},
+	{
+		// 主キーを変更するため、テーブルを作り直して既存の配送を移す
+		version: 6,
+		name:    "add delivery_key to outbox",
+		statements: []string{
+			`ALTER TABLE outbox RENAME TO outbox_v5`,
+			`CREATE TABLE outbox (
+				space_id            TEXT NOT NULL,
+				notification_status INTEGER NOT NULL,
+				delivery_key        TEXT NOT NULL DEFAULT '',
+				sink                TEXT NOT NULL,
+				state               INTEGER NOT NULL,
+				attempts            INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at     TEXT,
+				last_error          TEXT NOT NULL DEFAULT '',
+				space_snapshot      BLOB,
+				user_snapshot       BLOB,
+				created_at          TEXT,
+				delivered_at        TEXT,
+				PRIMARY KEY (space_id, notification_status, delivery_key, sink)
+			)`,
+			`INSERT INTO outbox (space_id, notification_status, sink, state, attempts, next_attempt_at,
+				last_error, space_snapshot, user_snapshot, created_at, delivered_at)
+				SELECT space_id, notification_status, sink, state, attempts, next_attempt_at,
+				last_error, space_snapshot, user_snapshot, created_at, delivered_at FROM outbox_v5`,
+			`DROP TABLE outbox_v5`,
+			`CREATE INDEX outbox_state ON outbox (state, next_attempt_at)`,
+		},
+	},
+}
+
+func sqliteSchemaVersion() int {
+	return sqliteMigrations[len(sqliteMigrations)-1].version
+}
+
+// migrateSQLite は未適用のマイグレーションを順に適用する
+// スキーマのバージョンは user_version に記録する
+func migrateSQLite(db *sql.DB, path string) error {
+	version, err := readUserVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := sqliteSchemaVersion()
+	if version > latest {
+		return fmt.Errorf("%w: %d (supported: %d)", ErrUnknownSchemaVersion, version, latest)
+	}
+	if version == latest {
+		return nil
+	}
+
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		backup := fmt.Sprintf("%s.v%d.%s.bak", path, version, time.Now().Format("20060102150405"))
+		if _, err := db.Exec(`VACUUM INTO ?`, backup); err != nil {
+			return fmt.Errorf("backup before migration failed: %w", err)
+		}
+	}
+
+	for _, m := range sqliteMigrations {
+		if m.version <= version {
+			continue
+		}
+		err := sqliteUpdate(db, func(tx *sql.Tx) error {
+			for _, stmt := range m.statements {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, m.version))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func readUserVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
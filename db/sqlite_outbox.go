@@ -0,0 +1,175 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const deliveryColumns = `space_id, notification_status, delivery_key, sink, state, attempts, next_attempt_at,
+	last_error, space_snapshot, user_snapshot, created_at, delivered_at`
+
+// EnqueueDeliveries は通知先ごとの配送を登録する
+// 登録済みの配送は変更せず、同じスペースの以前のステータスで未配送のものは破棄する
+// key は同じステータスで複数回通知するイベントを区別する
+func (c *SQLiteClient) EnqueueDeliveries(spaceID string, status SpaceNotificationStatus, key string, sinks []string, space, user []byte) error {
+	now := time.Now()
+	return c.update(func(tx *sql.Tx) error {
+		pending, err := loadDeliveries(tx, `space_id = ? AND state = ?`, spaceID, int32(DeliveryState_DELIVERY_PENDING))
+		if err != nil {
+			return err
+		}
+		for _, d := range pending {
+			if !supersedeDelivery(d, status) {
+				continue
+			}
+			if err := putDeliverySQLite(tx, d); err != nil {
+				return err
+			}
+		}
+
+		for _, sink := range sinks {
+			var exists int
+			err := tx.QueryRow(`SELECT COUNT(*) FROM outbox WHERE space_id = ? AND notification_status = ? AND delivery_key = ? AND sink = ?`,
+				spaceID, int32(status), key, sink).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if exists > 0 {
+				continue
+			}
+			if err := putDeliverySQLite(tx, newDelivery(spaceID, status, key, sink, space, user, now)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetDueDeliveries は配送時刻を過ぎた未配送の一覧を返す
+func (c *SQLiteClient) GetDueDeliveries(now time.Time) ([]*Delivery, error) {
+	return loadDeliveries(c.db, `state = ? AND next_attempt_at <= ?`, int32(DeliveryState_DELIVERY_PENDING), formatTime(now))
+}
+
+func (c *SQLiteClient) GetDeliveries(state DeliveryState) ([]*Delivery, error) {
+	return loadDeliveries(c.db, `state = ?`, int32(state))
+}
+
+func (c *SQLiteClient) MarkDelivered(d *Delivery) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERED, markDelivered)
+}
+
+// MarkRetry は配送の失敗を記録し、次の配送時刻を設定する
+func (c *SQLiteClient) MarkRetry(d *Delivery, cause error, nextAttempt time.Time) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_FAILED, markRetry(cause, nextAttempt))
+}
+
+// MarkDead は配送を諦めてデッドレターにする
+func (c *SQLiteClient) MarkDead(d *Delivery, cause error) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DEAD, markDead(cause))
+}
+
+// MarkDeferred は失敗として数えずに次の配送時刻を設定する
+func (c *SQLiteClient) MarkDeferred(d *Delivery, nextAttempt time.Time) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DEFERRED, markDeferred(nextAttempt))
+}
+
+// MarkDropped は配送せずに破棄する
+func (c *SQLiteClient) MarkDropped(d *Delivery, reason string) error {
+	return c.updateDelivery(d, EventType_EVENT_DELIVERY_DROPPED, markDropped(reason))
+}
+
+// updateDelivery は配送の状態を更新し、同じトランザクションで履歴に記録する
+func (c *SQLiteClient) updateDelivery(d *Delivery, eventType EventType, f func(d *Delivery)) error {
+	return c.update(func(tx *sql.Tx) error {
+		deliveries, err := loadDeliveries(tx, `space_id = ? AND notification_status = ? AND delivery_key = ? AND sink = ?`,
+			d.SpaceId, int32(d.NotificationStatus), d.Key, d.Sink)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return errors.New("delivery not found: " + d.SpaceId + "/" + d.Sink)
+		}
+
+		current := deliveries[0]
+		f(current)
+
+		if err := putDeliverySQLite(tx, current); err != nil {
+			return err
+		}
+		if err := appendEventSQLite(tx, deliveryEvent(current, eventType)); err != nil {
+			return err
+		}
+
+		proto.Reset(d)
+		proto.Merge(d, current)
+		return nil
+	})
+}
+
+// loadDeliveries は条件に一致する配送をスペース ID・通知ステータス・通知先の順に返す
+func loadDeliveries(q sqliteQueryer, where string, args ...interface{}) ([]*Delivery, error) {
+	rows, err := q.Query(`SELECT `+deliveryColumns+` FROM outbox WHERE `+where+`
+		ORDER BY space_id, notification_status, delivery_key, sink`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		var d Delivery
+		var status, state int32
+		var nextAttemptAt, createdAt, deliveredAt sql.NullString
+		err := rows.Scan(&d.SpaceId, &status, &d.Key, &d.Sink, &state, &d.Attempts, &nextAttemptAt,
+			&d.LastError, &d.Space, &d.User, &createdAt, &deliveredAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.NotificationStatus = SpaceNotificationStatus(status)
+		d.State = DeliveryState(state)
+		if d.NextAttemptAt, err = parseSQLiteTime(nextAttemptAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if d.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if d.DeliveredAt, err = parseSQLiteTime(deliveredAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func putDeliverySQLite(tx *sql.Tx, d *Delivery) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO outbox (`+deliveryColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.SpaceId, int32(d.NotificationStatus), d.Key, d.Sink, int32(d.State), d.Attempts, formatSQLiteTime(d.NextAttemptAt),
+		d.LastError, d.Space, d.User, formatSQLiteTime(d.CreatedAt), formatSQLiteTime(d.DeliveredAt))
+	return err
+}
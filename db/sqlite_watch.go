@@ -0,0 +1,76 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AddWatchedSpace は手動で監視するスペースを登録し、新たに登録したかを返す
+func (c *SQLiteClient) AddWatchedSpace(spaceID string) (bool, error) {
+	added := false
+	err := c.update(func(tx *sql.Tx) error {
+		w := newWatchedSpace(spaceID, time.Now())
+		res, err := tx.Exec(`INSERT OR IGNORE INTO watched (space_id, added_at) VALUES (?, ?)`,
+			w.SpaceId, formatSQLiteTime(w.AddedAt))
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		added = n > 0
+		return nil
+	})
+	return added, err
+}
+
+// GetWatchedSpaces は手動で監視するスペースを登録順に返す
+func (c *SQLiteClient) GetWatchedSpaces() ([]*WatchedSpace, error) {
+	rows, err := c.db.Query(`SELECT space_id, added_at FROM watched ORDER BY added_at, space_id`)
+	if err != nil {
+		return nil, err
+	}
+
+	var watched []*WatchedSpace
+	for rows.Next() {
+		var w WatchedSpace
+		var addedAt sql.NullString
+		if err := rows.Scan(&w.SpaceId, &addedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if w.AddedAt, err = parseSQLiteTime(addedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		watched = append(watched, &w)
+	}
+	if err := closeRows(rows); err != nil {
+		return nil, err
+	}
+	return watched, nil
+}
+
+func (c *SQLiteClient) RemoveWatchedSpace(spaceID string) error {
+	return c.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM watched WHERE space_id = ?`, spaceID)
+		return err
+	})
+}
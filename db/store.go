@@ -0,0 +1,120 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DriverBolt   = "bolt"
+	DriverSQLite = "sqlite"
+	DriverMemory = "memory"
+)
+
+// Store は通知状態・投稿済みツイート・配送・履歴を保存するストレージ
+type Store interface {
+	Close() error
+
+	// 通知状態
+	GetNotifiedStatus(spaceID string) (SpaceNotificationStatus, error)
+	CheckNotified(spaceID string, status SpaceNotificationStatus) (bool, error)
+	RegisterSchedule(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error
+	RegisterScheduleRemind(spaceID, creatorID, screenName, title string, scheduledStart, createdAt time.Time) error
+	RegisterStart(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error
+	RegisterEnd(spaceID, creatorID, screenName, title string, startedAt, createdAt time.Time) error
+	Claim(spaceID string, status SpaceNotificationStatus) (bool, error)
+	FailClaim(spaceID string, status SpaceNotificationStatus) error
+	RecoverClaims() ([]*Space, error)
+	GetSpace(spaceID string) (*Space, error)
+	GetActiveSpaces() ([]*Space, error)
+	SetState(spaceID, state string) error
+	SetScheduledStart(spaceID string, scheduledStart time.Time) error
+	DeleteSpace(spaceID string) error
+	AddParticipantCount(spaceID string, t time.Time, count int64) error
+	ClaimMilestone(spaceID string, threshold int64) (bool, error)
+	ClaimJoined(spaceID, userID string) (bool, error)
+
+	AddWatchedSpace(spaceID string) (bool, error)
+	GetWatchedSpaces() ([]*WatchedSpace, error)
+	RemoveWatchedSpace(spaceID string) error
+
+	// 投稿済みツイート
+	GetTweets(spaceID string) ([]*Tweet, error)
+	AddTweet(spaceID string, status SpaceNotificationStatus, tweetID int64) error
+	ClearTweets(spaceID string) error
+
+	// 配送
+	EnqueueDeliveries(spaceID string, status SpaceNotificationStatus, key string, sinks []string, space, user []byte) error
+	GetDueDeliveries(now time.Time) ([]*Delivery, error)
+	GetDeliveries(state DeliveryState) ([]*Delivery, error)
+	MarkDelivered(d *Delivery) error
+	MarkRetry(d *Delivery, cause error, nextAttempt time.Time) error
+	MarkDead(d *Delivery, cause error) error
+	MarkDeferred(d *Delivery, nextAttempt time.Time) error
+	MarkDropped(d *Delivery, reason string) error
+
+	// 履歴
+	RecordObservation(e *Event) error
+	GetHistory(spaceID string) ([]*Event, error)
+	QueryHistory(q HistoryQuery) ([]*Event, error)
+
+	// 保守
+	DeleteClosedSpaces(before time.Time) (int, error)
+	DeleteHistory(before time.Time) (int, error)
+	Backup(dst string) (int64, error)
+	ForEachSpace(f func(s *Space) error) error
+	ForEachEvent(f func(id string, e *Event) error) error
+	MergeSpace(s *Space) error
+	ImportEvent(id string, e *Event) error
+}
+
+var (
+	_ Store = (*Client)(nil)
+	_ Store = (*SQLiteClient)(nil)
+	_ Store = (*MemoryClient)(nil)
+)
+
+// OpenStore は driver で指定したストレージを開く
+// driver が空の場合は bolt を使用し、memory の場合は path を使用しない
+func OpenStore(driver, path string) (Store, error) {
+	switch driver {
+	case "", DriverBolt:
+		return Open(path)
+	case DriverSQLite:
+		return OpenSQLite(path)
+	case DriverMemory:
+		return NewMemoryClient(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver: %s", driver)
+	}
+}
+
+// CompactStore はデータベースファイルを再構築して未使用の領域を解放する
+func CompactStore(driver, path string) (before, after int64, err error) {
+	switch driver {
+	case "", DriverBolt:
+		return Compact(path)
+	case DriverSQLite:
+		return CompactSQLite(path)
+	case DriverMemory:
+		return 0, 0, ErrNotSupported
+	default:
+		return 0, 0, fmt.Errorf("unknown database driver: %s", driver)
+	}
+}
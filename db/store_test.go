@@ -0,0 +1,503 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// forEachStore は各ストレージの実装で同じテストを実行する
+func forEachStore(t *testing.T, f func(t *testing.T, c Store)) {
+	for _, driver := range []string{DriverBolt, DriverSQLite, DriverMemory} {
+		t.Run(driver, func(t *testing.T) {
+			c, err := OpenStore(driver, filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			f(t, c)
+		})
+	}
+}
+
+func TestStoreNotification(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		now := time.Now()
+
+		claimed, err := c.Claim("space1", SpaceNotificationStatus_SCHEDULE)
+		if err != nil || !claimed {
+			t.Fatalf("Claim, actual: %v, %v, expected: true", claimed, err)
+		}
+		claimed, err = c.Claim("space1", SpaceNotificationStatus_SCHEDULE)
+		if err != nil || claimed {
+			t.Fatalf("Claim pending, actual: %v, %v, expected: false", claimed, err)
+		}
+
+		if err := c.RegisterSchedule("space1", "user1", "user1", "title", now, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddTweet("space1", SpaceNotificationStatus_SCHEDULE, 100); err != nil {
+			t.Fatal(err)
+		}
+
+		notified, err := c.CheckNotified("space1", SpaceNotificationStatus_SCHEDULE_REMIND)
+		if err != nil || notified {
+			t.Fatalf("CheckNotified, actual: %v, %v, expected: false", notified, err)
+		}
+
+		claimed, err = c.Claim("space1", SpaceNotificationStatus_START)
+		if err != nil || !claimed {
+			t.Fatalf("Claim start, actual: %v, %v, expected: true", claimed, err)
+		}
+		recovered, err := c.RecoverClaims()
+		if err != nil || len(recovered) != 1 {
+			t.Fatalf("RecoverClaims, actual: %v, %v", recovered, err)
+		}
+
+		if err := c.RegisterStart("space1", "user1", "user1", "title", now, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddTweet("space1", SpaceNotificationStatus_START, 101); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := c.GetSpace("space1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.NotificationStatus != SpaceNotificationStatus_START || s.State != StateLive {
+			t.Errorf("GetSpace, actual: %v, %v", s.NotificationStatus, s.State)
+		}
+		if len(s.Tweets) != 2 || s.Tweets[0].Id != 100 || s.Tweets[1].Id != 101 {
+			t.Errorf("GetSpace tweets, actual: %v", s.Tweets)
+		}
+		if !s.CreatedAt.AsTime().Equal(now) {
+			t.Errorf("GetSpace created_at, actual: %v, expected: %v", s.CreatedAt.AsTime(), now)
+		}
+
+		// 取り込みで通知ステータスを戻さない
+		err = c.MergeSpace(&Space{
+			Id:                 "space1",
+			NotificationStatus: SpaceNotificationStatus_SCHEDULE,
+			Tweets:             []*Tweet{{Id: 99, NotificationStatus: SpaceNotificationStatus_SCHEDULE}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := c.GetNotifiedStatus("space1")
+		if err != nil || status != SpaceNotificationStatus_START {
+			t.Errorf("GetNotifiedStatus after merge, actual: %v, %v", status, err)
+		}
+		tweets, err := c.GetTweets("space1")
+		if err != nil || len(tweets) != 3 {
+			t.Errorf("GetTweets after merge, actual: %v, %v", tweets, err)
+		}
+
+		if err := c.SetState("space1", StateEnded); err != nil {
+			t.Fatal(err)
+		}
+		active, err := c.GetActiveSpaces()
+		if err != nil || len(active) != 0 {
+			t.Errorf("GetActiveSpaces, actual: %v, %v", active, err)
+		}
+
+		deleted, err := c.DeleteClosedSpaces(time.Now().Add(time.Hour))
+		if err != nil || deleted != 1 {
+			t.Errorf("DeleteClosedSpaces, actual: %v, %v", deleted, err)
+		}
+		status, err = c.GetNotifiedStatus("space1")
+		if err != nil || status != SpaceNotificationStatus_NONE {
+			t.Errorf("GetNotifiedStatus after delete, actual: %v, %v", status, err)
+		}
+	})
+}
+
+func TestStoreOutbox(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		sinks := []string{"command", "tweet"}
+		if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_SCHEDULE, "", sinks, []byte("{}"), []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_SCHEDULE, "", sinks, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		due, err := c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 2 {
+			t.Fatalf("GetDueDeliveries, actual: %v, %v", due, err)
+		}
+		if string(due[0].Space) != "{}" {
+			t.Errorf("GetDueDeliveries space, actual: %q", due[0].Space)
+		}
+
+		if err := c.MarkDelivered(due[0]); err != nil {
+			t.Fatal(err)
+		}
+		if due[0].State != DeliveryState_DELIVERY_DELIVERED || due[0].Attempts != 1 {
+			t.Errorf("MarkDelivered, actual: %v", due[0])
+		}
+		if err := c.MarkRetry(due[1], errors.New("error"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		due, err = c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 0 {
+			t.Fatalf("GetDueDeliveries after retry, actual: %v, %v", due, err)
+		}
+
+		// 次のステータスを登録すると未配送のものは破棄される
+		if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_START, "", sinks[:1], nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		superseded, err := c.GetDeliveries(DeliveryState_DELIVERY_SUPERSEDED)
+		if err != nil || len(superseded) != 1 || superseded[0].Sink != "tweet" {
+			t.Errorf("GetDeliveries superseded, actual: %v, %v", superseded, err)
+		}
+
+		events, err := c.GetHistory("space1")
+		if err != nil || len(events) != 2 {
+			t.Fatalf("GetHistory, actual: %v, %v", events, err)
+		}
+		if events[0].Type != EventType_EVENT_DELIVERED || events[1].Type != EventType_EVENT_DELIVERY_FAILED {
+			t.Errorf("GetHistory types, actual: %v, %v", events[0].Type, events[1].Type)
+		}
+	})
+}
+
+func TestStoreOutboxKey(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_START, "", []string{"tweet"}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		// 同じステータスでも key が異なれば別の配送として登録する
+		for _, key := range []string{"100", "1000", "100"} {
+			if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_MILESTONE, key, []string{"tweet"}, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 節目の通知は開始の通知を破棄しない
+		due, err := c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 3 {
+			t.Fatalf("GetDueDeliveries, actual: %v, %v", due, err)
+		}
+		keys := make(map[string]bool)
+		for _, d := range due {
+			keys[d.Key] = true
+		}
+		if !keys[""] || !keys["100"] || !keys["1000"] {
+			t.Errorf("GetDueDeliveries keys, actual: %v", keys)
+		}
+
+		for _, d := range due {
+			if d.Key == "100" {
+				if err := c.MarkDelivered(d); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		due, err = c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 2 {
+			t.Errorf("GetDueDeliveries after delivered, actual: %v, %v", due, err)
+		}
+		for _, d := range due {
+			if d.Key == "100" {
+				t.Errorf("GetDueDeliveries after delivered, actual: %v", d)
+			}
+		}
+	})
+}
+
+func TestStoreOutboxDeferDrop(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_SCHEDULE, "", []string{"command", "tweet"}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		due, err := c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 2 {
+			t.Fatalf("GetDueDeliveries, actual: %v, %v", due, err)
+		}
+
+		// 延期は失敗として数えない
+		if err := c.MarkDeferred(due[0], time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if due[0].State != DeliveryState_DELIVERY_PENDING || due[0].Attempts != 0 || !due[0].NextAttemptAt.AsTime().After(time.Now()) {
+			t.Errorf("MarkDeferred, actual: %v", due[0])
+		}
+		if err := c.MarkDropped(due[1], "quiet hours"); err != nil {
+			t.Fatal(err)
+		}
+
+		due, err = c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 0 {
+			t.Fatalf("GetDueDeliveries after defer, actual: %v, %v", due, err)
+		}
+		dropped, err := c.GetDeliveries(DeliveryState_DELIVERY_DROPPED)
+		if err != nil || len(dropped) != 1 || dropped[0].LastError != "quiet hours" {
+			t.Errorf("GetDeliveries dropped, actual: %v, %v", dropped, err)
+		}
+
+		events, err := c.GetHistory("space1")
+		if err != nil || len(events) != 2 {
+			t.Fatalf("GetHistory, actual: %v, %v", events, err)
+		}
+		if events[0].Type != EventType_EVENT_DELIVERY_DEFERRED || events[1].Type != EventType_EVENT_DELIVERY_DROPPED {
+			t.Errorf("GetHistory types, actual: %v, %v", events[0].Type, events[1].Type)
+		}
+	})
+}
+
+func TestStoreOutboxDeleteSpace(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		if _, err := c.Claim("space1", SpaceNotificationStatus_SCHEDULE); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_SCHEDULE, "", []string{"tweet"}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		due, err := c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 1 {
+			t.Fatalf("GetDueDeliveries, actual: %v, %v", due, err)
+		}
+		if err := c.MarkDelivered(due[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		// 削除したスペースは配送済みの記録に関わらず再び告知する
+		if err := c.DeleteSpace("space1"); err != nil {
+			t.Fatal(err)
+		}
+		if claimed, err := c.Claim("space1", SpaceNotificationStatus_SCHEDULE); err != nil || !claimed {
+			t.Fatalf("Claim after delete, actual: %v, %v", claimed, err)
+		}
+		if err := c.EnqueueDeliveries("space1", SpaceNotificationStatus_SCHEDULE, "", []string{"tweet"}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		due, err = c.GetDueDeliveries(time.Now())
+		if err != nil || len(due) != 1 {
+			t.Errorf("GetDueDeliveries after delete, actual: %v, %v", due, err)
+		}
+	})
+}
+
+func TestStoreExportImport(t *testing.T) {
+	src, err := Open(filepath.Join(t.TempDir(), "src.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	now := time.Now()
+	if err := src.RegisterSchedule("space1", "user1", "user1", "title", now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// bolt から書き出した履歴を SQLite に 2 回取り込んでも重複しない
+	dst, err := OpenSQLite(filepath.Join(t.TempDir(), "dst.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	for i := 0; i < 2; i++ {
+		err := src.ForEachEvent(func(id string, e *Event) error {
+			return dst.ImportEvent(id, e)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	err = dst.ForEachEvent(func(id string, e *Event) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Errorf("ForEachEvent, actual: %v", ids)
+	}
+}
+
+func TestStoreParticipants(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		start := time.Date(2021, 10, 4, 21, 0, 0, 0, time.UTC)
+		if err := c.RegisterStart("space1", "user1", "user1", "title", start, start); err != nil {
+			t.Fatal(err)
+		}
+
+		// 同じ時刻の取得は 1 件にまとめる
+		samples := []struct {
+			minutes int
+			count   int64
+		}{
+			{0, 10},
+			{1, 40},
+			{1, 40},
+			{2, 25},
+		}
+		for _, sample := range samples {
+			at := start.Add(time.Duration(sample.minutes) * time.Minute)
+			if err := c.AddParticipantCount("space1", at, sample.count); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 終了を登録しても参加者数を引き継ぐ
+		if err := c.RegisterEnd("space1", "user1", "user1", "title", start, start); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := c.GetSpace("space1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := s.GetParticipants()
+		if len(p.GetCounts()) != 3 {
+			t.Fatalf("GetSpace participants, actual: %v", p)
+		}
+		if p.Peak() != 40 || p.Average() != 25 {
+			t.Errorf("Participants, actual: peak=%d average=%d", p.Peak(), p.Average())
+		}
+		if s.NotificationStatus != SpaceNotificationStatus_END || s.State != StateEnded {
+			t.Errorf("RegisterEnd, actual: %v %s", s.NotificationStatus, s.State)
+		}
+	})
+}
+
+func TestStoreMilestone(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		now := time.Now()
+		if err := c.RegisterStart("space1", "user1", "user1", "title", now, now); err != nil {
+			t.Fatal(err)
+		}
+
+		// 節目はスペースごとに 1 度だけ確保できる
+		for i, expected := range []bool{true, false} {
+			claimed, err := c.ClaimMilestone("space1", 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed != expected {
+				t.Errorf("ClaimMilestone[%d], actual: %v, expected: %v", i, claimed, expected)
+			}
+		}
+
+		// 終了を登録しても通知済みの節目を引き継ぐ
+		if err := c.RegisterEnd("space1", "user1", "user1", "title", now, now); err != nil {
+			t.Fatal(err)
+		}
+		s, err := c.GetSpace("space1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Milestones) != 1 || s.Milestones[0] != 1000 {
+			t.Errorf("GetSpace milestones, actual: %v", s.Milestones)
+		}
+
+		claimed, err := c.ClaimMilestone("space2", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !claimed {
+			t.Error("ClaimMilestone other space, expected: true")
+		}
+	})
+}
+
+func TestStoreJoined(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		// 記録のないスペースでもユーザーごとに 1 度だけ確保できる
+		cases := []struct {
+			userID   string
+			expected bool
+		}{
+			{"user1", true},
+			{"user2", true},
+			{"user1", false},
+		}
+		for i, cs := range cases {
+			claimed, err := c.ClaimJoined("space1", cs.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed != cs.expected {
+				t.Errorf("ClaimJoined[%d], actual: %v, expected: %v", i, claimed, cs.expected)
+			}
+		}
+
+		s, err := c.GetSpace("space1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.GetJoinedUserIds()) != 2 {
+			t.Errorf("GetSpace joined, actual: %v", s.GetJoinedUserIds())
+		}
+
+		// 確保だけで作成された状態のない記録は終了の確認対象にしない
+		active, err := c.GetActiveSpaces()
+		if err != nil || len(active) != 0 {
+			t.Errorf("GetActiveSpaces before state, actual: %v, %v", active, err)
+		}
+		if err := c.SetState("space1", StateLive); err != nil {
+			t.Fatal(err)
+		}
+		active, err = c.GetActiveSpaces()
+		if err != nil || len(active) != 1 {
+			t.Errorf("GetActiveSpaces, actual: %v, %v", active, err)
+		}
+	})
+}
+
+func TestStoreWatchedSpaces(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c Store) {
+		cases := []struct {
+			spaceID  string
+			expected bool
+		}{
+			{"space1", true},
+			{"space2", true},
+			{"space1", false},
+		}
+		for i, cs := range cases {
+			added, err := c.AddWatchedSpace(cs.spaceID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added != cs.expected {
+				t.Errorf("AddWatchedSpace[%d], actual: %v, expected: %v", i, added, cs.expected)
+			}
+		}
+
+		if err := c.RemoveWatchedSpace("space1"); err != nil {
+			t.Fatal(err)
+		}
+
+		watched, err := c.GetWatchedSpaces()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(watched) != 1 || watched[0].SpaceId != "space2" || watched[0].AddedAt == nil {
+			t.Errorf("GetWatchedSpaces, actual: %v", watched)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package db
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// AddWatchedSpace は手動で監視するスペースを登録し、新たに登録したかを返す
+func (c *Client) AddWatchedSpace(spaceID string) (bool, error) {
+	added := false
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketWatched))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketWatched)
+		}
+		if b.Get([]byte(spaceID)) != nil {
+			return nil
+		}
+
+		data, err := proto.Marshal(newWatchedSpace(spaceID, time.Now()))
+		if err != nil {
+			return err
+		}
+		added = true
+		return b.Put([]byte(spaceID), data)
+	})
+	return added, err
+}
+
+// GetWatchedSpaces は手動で監視するスペースを登録順に返す
+func (c *Client) GetWatchedSpaces() ([]*WatchedSpace, error) {
+	var watched []*WatchedSpace
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketWatched))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketWatched)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var w WatchedSpace
+			if err := proto.Unmarshal(v, &w); err != nil {
+				return err
+			}
+			watched = append(watched, &w)
+			return nil
+		})
+	})
+	sortWatchedSpaces(watched)
+	return watched, err
+}
+
+func (c *Client) RemoveWatchedSpace(spaceID string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketWatched))
+		if b == nil {
+			return errors.New("bucket not found: " + bucketWatched)
+		}
+		return b.Delete([]byte(spaceID))
+	})
+}
+
+func newWatchedSpace(spaceID string, now time.Time) *WatchedSpace {
+	return &WatchedSpace{
+		SpaceId: spaceID,
+		AddedAt: timestamppb.New(now),
+	}
+}
+
+func sortWatchedSpaces(watched []*WatchedSpace) {
+	sort.SliceStable(watched, func(i, j int) bool {
+		a, b := watched[i].AddedAt.AsTime(), watched[j].AddedAt.AsTime()
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return watched[i].SpaceId < watched[j].SpaceId
+	})
+}
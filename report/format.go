@@ -0,0 +1,170 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/qitoi/space-watcher/bot"
+)
+
+const (
+	FormatTable    = "table"
+	FormatJSON     = "json"
+	FormatTemplate = "template"
+)
+
+const dateFormat = "2006-01-02 15:04"
+
+// WriteTable はレポートを表形式で書き出す
+func (r *Report) WriteTable(w io.Writer, loc *time.Location) error {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	fmt.Fprintf(w, "Period: %s - %s\n", r.From.In(loc).Format(dateFormat), r.To.In(loc).Format(dateFormat))
+	fmt.Fprintf(w, "Spaces: %d (scheduled: %d, ad-hoc: %d, %.1f%% scheduled)\n\n", r.Spaces, r.Scheduled, r.AdHoc, r.ScheduledRatio()*100)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSPACES\tSCHEDULED\tAD-HOC\tTOTAL\tAVERAGE\tSTART HOUR\tPEAK LISTENERS\tAVG LISTENERS")
+	for _, h := range r.Hosts {
+		fmt.Fprintf(tw, "@%s\t%d\t%d\t%d\t%s\t%s\t%s\t%d\t%d\n",
+			h.ScreenName, h.Spaces, h.Scheduled, h.AdHoc, h.TotalDuration(), h.AverageDuration(), formatHour(h.StartHour),
+			h.PeakParticipants, h.AverageParticipants)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON はレポートを JSON で書き出す
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Render はレポートをテンプレートで文字列にする
+// テンプレートでは通知メッセージと同じ escape, truncate と時刻の表示用の hour が使用できる
+func Render(message string, r *Report, loc *time.Location) (string, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	t, err := newTemplate(message)
+	if err != nil {
+		return "", err
+	}
+
+	sb := &strings.Builder{}
+	err = t.Execute(sb, struct {
+		*Report
+		From time.Time
+		To   time.Time
+	}{
+		Report: r,
+		From:   r.From.In(loc),
+		To:     r.To.In(loc),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+// RenderTweet はレポートをテンプレートで展開し、ツイートの上限を超える場合は下位のホストから省略する
+func RenderTweet(message string, r *Report, loc *time.Location) (string, error) {
+	text, err := Render(message, r, loc)
+	if err != nil {
+		return "", err
+	}
+
+	length := bot.TweetLength(text)
+	if length <= bot.MaxTweetLength {
+		return text, nil
+	}
+
+	shortened := *r
+	for n := len(r.Hosts) - 1; n >= 0; n-- {
+		shortened.Hosts = r.Hosts[:n]
+		t, err := Render(message, &shortened, loc)
+		if err != nil {
+			return "", err
+		}
+		if bot.TweetLength(t) <= bot.MaxTweetLength {
+			return t, nil
+		}
+	}
+
+	return "", &bot.TooLongError{Length: length}
+}
+
+// CheckTweetTemplate はホストを省略してもツイートの上限に収まらないテンプレートをエラーにする
+func CheckTweetTemplate(message string) error {
+	text, err := Render(message, sampleReport(), time.UTC)
+	if err != nil {
+		return err
+	}
+	if length := bot.TweetLength(text); length > bot.MaxTweetLength {
+		return &bot.TooLongError{Length: length}
+	}
+	return nil
+}
+
+// sampleReport はテンプレートの確認に使用する、ホストを省略して件数が最大の桁数の Report を返す
+func sampleReport() *Report {
+	now := time.Now()
+	return &Report{
+		From:      now,
+		To:        now,
+		Spaces:    9999,
+		Scheduled: 9999,
+		AdHoc:     9999,
+		Hosts:     []Host{},
+	}
+}
+
+// CheckTemplate はレポートのテンプレートを解析できるか確認する
+func CheckTemplate(message string) error {
+	_, err := newTemplate(message)
+	return err
+}
+
+func newTemplate(message string) (*template.Template, error) {
+	return template.New("report").
+		Funcs(map[string]interface{}{
+			"escape":   bot.EscapeMessage,
+			"truncate": bot.Truncate,
+			"hour":     formatHour,
+			"percent": func(ratio float64) string {
+				return fmt.Sprintf("%.0f%%", ratio*100)
+			},
+		}).
+		Parse(message)
+}
+
+func formatHour(hour int) string {
+	if hour < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%02d:00", hour)
+}
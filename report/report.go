@@ -0,0 +1,266 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package report
+
+import (
+	"sort"
+	"time"
+
+	"github.com/qitoi/space-watcher/db"
+)
+
+const (
+	DefaultTop = 10
+)
+
+type Options struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location
+	Top      int
+}
+
+// Report は期間中に開始したスペースの集計
+type Report struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Spaces    int       `json:"spaces"`
+	Scheduled int       `json:"scheduled"`
+	AdHoc     int       `json:"ad_hoc"`
+	Hosts     []Host    `json:"hosts"`
+}
+
+// Host はホストごとの集計
+// 開始時刻の時は集計できない場合 -1 になる
+// 参加者数は記録のあるスペースの最大値と、スペースごとの平均の平均
+type Host struct {
+	CreatorID      string `json:"creator_id"`
+	ScreenName     string `json:"screen_name"`
+	Spaces         int    `json:"spaces"`
+	Scheduled      int    `json:"scheduled"`
+	AdHoc          int    `json:"ad_hoc"`
+	Measured       int    `json:"measured"`
+	TotalSeconds   int64  `json:"total_seconds"`
+	AverageSeconds int64  `json:"average_seconds"`
+	StartHour      int    `json:"start_hour"`
+
+	PeakParticipants    int64 `json:"peak_participants"`
+	AverageParticipants int64 `json:"average_participants"`
+}
+
+// ScheduledRatio は予約されていたスペースの割合を返す
+func (r *Report) ScheduledRatio() float64 {
+	if r.Spaces == 0 {
+		return 0
+	}
+	return float64(r.Scheduled) / float64(r.Spaces)
+}
+
+func (h Host) TotalDuration() time.Duration {
+	return time.Duration(h.TotalSeconds) * time.Second
+}
+
+func (h Host) AverageDuration() time.Duration {
+	return time.Duration(h.AverageSeconds) * time.Second
+}
+
+func (h Host) ScheduledRatio() float64 {
+	if h.Spaces == 0 {
+		return 0
+	}
+	return float64(h.Scheduled) / float64(h.Spaces)
+}
+
+// spaceSummary は 1 つのスペースの履歴から読み取った内容
+type spaceSummary struct {
+	creatorID  string
+	screenName string
+	scheduled  bool
+	startedAt  time.Time
+	endedAt    time.Time
+
+	participants *db.Participants
+}
+
+// Build は期間中に履歴のあるスペースのうち、期間中に開始したものを集計する
+func Build(store db.Store, opts Options) (*Report, error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = DefaultTop
+	}
+
+	events, err := store.QueryHistory(db.HistoryQuery{From: opts.From, To: opts.To})
+	if err != nil {
+		return nil, err
+	}
+
+	var spaceIDs []string
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if !seen[e.SpaceId] {
+			seen[e.SpaceId] = true
+			spaceIDs = append(spaceIDs, e.SpaceId)
+		}
+	}
+
+	var summaries []*spaceSummary
+	for _, id := range spaceIDs {
+		// 期間より前の予約の記録も含めて判定する
+		history, err := store.GetHistory(id)
+		if err != nil {
+			return nil, err
+		}
+		s := summarize(history)
+		if s.startedAt.IsZero() || s.startedAt.Before(opts.From) || (!opts.To.IsZero() && !s.startedAt.Before(opts.To)) {
+			continue
+		}
+
+		record, err := store.GetSpace(id)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil && len(record.GetParticipants().GetCounts()) > 0 {
+			s.participants = record.Participants
+		}
+
+		summaries = append(summaries, s)
+	}
+
+	return aggregate(summaries, opts.From, opts.To, loc, top), nil
+}
+
+func summarize(history []*db.Event) *spaceSummary {
+	s := &spaceSummary{}
+	for _, e := range history {
+		if e.CreatorId != "" {
+			s.creatorID = e.CreatorId
+		}
+		if e.ScreenName != "" {
+			s.screenName = e.ScreenName
+		}
+		if e.State == db.StateScheduled || e.ScheduledStart != nil {
+			s.scheduled = true
+		}
+		if s.startedAt.IsZero() && e.StartedAt != nil {
+			s.startedAt = e.StartedAt.AsTime()
+		}
+		if s.endedAt.IsZero() && e.Type == db.EventType_EVENT_OBSERVED && e.State == db.StateEnded && e.Timestamp != nil {
+			s.endedAt = e.Timestamp.AsTime()
+		}
+	}
+	return s
+}
+
+func aggregate(summaries []*spaceSummary, from, to time.Time, loc *time.Location, top int) *Report {
+	r := &Report{
+		From:  from,
+		To:    to,
+		Hosts: []Host{},
+	}
+
+	hosts := make(map[string]*Host)
+	hours := make(map[string][]int)
+	averages := make(map[string][]int64)
+	var order []string
+	for _, s := range summaries {
+		h, ok := hosts[s.creatorID]
+		if !ok {
+			h = &Host{CreatorID: s.creatorID}
+			hosts[s.creatorID] = h
+			order = append(order, s.creatorID)
+		}
+		if s.screenName != "" {
+			h.ScreenName = s.screenName
+		}
+
+		r.Spaces++
+		h.Spaces++
+		if s.scheduled {
+			r.Scheduled++
+			h.Scheduled++
+		} else {
+			r.AdHoc++
+			h.AdHoc++
+		}
+
+		if !s.endedAt.IsZero() && s.endedAt.After(s.startedAt) {
+			h.Measured++
+			h.TotalSeconds += int64(s.endedAt.Sub(s.startedAt) / time.Second)
+		}
+
+		if p := s.participants; p != nil {
+			if peak := p.Peak(); peak > h.PeakParticipants {
+				h.PeakParticipants = peak
+			}
+			averages[s.creatorID] = append(averages[s.creatorID], p.Average())
+		}
+
+		if hours[s.creatorID] == nil {
+			hours[s.creatorID] = make([]int, 24)
+		}
+		hours[s.creatorID][s.startedAt.In(loc).Hour()]++
+	}
+
+	for _, id := range order {
+		h := hosts[id]
+		if h.Measured > 0 {
+			h.AverageSeconds = h.TotalSeconds / int64(h.Measured)
+		}
+		h.StartHour = usualHour(hours[id])
+		if n := int64(len(averages[id])); n > 0 {
+			var sum int64
+			for _, a := range averages[id] {
+				sum += a
+			}
+			h.AverageParticipants = (sum + n/2) / n
+		}
+		r.Hosts = append(r.Hosts, *h)
+	}
+
+	sort.SliceStable(r.Hosts, func(i, j int) bool {
+		a, b := &r.Hosts[i], &r.Hosts[j]
+		if a.Spaces != b.Spaces {
+			return a.Spaces > b.Spaces
+		}
+		if a.TotalSeconds != b.TotalSeconds {
+			return a.TotalSeconds > b.TotalSeconds
+		}
+		return a.ScreenName < b.ScreenName
+	})
+	if len(r.Hosts) > top {
+		r.Hosts = r.Hosts[:top]
+	}
+
+	return r
+}
+
+// usualHour は最も多く開始した時を返す (同数の場合は早い時刻)
+func usualHour(counts []int) int {
+	hour := -1
+	max := 0
+	for h, n := range counts {
+		if n > max {
+			hour = h
+			max = n
+		}
+	}
+	return hour
+}
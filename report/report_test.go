@@ -0,0 +1,157 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/qitoi/space-watcher/bot"
+	"github.com/qitoi/space-watcher/db"
+)
+
+func TestBuild(t *testing.T) {
+	store := db.NewMemoryClient()
+	base := time.Date(2021, 10, 4, 0, 0, 0, 0, time.UTC)
+
+	observe := func(spaceID, creatorID, state string, at time.Time, startedAt *time.Time) {
+		e := &db.Event{
+			SpaceId:    spaceID,
+			CreatorId:  creatorID,
+			ScreenName: creatorID,
+			State:      state,
+			Timestamp:  timestamppb.New(at),
+		}
+		if startedAt != nil {
+			e.StartedAt = timestamppb.New(*startedAt)
+		}
+		if err := store.RecordObservation(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	space := func(spaceID, creatorID string, scheduled bool, start time.Time, length time.Duration) {
+		if scheduled {
+			observe(spaceID, creatorID, db.StateScheduled, start.Add(-24*time.Hour), nil)
+		}
+		observe(spaceID, creatorID, db.StateLive, start, &start)
+		observe(spaceID, creatorID, db.StateEnded, start.Add(length), &start)
+	}
+
+	space("space1", "user1", true, base.Add(21*time.Hour), time.Hour)
+	space("space2", "user1", false, base.Add(24*time.Hour+21*time.Hour), 30*time.Minute)
+	space("space3", "user1", true, base.Add(48*time.Hour+9*time.Hour), 90*time.Minute)
+	space("space4", "user2", false, base.Add(12*time.Hour), 2*time.Hour)
+	// 期間外に開始したスペースは集計しない
+	space("space5", "user2", true, base.Add(-time.Hour), time.Hour)
+
+	// 参加者数は記録のあるスペースのみ集計する
+	for i, count := range []int64{10, 40, 20} {
+		if err := store.AddParticipantCount("space1", base.Add(21*time.Hour+time.Duration(i)*time.Minute), count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.AddParticipantCount("space3", base.Add(57*time.Hour), 50); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Build(store, Options{From: base, To: base.Add(7 * 24 * time.Hour), Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Spaces != 4 || r.Scheduled != 2 || r.AdHoc != 2 {
+		t.Errorf("Build, actual: spaces=%d scheduled=%d ad-hoc=%d", r.Spaces, r.Scheduled, r.AdHoc)
+	}
+	if len(r.Hosts) != 2 {
+		t.Fatalf("Build hosts, actual: %d, expected: 2", len(r.Hosts))
+	}
+
+	h := r.Hosts[0]
+	if h.CreatorID != "user1" || h.Spaces != 3 || h.Scheduled != 2 || h.AdHoc != 1 {
+		t.Errorf("Build host, actual: %+v", h)
+	}
+	if h.TotalDuration() != 3*time.Hour || h.AverageDuration() != time.Hour {
+		t.Errorf("Build host duration, actual: %v, %v", h.TotalDuration(), h.AverageDuration())
+	}
+	if h.StartHour != 21 {
+		t.Errorf("Build host start hour, actual: %d, expected: 21", h.StartHour)
+	}
+	if h.PeakParticipants != 50 || h.AverageParticipants != 37 {
+		t.Errorf("Build host participants, actual: peak=%d average=%d", h.PeakParticipants, h.AverageParticipants)
+	}
+	if h := r.Hosts[1]; h.PeakParticipants != 0 || h.AverageParticipants != 0 {
+		t.Errorf("Build host participants, actual: peak=%d average=%d", h.PeakParticipants, h.AverageParticipants)
+	}
+
+	r, err = Build(store, Options{From: base, To: base.Add(7 * 24 * time.Hour), Location: time.UTC, Top: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Hosts) != 1 {
+		t.Errorf("Build top, actual: %d, expected: 1", len(r.Hosts))
+	}
+
+	actual, err := Render(`{{range .Hosts}}{{printf "@%s" .ScreenName | escape}} {{.Spaces}} {{hour .StartHour}} {{.AverageDuration}}{{end}} {{percent .ScheduledRatio}}`, r, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "@.user1 3 21:00 1h0m0s 50%"
+	if actual != expected {
+		t.Errorf("Render, actual: %s, expected: %s", actual, expected)
+	}
+
+	sb := &strings.Builder{}
+	if err := r.WriteTable(sb, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "@user1") {
+		t.Errorf("WriteTable, actual: %s", sb.String())
+	}
+}
+
+func TestRenderTweet(t *testing.T) {
+	r := &Report{Spaces: 20}
+	for i := 0; i < 20; i++ {
+		r.Hosts = append(r.Hosts, Host{ScreenName: strings.Repeat("a", 15), Spaces: 1})
+	}
+
+	// 上限を超える場合は下位のホストを省略する
+	message := `{{.Spaces}} 件{{range .Hosts}} @{{.ScreenName}} {{.Spaces}} 件{{end}}`
+	actual, err := RenderTweet(message, r, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(actual, "@"); n == 0 || n >= len(r.Hosts) || bot.TweetLength(actual) > bot.MaxTweetLength {
+		t.Errorf("RenderTweet, actual: %s", actual)
+	}
+
+	if _, err := RenderTweet(strings.Repeat("a", 281), r, time.UTC); err == nil {
+		t.Error("RenderTweet, expected error")
+	}
+}
+
+func TestCheckTweetTemplate(t *testing.T) {
+	if err := CheckTweetTemplate(`{{.Spaces}} 件 {{percent .ScheduledRatio}}{{range .Hosts}} @{{.ScreenName}}{{end}}`); err != nil {
+		t.Error(err)
+	}
+	if err := CheckTweetTemplate(strings.Repeat("a", 278) + "{{.Spaces}}"); err == nil {
+		t.Error("CheckTweetTemplate, expected error")
+	}
+}
@@ -0,0 +1,575 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Env は条件式から参照する変数
+// 値は string, bool, 整数, []string のいずれか
+type Env map[string]interface{}
+
+// Expr はコンパイル済みの条件式
+//
+// 使用できる式:
+//
+//	リテラル   "文字列", 123, true, false, ["a", "b"]
+//	比較       == != < <= > >=
+//	正規表現   =~ !~ (右辺は文字列のリテラル)
+//	包含       in (右辺はリスト)
+//	論理       && || !
+//
+// 関数呼び出しや代入はできず、評価は必ず終了する
+type Expr struct {
+	src  string
+	root node
+	vars []string
+}
+
+// Compile は条件式をコンパイルする
+// 空の条件式は常に一致する
+func Compile(src string) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return &Expr{src: src, root: literal{true}}, nil
+	}
+
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, vars: make(map[string]bool)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+
+	vars := make([]string, 0, len(p.vars))
+	for v := range p.vars {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+
+	return &Expr{src: src, root: root, vars: vars}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars は条件式が参照する変数の名前を返す
+func (e *Expr) Vars() []string {
+	return e.vars
+}
+
+// Match は条件式を評価する
+func (e *Expr) Match(env Env) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("result is not bool: %v", v)
+	}
+	return b, nil
+}
+
+// MayMatch は env にない変数を未知の値として、条件式に一致する可能性があるかを返す
+// 結果が未知の値に依存する場合は true を返す
+func (e *Expr) MayMatch(env Env) bool {
+	v, known := mayEval(e.root, env)
+	return !known || v
+}
+
+// token
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+loop:
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '`':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if c == '"' && src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+			}
+			tokens = append(tokens, token{tokenString, s, i})
+			i = j + 1
+		case '0' <= c && c <= '9':
+			j := i
+			for j < len(src) && '0' <= src[j] && src[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, src[i:j], i})
+			i = j
+		case c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+			j := i
+			for j < len(src) && (src[j] == '_' || ('a' <= src[j] && src[j] <= 'z') || ('A' <= src[j] && src[j] <= 'Z') || ('0' <= src[j] && src[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, src[i:j], i})
+			i = j
+		default:
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{tokenOp, op, i})
+					i += len(op)
+					continue loop
+				}
+			}
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(src)}), nil
+}
+
+// parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	vars   map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(tokenOp, text) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d", text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenOp, "||") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = logical{op: "||", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenOp, "&&") {
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = logical{op: "&&", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept(tokenOp, "!") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		p.next()
+		y, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return compare{op: t.text, x: x, y: y}, nil
+	case t.kind == tokenOp && (t.text == "=~" || t.text == "!~"):
+		p.next()
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return nil, fmt.Errorf("expected string literal at %d", pattern.pos)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp at %d: %w", pattern.pos, err)
+		}
+		return match{negate: t.text == "!~", x: x, re: re}, nil
+	case t.kind == tokenIdent && t.text == "in":
+		p.next()
+		y, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return in{x: x, y: y}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return literal{t.text}, nil
+	case tokenNumber:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number at %d: %w", t.pos, err)
+		}
+		return literal{n}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "in":
+			return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+		}
+		p.vars[t.text] = true
+		return variable{t.text}, nil
+	case tokenOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		case "[":
+			var items []node
+			if p.accept(tokenOp, "]") {
+				return list{items}, nil
+			}
+			for {
+				x, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, x)
+				if p.accept(tokenOp, "]") {
+					return list{items}, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// node
+
+type node interface {
+	eval(env Env) (interface{}, error)
+}
+
+type literal struct {
+	v interface{}
+}
+
+func (n literal) eval(Env) (interface{}, error) {
+	return n.v, nil
+}
+
+type variable struct {
+	name string
+}
+
+func (n variable) eval(env Env) (interface{}, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable: %s", n.name)
+	}
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return items, nil
+	}
+	return v, nil
+}
+
+type list struct {
+	items []node
+}
+
+func (n list) eval(env Env) (interface{}, error) {
+	items := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+type not struct {
+	x node
+}
+
+func (n not) eval(env Env) (interface{}, error) {
+	b, err := evalBool(n.x, env)
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+type logical struct {
+	op   string
+	x, y node
+}
+
+func (n logical) eval(env Env) (interface{}, error) {
+	x, err := evalBool(n.x, env)
+	if err != nil {
+		return nil, err
+	}
+	if (n.op == "&&" && !x) || (n.op == "||" && x) {
+		return x, nil
+	}
+	return evalBool(n.y, env)
+}
+
+type compare struct {
+	op   string
+	x, y node
+}
+
+func (n compare) eval(env Env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		eq, err := equal(x, y)
+		if err != nil {
+			return nil, err
+		}
+		return eq == (n.op == "=="), nil
+	}
+
+	a, ok1 := x.(int64)
+	b, ok2 := y.(int64)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("%s requires numbers: %v, %v", n.op, x, y)
+	}
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	default:
+		return a >= b, nil
+	}
+}
+
+type match struct {
+	negate bool
+	x      node
+	re     *regexp.Regexp
+}
+
+func (n match) eval(env Env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := x.(string)
+	if !ok {
+		return nil, fmt.Errorf("regexp match requires string: %v", x)
+	}
+	return n.re.MatchString(s) != n.negate, nil
+}
+
+type in struct {
+	x, y node
+}
+
+func (n in) eval(env Env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := y.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("in requires list: %v", y)
+	}
+	for _, item := range items {
+		eq, err := equal(x, item)
+		if err != nil {
+			return nil, err
+		}
+		if eq {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func evalBool(n node, env Env) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("not bool: %v", v)
+	}
+	return b, nil
+}
+
+// mayEval は未知の値を含む条件式を三値論理で評価する
+// known が false の場合は結果が未知の値に依存する
+func mayEval(n node, env Env) (v bool, known bool) {
+	switch n := n.(type) {
+	case not:
+		v, known := mayEval(n.x, env)
+		return !v, known
+	case logical:
+		// 片方の値で結果が決まる場合は、もう片方が未知でもよい
+		short := func(b bool) bool {
+			return (n.op == "&&" && !b) || (n.op == "||" && b)
+		}
+		x, xKnown := mayEval(n.x, env)
+		if xKnown && short(x) {
+			return x, true
+		}
+		y, yKnown := mayEval(n.y, env)
+		if yKnown && short(y) {
+			return y, true
+		}
+		return y, xKnown && yKnown
+	}
+	b, err := evalBool(n, env)
+	return b, err == nil
+}
+
+// equal は同じ型の値を比較する
+func equal(x, y interface{}) (bool, error) {
+	switch a := x.(type) {
+	case string:
+		if b, ok := y.(string); ok {
+			return a == b, nil
+		}
+	case int64:
+		if b, ok := y.(int64); ok {
+			return a == b, nil
+		}
+	case bool:
+		if b, ok := y.(bool); ok {
+			return a == b, nil
+		}
+	}
+	return false, fmt.Errorf("cannot compare %v and %v", x, y)
+}
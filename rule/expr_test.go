@@ -0,0 +1,145 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package rule
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	env := Env{
+		"event":        "start",
+		"creator":      "alice",
+		"title":        "#release 告知",
+		"participants": 120,
+		"is_ticketed":  false,
+		"tags":         []string{"a", "b"},
+	}
+
+	cases := []struct {
+		src      string
+		expected bool
+	}{
+		{``, true},
+		{`event == "start"`, true},
+		{`event != "start"`, false},
+		{`creator in ["bob", "alice"]`, true},
+		{`"b" in tags`, true},
+		{`title =~ "^#release"`, true},
+		{`title !~ "(?i)TEST"`, true},
+		{`participants >= 100 && participants < 1000`, true},
+		{`participants > 120 || is_ticketed`, false},
+		{`!(event == "end") && !is_ticketed`, true},
+		{`event == "schedule" || event == "start" && creator == "bob"`, false},
+		{"title =~ `^#rel`", true},
+	}
+	for _, c := range cases {
+		expr, err := Compile(c.src)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", c.src, err)
+			continue
+		}
+		actual, err := expr.Match(env)
+		if err != nil {
+			t.Errorf("Match(%s): %v", c.src, err)
+			continue
+		}
+		if actual != c.expected {
+			t.Errorf("Match(%s), actual: %v, expected: %v", c.src, actual, c.expected)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	cases := []string{
+		`event ==`,
+		`event == "start`,
+		`(event == "start"`,
+		`title =~ creator`,
+		`title =~ "("`,
+		`event = "start"`,
+		`["a", "b"`,
+		`event == "start" creator`,
+	}
+	for _, src := range cases {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Compile(%s), expected error", src)
+		}
+	}
+}
+
+func TestMatchError(t *testing.T) {
+	env := Env{"title": "title", "participants": 10}
+
+	cases := []string{
+		`unknown == "a"`,
+		`title == 1`,
+		`title > 1`,
+		`participants =~ "1"`,
+		`title in "title"`,
+		`title`,
+	}
+	for _, src := range cases {
+		expr, err := Compile(src)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", src, err)
+			continue
+		}
+		if _, err := expr.Match(env); err == nil {
+			t.Errorf("Match(%s), expected error", src)
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	expr, err := Compile(`creator in ["a"] && (title =~ "x" || creator == "b") && participants > 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"creator", "participants", "title"}
+	if actual := expr.Vars(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Vars, actual: %v, expected: %v", actual, expected)
+	}
+}
+
+func TestMayMatch(t *testing.T) {
+	cases := []struct {
+		src      string
+		env      Env
+		expected bool
+	}{
+		{``, Env{"event": "start"}, true},
+		{`event == "start"`, Env{"event": "start"}, true},
+		{`event == "start"`, Env{"event": "end"}, false},
+		{`event == "start" && creator == "alice"`, Env{"event": "start"}, true},
+		{`event == "start" && creator == "alice"`, Env{"event": "end"}, false},
+		{`creator == "alice" && event in ["start", "end"]`, Env{"event": "schedule"}, false},
+		{`event == "start" || creator == "alice"`, Env{"event": "end"}, true},
+		{`!(event == "start")`, Env{"event": "start"}, false},
+		{`!(creator == "alice")`, Env{"event": "start"}, true},
+	}
+	for _, c := range cases {
+		expr, err := Compile(c.src)
+		if err != nil {
+			t.Fatalf("Compile(%s): %v", c.src, err)
+		}
+		if actual := expr.MayMatch(c.env); actual != c.expected {
+			t.Errorf("MayMatch(%s, %v), actual: %v, expected: %v", c.src, c.env, actual, c.expected)
+		}
+	}
+}
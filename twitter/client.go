@@ -31,7 +31,8 @@ const (
 )
 
 type Client struct {
-	bearer string
+	bearer  string
+	baseURL string
 }
 
 type RateLimit struct {
@@ -41,8 +42,28 @@ type RateLimit struct {
 }
 
 type Errors []struct {
-	Message string `json:"message"`
-	Code    int    `json:"code"`
+	Message      string `json:"message"`
+	Code         int    `json:"code"`
+	Title        string `json:"title,omitempty"`
+	Detail       string `json:"detail,omitempty"`
+	Type         string `json:"type,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+	ResourceID   string `json:"resource_id,omitempty"`
+}
+
+// ProblemResourceNotFound は存在しないリソースを示すエラーの種類
+const ProblemResourceNotFound = "https://api.twitter.com/2/problems/resource-not-found"
+
+// NotFoundIDs は存在しないリソースの ID を返す
+// 権限がないなど、他の種類のエラーは含まない
+func (e Errors) NotFoundIDs() map[string]bool {
+	ids := make(map[string]bool)
+	for _, err := range e {
+		if err.Type == ProblemResourceNotFound && err.ResourceID != "" {
+			ids[err.ResourceID] = true
+		}
+	}
+	return ids
 }
 
 type APIError struct {
@@ -56,13 +77,19 @@ func (e *APIError) Error() string {
 }
 
 func NewClient(bearer string) *Client {
+	return NewClientWithURL(bearer, twitterAPIv2)
+}
+
+// NewClientWithURL は API の URL を指定してクライアントを作成する
+func NewClientWithURL(bearer, baseURL string) *Client {
 	return &Client{
-		bearer: bearer,
+		bearer:  bearer,
+		baseURL: baseURL,
 	}
 }
 
 func (c *Client) Get(ctx context.Context, api string, params map[string]string, out interface{}) (*RateLimit, error) {
-	req, err := http.NewRequest("GET", twitterAPIv2+api, nil)
+	req, err := http.NewRequest("GET", c.baseURL+api, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +107,7 @@ func (c *Client) Get(ctx context.Context, api string, params map[string]string,
 }
 
 func (c *Client) Post(ctx context.Context, api string, params map[string]string, out interface{}) (*RateLimit, error) {
-	req, err := http.NewRequest("POST", twitterAPIv2+api, nil)
+	req, err := http.NewRequest("POST", c.baseURL+api, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,224 @@
+/*
+ *  Copyright 2021 qitoi
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ */
+
+package twitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	twitterAPIMediaUpload         = "https://upload.twitter.com/1.1/media/upload.json"
+	twitterAPIMediaMetadataCreate = "https://upload.twitter.com/1.1/media/metadata/create.json"
+
+	mediaChunkSize = 1024 * 1024
+)
+
+// MediaClient は v1.1 の media/upload を扱うクライアント
+// httpClient はユーザーコンテキストの OAuth1 認証済みであること
+type MediaClient struct {
+	httpClient *http.Client
+}
+
+type mediaUploadResponse struct {
+	MediaID        int64  `json:"media_id"`
+	MediaIDString  string `json:"media_id_string"`
+	ProcessingInfo *struct {
+		State          string `json:"state"`
+		CheckAfterSecs int    `json:"check_after_secs"`
+		Error          *struct {
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
+	} `json:"processing_info,omitempty"`
+}
+
+func NewMediaClient(httpClient *http.Client) *MediaClient {
+	return &MediaClient{
+		httpClient: httpClient,
+	}
+}
+
+// Upload は INIT / APPEND / FINALIZE の分割アップロードを行い、メディア ID を返す
+func (c *MediaClient) Upload(ctx context.Context, data []byte, mediaType, mediaCategory string) (int64, error) {
+	params := url.Values{}
+	params.Set("command", "INIT")
+	params.Set("total_bytes", strconv.Itoa(len(data)))
+	params.Set("media_type", mediaType)
+	if mediaCategory != "" {
+		params.Set("media_category", mediaCategory)
+	}
+
+	var init mediaUploadResponse
+	if err := c.postForm(ctx, params, &init); err != nil {
+		return 0, err
+	}
+	mediaID := strconv.FormatInt(init.MediaID, 10)
+
+	for i := 0; i*mediaChunkSize < len(data); i++ {
+		end := (i + 1) * mediaChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := c.append(ctx, mediaID, i, data[i*mediaChunkSize:end]); err != nil {
+			return 0, err
+		}
+	}
+
+	params = url.Values{}
+	params.Set("command", "FINALIZE")
+	params.Set("media_id", mediaID)
+
+	var finalize mediaUploadResponse
+	if err := c.postForm(ctx, params, &finalize); err != nil {
+		return 0, err
+	}
+
+	// サーバー側の処理が終わるまで待つ
+	info := finalize.ProcessingInfo
+	for info != nil && (info.State == "pending" || info.State == "in_progress") {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(time.Duration(info.CheckAfterSecs) * time.Second):
+		}
+
+		var status mediaUploadResponse
+		if err := c.status(ctx, mediaID, &status); err != nil {
+			return 0, err
+		}
+		info = status.ProcessingInfo
+	}
+	if info != nil && info.State == "failed" {
+		if info.Error != nil {
+			return 0, fmt.Errorf("media processing failed: %s", info.Error.Message)
+		}
+		return 0, fmt.Errorf("media processing failed")
+	}
+
+	return init.MediaID, nil
+}
+
+// CreateMetadata はメディアに代替テキストを設定する
+func (c *MediaClient) CreateMetadata(ctx context.Context, mediaID int64, altText string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"media_id": strconv.FormatInt(mediaID, 10),
+		"alt_text": map[string]string{
+			"text": altText,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", twitterAPIMediaMetadataCreate, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.do(req, nil)
+}
+
+func (c *MediaClient) append(ctx context.Context, mediaID string, index int, chunk []byte) error {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	if err := mw.WriteField("command", "APPEND"); err != nil {
+		return err
+	}
+	if err := mw.WriteField("media_id", mediaID); err != nil {
+		return err
+	}
+	if err := mw.WriteField("segment_index", strconv.Itoa(index)); err != nil {
+		return err
+	}
+	fw, err := mw.CreateFormFile("media", "media")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(chunk); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", twitterAPIMediaUpload, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return c.do(req, nil)
+}
+
+func (c *MediaClient) status(ctx context.Context, mediaID string, out interface{}) error {
+	params := url.Values{}
+	params.Set("command", "STATUS")
+	params.Set("media_id", mediaID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", twitterAPIMediaUpload+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, out)
+}
+
+func (c *MediaClient) postForm(ctx context.Context, params url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", twitterAPIMediaUpload, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(req, out)
+}
+
+func (c *MediaClient) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var body struct {
+			Errors Errors `json:"errors"`
+		}
+		b, _ := io.ReadAll(resp.Body)
+		_ = json.Unmarshal(b, &body)
+		return &APIError{
+			Errors:     body.Errors,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
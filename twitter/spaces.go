@@ -20,9 +20,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 )
 
+var spaceURLPattern = regexp.MustCompile(`^https?://(?:www\.|mobile\.)?twitter\.com/i/spaces/([0-9A-Za-z]+)`)
+
 type Space struct {
 	ID               string     `json:"id"`
 	CreatorID        string     `json:"creator_id"`
@@ -76,6 +79,100 @@ func (c *Client) GetSpacesByCreatorIDs(ctx context.Context, req SpacesByCreatorI
 	return &r, rate, nil
 }
 
+type SpacesByIDsRequest struct {
+	IDs         []string
+	Expansions  []string
+	SpaceFields []string
+	UserFields  []string
+}
+
+type SpacesByIDsResponse struct {
+	Data     []Space `json:"data"`
+	Includes *struct {
+		Users *[]User `json:"users,omitempty"`
+	} `json:"includes,omitempty"`
+	Errors Errors `json:"-"`
+}
+
+func (c *Client) GetSpacesByIDs(ctx context.Context, req SpacesByIDsRequest) (*SpacesByIDsResponse, *RateLimit, error) {
+	if len(req.IDs) == 0 {
+		return nil, nil, errors.New("invalid parameter")
+	}
+
+	params := make(map[string]string)
+
+	setRequestParam(params, "ids", req.IDs)
+	setRequestParam(params, "expansions", req.Expansions)
+	setRequestParam(params, "space.fields", req.SpaceFields)
+	setRequestParam(params, "user.fields", req.UserFields)
+
+	var r SpacesByIDsResponse
+	rate, err := c.Get(ctx, "spaces", params, &r)
+
+	// 存在しないスペースはステータス 200 で errors に含まれる
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode/100 == 2 {
+		r.Errors = apiErr.Errors
+		err = nil
+	}
+
+	if err != nil {
+		return nil, rate, err
+	}
+
+	return &r, rate, nil
+}
+
+type SpacesSearchRequest struct {
+	Query       string
+	State       string
+	Expansions  []string
+	SpaceFields []string
+	UserFields  []string
+}
+
+type SpacesSearchResponse struct {
+	Data     []Space `json:"data"`
+	Includes *struct {
+		Users *[]User `json:"users,omitempty"`
+	} `json:"includes,omitempty"`
+}
+
+// SearchSpaces はタイトルが query に一致するスペースを検索する
+// state は live, scheduled, all のいずれかで、省略した場合は all になる
+func (c *Client) SearchSpaces(ctx context.Context, req SpacesSearchRequest) (*SpacesSearchResponse, *RateLimit, error) {
+	if req.Query == "" {
+		return nil, nil, errors.New("invalid parameter")
+	}
+
+	params := make(map[string]string)
+
+	params["query"] = req.Query
+	if req.State != "" {
+		params["state"] = req.State
+	}
+	setRequestParam(params, "expansions", req.Expansions)
+	setRequestParam(params, "space.fields", req.SpaceFields)
+	setRequestParam(params, "user.fields", req.UserFields)
+
+	var r SpacesSearchResponse
+	rate, err := c.Get(ctx, "spaces/search", params, &r)
+
+	if err != nil {
+		return nil, rate, err
+	}
+
+	return &r, rate, nil
+}
+
 func GetSpaceURL(spaceID string) string {
 	return fmt.Sprintf("https://twitter.com/i/spaces/%s", spaceID)
 }
+
+// ParseSpaceURL はスペースの URL からスペースの ID を取り出す
+func ParseSpaceURL(url string) (string, bool) {
+	m := spaceURLPattern.FindStringSubmatch(url)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
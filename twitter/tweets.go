@@ -17,6 +17,9 @@
 package twitter
 
 import (
+	"context"
+	"errors"
+	"strconv"
 	"time"
 )
 
@@ -27,11 +30,20 @@ type Tweet struct {
 		PollIDs   []string `json:"poll_ids"`
 		MediaKeys []string `json:"media_keys"`
 	} `json:"attachments,omitempty"`
-	AuthorID         *string    `json:"author_id,omitempty"`
-	ConversationID   *string    `json:"conversation_id,omitempty"`
-	CreatedAt        *time.Time `json:"created_at,omitempty"`
-	InReplyToUserID  *string    `json:"in_reply_to_user_id,omitempty"`
-	Lang             *string    `json:"lang,omitempty"`
+	AuthorID       *string    `json:"author_id,omitempty"`
+	ConversationID *string    `json:"conversation_id,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	Entities       *struct {
+		URLs []struct {
+			Start       int    `json:"start"`
+			End         int    `json:"end"`
+			URL         string `json:"url"`
+			ExpandedURL string `json:"expanded_url"`
+			DisplayURL  string `json:"display_url"`
+		} `json:"urls,omitempty"`
+	} `json:"entities,omitempty"`
+	InReplyToUserID  *string `json:"in_reply_to_user_id,omitempty"`
+	Lang             *string `json:"lang,omitempty"`
 	NonPublicMetrics *struct {
 		ImpressionCount   int64 `json:"impression_count"`
 		URLLinkClicks     int64 `json:"url_link_clicks"`
@@ -71,3 +83,45 @@ type Tweet struct {
 		CountryCodes []string `json:"country_codes"`
 	} `json:"withheld,omitempty"`
 }
+
+type UserTweetsRequest struct {
+	UserID      string
+	SinceID     string
+	MaxResults  int
+	TweetFields []string
+}
+
+type UserTweetsResponse struct {
+	Data []Tweet `json:"data"`
+	Meta *struct {
+		NewestID    string `json:"newest_id"`
+		OldestID    string `json:"oldest_id"`
+		ResultCount int    `json:"result_count"`
+	} `json:"meta,omitempty"`
+}
+
+// GetUserTweets はユーザーの最近のツイートを新しい順に取得する
+func (c *Client) GetUserTweets(ctx context.Context, req UserTweetsRequest) (*UserTweetsResponse, *RateLimit, error) {
+	if req.UserID == "" {
+		return nil, nil, errors.New("invalid parameter")
+	}
+
+	params := make(map[string]string)
+
+	if req.SinceID != "" {
+		params["since_id"] = req.SinceID
+	}
+	if req.MaxResults > 0 {
+		params["max_results"] = strconv.Itoa(req.MaxResults)
+	}
+	setRequestParam(params, "tweet.fields", req.TweetFields)
+
+	var r UserTweetsResponse
+	rate, err := c.Get(ctx, "users/"+req.UserID+"/tweets", params, &r)
+
+	if err != nil {
+		return nil, rate, err
+	}
+
+	return &r, rate, nil
+}